@@ -6,24 +6,25 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"net/http/httputil"
-	"os"
+	"strings"
 	"time"
 
+	"github.com/fullstorydev/relay-core/relay/logging"
 	"golang.org/x/net/websocket"
 )
 
-var logger = log.New(os.Stdout, "[catcher] ", 0)
+var logger = logging.For("catcher")
 var ServicePort int = 12346
 
 // Service is an instance of the catcher service. This service is used to test
 // the relay. It exposes an HTTP server that captures the last request it
 // receives and makes it available via the LastRequest() and LastRequestBody()
 // methods. For websocket testing, the /echo endpoint exposes a simple websocket
-// server that echoes back whatever it receives.
+// server that echoes back whatever it receives. The /stream endpoint responds
+// with the same content as the index page, but without a Content-Length.
 type Service struct {
 	lastRequest []byte
 	listener    net.Listener
@@ -35,6 +36,15 @@ func NewService() *Service {
 
 	service.mux = http.NewServeMux()
 	service.mux.Handle("/echo", websocket.Handler(EchoServer))
+	service.mux.HandleFunc("/stream", func(response http.ResponseWriter, request *http.Request) {
+		// Flushing before the body is complete means that the response is
+		// sent without a Content-Length.
+		response.WriteHeader(http.StatusOK)
+		for _, line := range strings.SplitAfter(IndexHTML, "\n") {
+			response.Write([]byte(line))
+			response.(http.Flusher).Flush()
+		}
+	})
 	service.mux.HandleFunc("/favicon.ico", func(response http.ResponseWriter, request *http.Request) {
 		response.WriteHeader(http.StatusNotFound)
 		response.Write([]byte("No favicon"))
@@ -46,7 +56,7 @@ func NewService() *Service {
 		lastRequest, _ := httputil.DumpRequest(request, true)
 		service.lastRequest = lastRequest
 
-		logger.Println("Caught:", request.URL)
+		logger.Info("Caught request", "url", request.URL)
 	})
 
 	return service
@@ -2,14 +2,13 @@
 package main
 
 import (
-	"log"
-	"os"
 	"time"
 
 	"github.com/fullstorydev/relay-core/catcher"
+	"github.com/fullstorydev/relay-core/relay/logging"
 )
 
-var logger = log.New(os.Stdout, "[catcher] ", 0)
+var logger = logging.For("catcher")
 
 func main() {
 	service := catcher.NewService()
@@ -17,7 +16,7 @@ func main() {
 	if err != nil {
 		panic("Could not start catcher service: " + err.Error())
 	}
-	logger.Println("Catcher listening on port", service.Port())
+	logger.Info("Catcher listening", "port", service.Port())
 	for {
 		time.Sleep(100 * time.Minute)
 	}
@@ -3,6 +3,10 @@ module github.com/fullstorydev/relay-core
 go 1.22.3
 
 require (
+	github.com/andybalholm/brotli v1.2.0
+	github.com/klauspost/compress v1.18.0
 	golang.org/x/net v0.23.0
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require golang.org/x/text v0.14.0 // indirect
@@ -0,0 +1,307 @@
+// Package accesslog writes a record of each request handled by the relay, in
+// the Common or Combined Log Format, as JSON, or using a custom template.
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Format is the format in which entries are written.
+type Format int
+
+const (
+	// Common is the Common Log Format used by many web servers.
+	Common Format = iota
+
+	// Combined is the Common Log Format with the referrer and user agent
+	// appended.
+	Combined
+
+	// JSON writes each entry as a JSON object on its own line.
+	JSON
+
+	// Template writes each entry using a text/template, which is executed
+	// with the *Entry as its data.
+	Template
+)
+
+func ParseFormat(value string) (Format, error) {
+	switch value {
+	case "common":
+		return Common, nil
+	case "combined":
+		return Combined, nil
+	case "json":
+		return JSON, nil
+	default:
+		return Combined, fmt.Errorf(`Unknown access log format "%v"; expected "common", "combined", or "json"`, value)
+	}
+}
+
+func (format Format) String() string {
+	switch format {
+	case Common:
+		return "common"
+	case Combined:
+		return "combined"
+	case JSON:
+		return "json"
+	case Template:
+		return "template"
+	default:
+		return "(unknown format)"
+	}
+}
+
+// Entry describes the outcome of a single request.
+type Entry struct {
+	Time      time.Time // When the request was received.
+	RequestID string    // The relay's unique identifier for the request.
+	ClientIP  string
+	Method    string
+	URI       string // The request URI sent by the client, before any rewriting.
+	Proto     string
+	Status    int
+
+	RequestBytes  int64 // Bytes of request body received from the client.
+	ResponseBytes int64 // Bytes of response body sent to the client.
+
+	Duration         time.Duration // Total time taken to handle the request.
+	UpstreamDuration time.Duration // Time spent waiting for the target, including retries.
+	UpstreamHost     string        // The target host the request was relayed to, if any.
+
+	ServicedBy string   // The plugin that responded to the request, or "relay" if it was relayed to the target.
+	Rules      []string // Plugin rules that applied to the request.
+
+	// Attributes that plugins attached to the request for logging, keyed by
+	// name.
+	Attributes map[string]string
+
+	Referer   string
+	UserAgent string
+}
+
+// RelayDuration returns the time the relay itself spent handling the request:
+// the total time, less the time spent waiting for the target.
+func (entry *Entry) RelayDuration() time.Duration {
+	return entry.Duration - entry.UpstreamDuration
+}
+
+// Options configures an access log.
+type Options struct {
+	Format   Format
+	Template string // The template used when Format is Template.
+
+	// The file that entries are written to. If empty, they're written to
+	// stdout.
+	Path string
+
+	// If non-zero, the file is rotated once it would grow beyond MaxSize
+	// bytes, and up to MaxBackups rotated files are kept.
+	MaxSize    int64
+	MaxBackups int
+
+	// If non-nil, entries are written here instead of to Path.
+	Output io.Writer
+}
+
+func NewDefaultOptions() *Options {
+	return &Options{
+		Format:     Combined,
+		MaxBackups: 5,
+	}
+}
+
+// Logger writes access log entries. It's safe for concurrent use.
+type Logger struct {
+	format   Format
+	template *template.Template
+
+	mu     sync.Mutex
+	buffer bytes.Buffer
+	output io.Writer
+	file   *rotatingFile
+}
+
+// Open creates an access log according to the provided options, opening its
+// file if it has one.
+func Open(options *Options) (*Logger, error) {
+	logger := &Logger{format: options.Format}
+
+	if options.Format == Template {
+		text := options.Template
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		tmpl, err := template.New("access-log").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid access log template: %v", err)
+		}
+		logger.template = tmpl
+	}
+
+	switch {
+	case options.Output != nil:
+		logger.output = options.Output
+	case options.Path == "":
+		logger.output = os.Stdout
+	default:
+		file, err := openRotatingFile(options.Path, options.MaxSize, options.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		logger.file = file
+		logger.output = file
+	}
+
+	return logger, nil
+}
+
+// Log writes an entry. Errors writing the entry are returned, but the logger
+// remains usable.
+func (logger *Logger) Log(entry *Entry) error {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+
+	logger.buffer.Reset()
+	if err := logger.formatEntry(&logger.buffer, entry); err != nil {
+		return err
+	}
+	_, err := logger.output.Write(logger.buffer.Bytes())
+	return err
+}
+
+// Close closes the log's file, if it has one.
+func (logger *Logger) Close() error {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if logger.file == nil {
+		return nil
+	}
+	return logger.file.Close()
+}
+
+func (logger *Logger) formatEntry(buffer *bytes.Buffer, entry *Entry) error {
+	switch logger.format {
+	case JSON:
+		return json.NewEncoder(buffer).Encode(jsonEntry{
+			Time:               entry.Time.Format(time.RFC3339Nano),
+			RequestID:          entry.RequestID,
+			ClientIP:           entry.ClientIP,
+			Method:             entry.Method,
+			URI:                entry.URI,
+			Proto:              entry.Proto,
+			Status:             entry.Status,
+			RequestBytes:       entry.RequestBytes,
+			ResponseBytes:      entry.ResponseBytes,
+			DurationMs:         milliseconds(entry.Duration),
+			RelayDurationMs:    milliseconds(entry.RelayDuration()),
+			UpstreamDurationMs: milliseconds(entry.UpstreamDuration),
+			UpstreamHost:       entry.UpstreamHost,
+			ServicedBy:         entry.ServicedBy,
+			Rules:              entry.Rules,
+			Attributes:         entry.Attributes,
+			Referer:            entry.Referer,
+			UserAgent:          entry.UserAgent,
+		})
+	case Template:
+		return logger.template.Execute(buffer, entry)
+	default:
+		writeCommon(buffer, entry)
+		if logger.format == Combined {
+			fmt.Fprintf(buffer, " %v %v", quote(entry.Referer), quote(entry.UserAgent))
+		}
+		buffer.WriteByte('\n')
+		return nil
+	}
+}
+
+type jsonEntry struct {
+	Time               string            `json:"time"`
+	RequestID          string            `json:"request_id,omitempty"`
+	ClientIP           string            `json:"client_ip"`
+	Method             string            `json:"method"`
+	URI                string            `json:"uri"`
+	Proto              string            `json:"proto"`
+	Status             int               `json:"status"`
+	RequestBytes       int64             `json:"request_bytes"`
+	ResponseBytes      int64             `json:"response_bytes"`
+	DurationMs         float64           `json:"duration_ms"`
+	RelayDurationMs    float64           `json:"relay_duration_ms"`
+	UpstreamDurationMs float64           `json:"upstream_duration_ms"`
+	UpstreamHost       string            `json:"upstream_host,omitempty"`
+	ServicedBy         string            `json:"serviced_by,omitempty"`
+	Rules              []string          `json:"rules,omitempty"`
+	Attributes         map[string]string `json:"attributes,omitempty"`
+	Referer            string            `json:"referer,omitempty"`
+	UserAgent          string            `json:"user_agent,omitempty"`
+}
+
+func milliseconds(duration time.Duration) float64 {
+	return float64(duration.Microseconds()) / 1000
+}
+
+// writeCommon writes an entry in the Common Log Format:
+//
+//	127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.1" 200 2326
+func writeCommon(buffer *bytes.Buffer, entry *Entry) {
+	responseBytes := "-"
+	if entry.ResponseBytes > 0 {
+		responseBytes = strconv.FormatInt(entry.ResponseBytes, 10)
+	}
+	fmt.Fprintf(
+		buffer,
+		`%v - - [%v] %v %v %v`,
+		dashIfEmpty(entry.ClientIP),
+		entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		quote(entry.Method+" "+entry.URI+" "+entry.Proto),
+		entry.Status,
+		responseBytes,
+	)
+}
+
+func dashIfEmpty(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+
+// quote quotes a value for the Common or Combined Log Format, using "-" for
+// empty values.
+func quote(value string) string {
+	if value == "" {
+		return `"-"`
+	}
+	return `"` + quoteEscaper.Replace(value) + `"`
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -0,0 +1,130 @@
+package accesslog_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/relay-core/relay/accesslog"
+)
+
+var testEntry = &accesslog.Entry{
+	Time:             time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60)),
+	ClientIP:         "127.0.0.1",
+	Method:           "GET",
+	URI:              "/index.html",
+	Proto:            "HTTP/1.1",
+	Status:           200,
+	ResponseBytes:    2326,
+	Duration:         30 * time.Millisecond,
+	UpstreamDuration: 20 * time.Millisecond,
+	UpstreamHost:     "example.com",
+	ServicedBy:       "relay",
+	Rules:            []string{"paths:^/index"},
+	UserAgent:        `Agent "007"`,
+}
+
+func TestFormats(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		options  accesslog.Options
+		expected string
+	}{
+		{
+			desc:     "Common",
+			options:  accesslog.Options{Format: accesslog.Common},
+			expected: `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.1" 200 2326` + "\n",
+		},
+		{
+			desc:     "Combined",
+			options:  accesslog.Options{Format: accesslog.Combined},
+			expected: `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.1" 200 2326 "-" "Agent \"007\""` + "\n",
+		},
+		{
+			desc:     "JSON",
+			options:  accesslog.Options{Format: accesslog.JSON},
+			expected: `{"time":"2000-10-10T13:55:36-07:00","client_ip":"127.0.0.1","method":"GET","uri":"/index.html","proto":"HTTP/1.1","status":200,"request_bytes":0,"response_bytes":2326,"duration_ms":30,"relay_duration_ms":10,"upstream_duration_ms":20,"upstream_host":"example.com","serviced_by":"relay","rules":["paths:^/index"],"user_agent":"Agent \"007\""}` + "\n",
+		},
+		{
+			desc: "Template",
+			options: accesslog.Options{
+				Format:   accesslog.Template,
+				Template: `{{.Status}} {{.URI}} relay={{.RelayDuration}} upstream={{.UpstreamDuration}} {{.Rules}}`,
+			},
+			expected: "200 /index.html relay=10ms upstream=20ms [paths:^/index]\n",
+		},
+	}
+
+	for _, testCase := range testCases {
+		var buffer bytes.Buffer
+		testCase.options.Output = &buffer
+		logger, err := accesslog.Open(&testCase.options)
+		if err != nil {
+			t.Errorf("Test '%v': Error opening access log: %v", testCase.desc, err)
+			continue
+		}
+		if err := logger.Log(testEntry); err != nil {
+			t.Errorf("Test '%v': Error logging: %v", testCase.desc, err)
+		}
+		if actual := buffer.String(); actual != testCase.expected {
+			t.Errorf("Test '%v': Expected:\n%v\nbut got:\n%v", testCase.desc, testCase.expected, actual)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	logger, err := accesslog.Open(&accesslog.Options{
+		Format:     accesslog.Common,
+		Path:       path,
+		MaxSize:    100,
+		MaxBackups: 2,
+	})
+	if err != nil {
+		t.Fatalf("Error opening access log: %v", err)
+	}
+	defer logger.Close()
+
+	// Each entry is about 70 bytes, so every entry after the first rotates
+	// the file.
+	for i := 0; i < 4; i++ {
+		if err := logger.Log(testEntry); err != nil {
+			t.Errorf("Error logging: %v", err)
+		}
+	}
+
+	for _, name := range []string{"access.log", "access.log.1", "access.log.2"} {
+		contents, err := os.ReadFile(filepath.Join(filepath.Dir(path), name))
+		if err != nil {
+			t.Errorf("Expected %v to exist: %v", name, err)
+			continue
+		}
+		if lines := bytes.Count(contents, []byte("\n")); lines != 1 {
+			t.Errorf("Expected %v to contain 1 entry but got %v", name, lines)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 backups to be kept")
+	}
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
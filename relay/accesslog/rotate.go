@@ -0,0 +1,107 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+)
+
+// rotatingFile is a log file which is renamed once it reaches a maximum size,
+// so that it doesn't grow without bound. Rotated files are named by appending
+// ".1", ".2", and so on to the path, with ".1" being the most recent.
+type rotatingFile struct {
+	path       string
+	maxSize    int64 // If zero, the file is never rotated.
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	file := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (file *rotatingFile) open() error {
+	f, err := os.OpenFile(file.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("Error opening access log: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("Error opening access log: %v", err)
+	}
+	file.file = f
+	file.size = info.Size()
+	return nil
+}
+
+// Write appends to the file, rotating it first if the write would take it
+// past its maximum size. Writes are never split across files.
+func (file *rotatingFile) Write(buffer []byte) (int, error) {
+	if file.maxSize > 0 && file.size > 0 && file.size+int64(len(buffer)) > file.maxSize {
+		if err := file.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := file.file.Write(buffer)
+	file.size += int64(n)
+	return n, err
+}
+
+func (file *rotatingFile) rotate() error {
+	if err := file.file.Close(); err != nil {
+		return err
+	}
+
+	if file.maxBackups <= 0 {
+		os.Remove(file.path)
+	} else {
+		os.Remove(file.backupPath(file.maxBackups))
+		for i := file.maxBackups - 1; i >= 1; i-- {
+			os.Rename(file.backupPath(i), file.backupPath(i+1))
+		}
+		if err := os.Rename(file.path, file.backupPath(1)); err != nil {
+			// Keep writing to the current file rather than losing entries.
+			file.open()
+			return fmt.Errorf("Error rotating access log: %v", err)
+		}
+	}
+
+	return file.open()
+}
+
+func (file *rotatingFile) backupPath(index int) string {
+	return fmt.Sprintf("%v.%v", file.path, index)
+}
+
+func (file *rotatingFile) Close() error {
+	return file.file.Close()
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
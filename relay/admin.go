@@ -0,0 +1,260 @@
+package relay
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/fullstorydev/relay-core/relay/logging"
+	"github.com/fullstorydev/relay-core/relay/metrics"
+	"github.com/fullstorydev/relay-core/relay/traffic"
+	"github.com/fullstorydev/relay-core/relay/version"
+)
+
+// AdminOptions configures the admin service, which exposes diagnostics and
+// controls on a separate listener from the relay itself.
+type AdminOptions struct {
+	Address string // The address to listen on, like "127.0.0.1:9091".
+	Token   string // Requests must include an "Authorization: Bearer <Token>" header.
+
+	// The configuration reported by the admin service. Secrets should already
+	// have been removed using redactConfig.
+	Config map[string]map[string]interface{}
+}
+
+// newAdminHandler creates the handler for the admin service:
+//
+//	/config              The effective configuration, with secrets redacted.
+//	/plugins             The active plugins, in order, and their rules. ?route= selects a plugin route's chain.
+//	/version             The relay's version.
+//	/stats               Runtime statistics.
+//	/log-levels          Log levels. PUT ?subsystem=&level= changes a level; DELETE resets them.
+//	/metrics             Metrics in the Prometheus text format.
+//	/circuit-breakers    The state of the circuit breaker for each target host.
+//	/debug/pprof/        Profiling and goroutine dumps.
+func (service *Service) newAdminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/config", func(response http.ResponseWriter, request *http.Request) {
+		writeJSON(response, *service.adminConfig.Load())
+	})
+
+	mux.HandleFunc("/plugins", func(response http.ResponseWriter, request *http.Request) {
+		type pluginInfo struct {
+			Name  string   `json:"name"`
+			Rules []string `json:"rules,omitempty"`
+		}
+		chain := service.handler.Plugins()
+		if routeName := request.URL.Query().Get("route"); routeName != "" {
+			route := findPluginRoute(service.handler.PluginRoutes(), routeName)
+			if route == nil {
+				http.Error(response, "Unknown plugin route", http.StatusNotFound)
+				return
+			}
+			chain = route.Plugins
+		}
+
+		plugins := []pluginInfo{}
+		for _, plugin := range chain {
+			info := pluginInfo{Name: plugin.Name()}
+			if rulesPlugin, ok := plugin.(traffic.RulesPlugin); ok {
+				info.Rules = rulesPlugin.Rules()
+			}
+			plugins = append(plugins, info)
+		}
+		writeJSON(response, plugins)
+	})
+
+	mux.HandleFunc("/version", func(response http.ResponseWriter, request *http.Request) {
+		writeJSON(response, map[string]string{
+			"version": version.RelayRelease,
+			"go":      runtime.Version(),
+		})
+	})
+
+	mux.HandleFunc("/stats", func(response http.ResponseWriter, request *http.Request) {
+		var memStats runtime.MemStats
+		runtime.ReadMemStats(&memStats)
+		writeJSON(response, map[string]interface{}{
+			"uptime":     time.Since(service.started).Round(time.Second).String(),
+			"goroutines": runtime.NumGoroutine(),
+			"draining":   service.draining.Load(),
+			"memory": map[string]uint64{
+				"alloc":        memStats.Alloc,
+				"sys":          memStats.Sys,
+				"heap_objects": memStats.HeapObjects,
+				"gc_cycles":    uint64(memStats.NumGC),
+			},
+		})
+	})
+
+	mux.HandleFunc("/log-levels", func(response http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			level, err := logging.ParseLevel(request.URL.Query().Get("level"))
+			if err != nil {
+				http.Error(response, err.Error(), http.StatusBadRequest)
+				return
+			}
+			subsystem := request.URL.Query().Get("subsystem")
+			logging.SetLevel(subsystem, level)
+			logger.Info("Changed log level", "subsystem", subsystem, "level", level)
+		case http.MethodDelete:
+			logging.ResetLevels()
+			logger.Info("Reset log levels")
+		default:
+			http.Error(response, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		levels := map[string]string{}
+		for subsystem, level := range logging.Levels() {
+			if subsystem == "" {
+				subsystem = "default"
+			}
+			levels[subsystem] = strings.ToLower(level.String())
+		}
+		writeJSON(response, levels)
+	})
+
+	mux.Handle("/metrics", metrics.DefaultRegistry)
+
+	// Report the state of the circuit breakers.
+	mux.HandleFunc("/circuit-breakers", func(response http.ResponseWriter, request *http.Request) {
+		writeJSON(response, service.handler.CircuitBreakers())
+	})
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return requireBearerToken(service.config.Admin.Token, mux)
+}
+
+// requireBearerToken rejects requests which don't include the provided token.
+// If the token is empty, every request is rejected.
+func requireBearerToken(token string, handler http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	emptyToken := strings.TrimSpace(token) == ""
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		actual := []byte(request.Header.Get("Authorization"))
+		if emptyToken || subtle.ConstantTimeCompare(actual, expected) != 1 {
+			response.Header().Set("WWW-Authenticate", `Bearer realm="relay-admin"`)
+			http.Error(response, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(response, request)
+	})
+}
+
+func findPluginRoute(routes []*traffic.PluginRoute, name string) *traffic.PluginRoute {
+	for _, route := range routes {
+		if route.Name == name {
+			return route
+		}
+	}
+	return nil
+}
+
+func writeJSON(response http.ResponseWriter, value interface{}) {
+	response.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(response)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		logger.Warn("Error writing admin response", "error", err)
+	}
+}
+
+const redacted = "[REDACTED]"
+
+// secretKeyWords are words which, if they appear in a configuration key,
+// indicate that its value is a secret.
+var secretKeyWords = map[string]bool{
+	"credentials": true,
+	"password":    true,
+	"passwd":      true,
+	"secret":      true,
+	"token":       true,
+}
+
+// redactConfig returns a copy of a configuration with secrets replaced. Values
+// are secrets if their keys look like they name a secret, like "token",
+// "client-secret", or "private-key". Passwords in URLs are also redacted.
+func redactConfig(values map[string]map[string]interface{}) map[string]map[string]interface{} {
+	result := make(map[string]map[string]interface{}, len(values))
+	for sectionName, section := range values {
+		result[sectionName] = redactValue("", section).(map[string]interface{})
+	}
+	return result
+}
+
+func redactValue(key string, value interface{}) interface{} {
+	if value != nil && isSecretKey(key) {
+		return redacted
+	}
+
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(typed))
+		for childKey, child := range typed {
+			result[childKey] = redactValue(childKey, child)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(typed))
+		for i, child := range typed {
+			result[i] = redactValue(key, child)
+		}
+		return result
+	case string:
+		if strings.Contains(typed, "://") {
+			if parsed, err := url.Parse(typed); err == nil && parsed.User != nil {
+				return parsed.Redacted()
+			}
+		}
+		return typed
+	default:
+		return typed
+	}
+}
+
+func isSecretKey(key string) bool {
+	words := strings.FieldsFunc(strings.ToLower(key), func(r rune) bool {
+		return r == '-' || r == '_' || r == '.'
+	})
+	for _, word := range words {
+		if secretKeyWords[word] {
+			return true
+		}
+	}
+	// "key" alone, or at the end, names key material; "key-file" names a
+	// path, which isn't secret.
+	return len(words) > 0 && words[len(words)-1] == "key"
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"sort"
 
 	"gopkg.in/yaml.v3"
 )
@@ -67,6 +68,39 @@ func (file *File) LookupRequiredSection(name string) (*Section, error) {
 	return file.sections[name], nil
 }
 
+// SectionNames returns the names of the File's sections, in sorted order.
+func (file *File) SectionNames() []string {
+	names := make([]string, 0, len(file.sections))
+	for name := range file.sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Values returns the contents of the File as plain values, keyed by section
+// name and then by key. YAML values are decoded into maps, slices, and
+// primitives; values which are empty in the YAML source are reported as nil.
+func (file *File) Values() map[string]map[string]interface{} {
+	values := map[string]map[string]interface{}{}
+	for name, section := range file.sections {
+		sectionValues := map[string]interface{}{}
+		for key, nodeOrValue := range section.values {
+			if node, ok := nodeOrValue.(yaml.Node); ok {
+				var value interface{}
+				if err := node.Decode(&value); err != nil {
+					value = node.Value
+				}
+				sectionValues[key] = value
+			} else {
+				sectionValues[key] = nodeOrValue
+			}
+		}
+		values[name] = sectionValues
+	}
+	return values
+}
+
 // Section is a named collection of values usually found within a File.
 // Generally a Section is associated with a plugin or subsystem, and the values
 // it contains represent configuration options for that plugin or subsystem.
@@ -101,24 +135,12 @@ func lookupValueInSection[T any](section *Section, key string) (*T, error) {
 		return nil, nil
 	}
 
+	if isEmptyValue(nodeOrValue) {
+		return nil, nil
+	}
+
 	switch typedNodeOrValue := nodeOrValue.(type) {
 	case yaml.Node:
-		// Detect a value which is completely empty in the YAML source, like
-		// "foo"'s value here:
-		//   foo:
-		// We treat these values as if the key they're associated with ("foo" in
-		// this case) is not present. They'd otherwise be treated as the empty
-		// string, but that would often lead us to generate error messages which
-		// aren't as nice, especially given that we provide default values via
-		// environment variables for many configuration options, so that the
-		// options are always "present". Empty strings can still be used in the
-		// configuration file by surrounding them with explicit quotes.
-		if typedNodeOrValue.Kind == yaml.ScalarNode &&
-			typedNodeOrValue.Style == 0 &&
-			typedNodeOrValue.Value == "" {
-			return nil, nil
-		}
-
 		var value T
 		if err := typedNodeOrValue.Decode(&value); err != nil {
 			return nil, err
@@ -133,6 +155,42 @@ func lookupValueInSection[T any](section *Section, key string) (*T, error) {
 	}
 }
 
+// IsSequence returns true if the value associated with the provided key is a
+// YAML sequence, like a list of targets.
+func IsSequence(section *Section, key string) bool {
+	node, ok := section.values[key].(yaml.Node)
+	return ok && node.Kind == yaml.SequenceNode
+}
+
+// isEmptyValue detects a value which is completely empty in the YAML source,
+// like "foo"'s value here:
+//
+//	foo:
+//
+// We treat these values as if the key they're associated with ("foo" in this
+// case) is not present. They'd otherwise be treated as the empty string, but
+// that would often lead us to generate error messages which aren't as nice,
+// especially given that we provide default values via environment variables
+// for many configuration options, so that the options are always "present".
+// Empty strings can still be used in the configuration file by surrounding
+// them with explicit quotes.
+func isEmptyValue(nodeOrValue interface{}) bool {
+	node, ok := nodeOrValue.(yaml.Node)
+	return ok && node.Kind == yaml.ScalarNode && node.Style == 0 && node.Value == ""
+}
+
+// HasValues returns true if any option in the section has a value. Options
+// whose values are completely empty, which are treated as not present, don't
+// count.
+func (section *Section) HasValues() bool {
+	for _, value := range section.values {
+		if !isEmptyValue(value) {
+			return true
+		}
+	}
+	return false
+}
+
 // LookupOptional returns the value associated with the provided key, if it's
 // present with type T. If it's not present, nil is returned. If it's present
 // but has the wrong type, an error is returned.
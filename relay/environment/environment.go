@@ -3,16 +3,16 @@ package environment
 import (
 	"bufio"
 	"fmt"
-	"log"
 	"os"
 	"regexp"
 	"strings"
 
+	"github.com/fullstorydev/relay-core/relay/logging"
 	"gopkg.in/yaml.v3"
 )
 
 var (
-	logger = log.New(os.Stdout, "[relay] ", 0)
+	logger = logging.For("environment")
 
 	// Matches "${FOO}", "${FOO:BAR}", "$(FOO)", or "$(FOO:BAR)".
 	varSubstitutionRegexp = regexp.MustCompile(`(\\*)((\$\{([^:}]*)(:([^}]*))?})|(\$\(([^:)]*)(:([^)]*))?\)))`)
@@ -121,7 +121,7 @@ func (env *Map) SubstituteVarsIntoYaml(input string) string {
 				}
 
 				// The input is invalid; just return the empty string.
-				logger.Printf(`Invalid value for environment variable '%v': %v`, envVar, value)
+				logger.Warn("Invalid value for environment variable", "variable", envVar, "value", value)
 				return ""
 			}
 		} else {
@@ -215,7 +215,7 @@ func parseDotEnv(filePath string) map[string]string {
 		}
 		separatorIndex := strings.Index(line, "=")
 		if separatorIndex == -1 || separatorIndex == len(line)-1 {
-			logger.Println("Invalid dotenv line:", line)
+			logger.Warn("Invalid dotenv line", "line", line)
 			continue
 		}
 		key := strings.Trim(line[0:separatorIndex], " 	")
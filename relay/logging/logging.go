@@ -0,0 +1,311 @@
+// Package logging provides the structured, leveled logging shared by the relay
+// and its plugins. Each subsystem (like "traffic" or a plugin's name) gets its
+// own logger, and the level of each subsystem can be changed at runtime.
+//
+// Loggers returned by For can be created at any time, including during package
+// initialization; they pick up changes made by Configure and SetLevel
+// immediately.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Format is the format in which log records are written.
+type Format int
+
+const (
+	// Logfmt writes each record as a line of key=value pairs.
+	Logfmt Format = iota
+
+	// JSON writes each record as a JSON object on its own line.
+	JSON
+)
+
+func ParseFormat(value string) (Format, error) {
+	switch value {
+	case "logfmt":
+		return Logfmt, nil
+	case "json":
+		return JSON, nil
+	default:
+		return Logfmt, fmt.Errorf(`Unknown log format "%v"; expected "logfmt" or "json"`, value)
+	}
+}
+
+func (format Format) String() string {
+	switch format {
+	case Logfmt:
+		return "logfmt"
+	case JSON:
+		return "json"
+	default:
+		return "(unknown format)"
+	}
+}
+
+// ParseLevel parses a level name: "debug", "info", "warn", or "error".
+func ParseLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return level, fmt.Errorf(`Unknown log level "%v"; expected "debug", "info", "warn", or "error"`, value)
+	}
+	return level, nil
+}
+
+// Options configures logging.
+type Options struct {
+	Format Format                // The format in which records are written.
+	Level  slog.Level            // The minimum level of records to write, unless overridden for a subsystem.
+	Levels map[string]slog.Level // Per-subsystem overrides of Level.
+	Output io.Writer             // Where records are written. If nil, they're written to stdout.
+}
+
+func NewDefaultOptions() *Options {
+	return &Options{
+		Format: Logfmt,
+		Level:  slog.LevelInfo,
+		Levels: map[string]slog.Level{},
+	}
+}
+
+// state is the current logging configuration. It's replaced wholesale
+// whenever the configuration changes, so loggers can read it without locking.
+type state struct {
+	output       slog.Handler
+	defaultLevel slog.Level
+	levels       map[string]slog.Level
+	debug        bool // If true, every subsystem logs at the debug level.
+}
+
+var (
+	current atomic.Pointer[state]
+
+	// configured holds the levels from the most recent call to Configure, so
+	// that runtime changes can be undone.
+	mu         sync.Mutex
+	configured *Options
+)
+
+func init() {
+	Configure(NewDefaultOptions())
+}
+
+// Configure replaces the logging configuration.
+func Configure(options *Options) {
+	mu.Lock()
+	defer mu.Unlock()
+	configured = options
+
+	output := options.Output
+	if output == nil {
+		output = os.Stdout
+	}
+
+	// Levels are checked by the subsystem loggers, so the output handler
+	// accepts everything.
+	handlerOptions := &slog.HandlerOptions{Level: slog.Level(-100)}
+	var handler slog.Handler
+	if options.Format == JSON {
+		handler = slog.NewJSONHandler(output, handlerOptions)
+	} else {
+		handler = slog.NewTextHandler(output, handlerOptions)
+	}
+
+	levels := make(map[string]slog.Level, len(options.Levels))
+	for subsystem, level := range options.Levels {
+		levels[subsystem] = level
+	}
+	current.Store(&state{
+		output:       handler,
+		defaultLevel: options.Level,
+		levels:       levels,
+	})
+}
+
+// SetLevel changes the level of a subsystem at runtime. An empty subsystem
+// changes the default level.
+func SetLevel(subsystem string, level slog.Level) {
+	update(func(next *state) {
+		if subsystem == "" {
+			next.defaultLevel = level
+		} else {
+			next.levels[subsystem] = level
+		}
+	})
+}
+
+// ResetLevels restores the levels from the most recent call to Configure,
+// undoing any runtime changes.
+func ResetLevels() {
+	mu.Lock()
+	options := configured
+	mu.Unlock()
+	update(func(next *state) {
+		next.defaultLevel = options.Level
+		next.levels = map[string]slog.Level{}
+		for subsystem, level := range options.Levels {
+			next.levels[subsystem] = level
+		}
+		next.debug = false
+	})
+}
+
+// ToggleDebug switches every subsystem to the debug level, or switches them
+// back to their previous levels. It returns true if debug logging is now on.
+func ToggleDebug() bool {
+	var debug bool
+	update(func(next *state) {
+		next.debug = !next.debug
+		debug = next.debug
+	})
+	return debug
+}
+
+// Levels returns the default level (under the key "") and the level of each
+// subsystem that overrides it.
+func Levels() map[string]slog.Level {
+	s := current.Load()
+	levels := map[string]slog.Level{"": s.defaultLevel}
+	for subsystem, level := range s.levels {
+		levels[subsystem] = level
+	}
+	if s.debug {
+		for subsystem := range levels {
+			levels[subsystem] = slog.LevelDebug
+		}
+	}
+	return levels
+}
+
+// FormatLevels describes a set of levels, as returned by Levels, for display.
+func FormatLevels(levels map[string]slog.Level) string {
+	subsystems := make([]string, 0, len(levels))
+	for subsystem := range levels {
+		subsystems = append(subsystems, subsystem)
+	}
+	sort.Strings(subsystems)
+
+	parts := make([]string, 0, len(subsystems))
+	for _, subsystem := range subsystems {
+		name := subsystem
+		if name == "" {
+			name = "default"
+		}
+		parts = append(parts, fmt.Sprintf("%v=%v", name, strings.ToLower(levels[subsystem].String())))
+	}
+	return strings.Join(parts, " ")
+}
+
+func update(change func(next *state)) {
+	mu.Lock()
+	defer mu.Unlock()
+	previous := current.Load()
+	next := *previous
+	next.levels = make(map[string]slog.Level, len(previous.levels))
+	for subsystem, level := range previous.levels {
+		next.levels[subsystem] = level
+	}
+	change(&next)
+	current.Store(&next)
+}
+
+func (s *state) level(subsystem string) slog.Level {
+	if s.debug {
+		return slog.LevelDebug
+	}
+	if level, ok := s.levels[subsystem]; ok {
+		return level
+	}
+	return s.defaultLevel
+}
+
+// For returns the logger for a subsystem. Each record it writes includes the
+// subsystem's name.
+func For(subsystem string) *slog.Logger {
+	return slog.New(&subsystemHandler{subsystem: subsystem})
+}
+
+// subsystemHandler filters records using the subsystem's current level and
+// writes them using the current output handler.
+type subsystemHandler struct {
+	subsystem string
+
+	// Attributes and groups added using WithAttrs and WithGroup, which must be
+	// applied again whenever the output handler changes.
+	derivations []func(slog.Handler) slog.Handler
+
+	cache atomic.Pointer[cachedHandler]
+}
+
+type cachedHandler struct {
+	output  slog.Handler
+	handler slog.Handler
+}
+
+func (h *subsystemHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= current.Load().level(h.subsystem)
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.handler().Handle(ctx, record)
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.derive(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	return h.derive(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+func (h *subsystemHandler) derive(derivation func(slog.Handler) slog.Handler) slog.Handler {
+	derivations := make([]func(slog.Handler) slog.Handler, 0, len(h.derivations)+1)
+	derivations = append(derivations, h.derivations...)
+	return &subsystemHandler{
+		subsystem:   h.subsystem,
+		derivations: append(derivations, derivation),
+	}
+}
+
+// handler returns the output handler with the subsystem's attributes applied.
+func (h *subsystemHandler) handler() slog.Handler {
+	output := current.Load().output
+	if cached := h.cache.Load(); cached != nil && cached.output == output {
+		return cached.handler
+	}
+
+	handler := output.WithAttrs([]slog.Attr{slog.String("subsystem", h.subsystem)})
+	for _, derivation := range h.derivations {
+		handler = derivation(handler)
+	}
+	h.cache.Store(&cachedHandler{output: output, handler: handler})
+	return handler
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -0,0 +1,143 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/fullstorydev/relay-core/relay/logging"
+)
+
+// configure directs logging to a buffer for the duration of a test.
+func configure(t *testing.T, options *logging.Options) *bytes.Buffer {
+	var buffer bytes.Buffer
+	options.Output = &buffer
+	logging.Configure(options)
+	t.Cleanup(func() { logging.Configure(logging.NewDefaultOptions()) })
+	return &buffer
+}
+
+func TestLevels(t *testing.T) {
+	options := logging.NewDefaultOptions()
+	options.Levels["quiet"] = slog.LevelWarn
+	buffer := configure(t, options)
+
+	// Loggers created before a configuration change should observe it.
+	loud := logging.For("loud")
+	quiet := logging.For("quiet")
+
+	loud.Debug("loud debug")
+	loud.Info("loud info")
+	quiet.Info("quiet info")
+	quiet.Warn("quiet warn")
+
+	logging.SetLevel("loud", slog.LevelDebug)
+	logging.SetLevel("", slog.LevelError)
+	loud.Debug("loud debug again")
+	logging.For("other").Warn("other warn")
+
+	logging.ResetLevels()
+	loud.Debug("loud debug after reset")
+	logging.For("other").Info("other info after reset")
+
+	output := buffer.String()
+	for _, expected := range []string{"loud info", "quiet warn", "loud debug again", "other info after reset"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected output to contain %q:\n%v", expected, output)
+		}
+	}
+	for _, unexpected := range []string{`"loud debug"`, "quiet info", "other warn", "loud debug after reset"} {
+		if strings.Contains(output, unexpected) {
+			t.Errorf("Expected output not to contain %q:\n%v", unexpected, output)
+		}
+	}
+	if !strings.Contains(output, `level=WARN msg="quiet warn" subsystem=quiet`) {
+		t.Errorf("Expected logfmt records tagged with the subsystem:\n%v", output)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	options := logging.NewDefaultOptions()
+	options.Format = logging.JSON
+	buffer := configure(t, options)
+
+	logging.For("traffic").With("request", 7).Info("Serviced request", "status", 200)
+
+	var record map[string]any
+	if err := json.Unmarshal(buffer.Bytes(), &record); err != nil {
+		t.Fatalf("Expected a JSON record but got %q: %v", buffer.String(), err)
+	}
+	expected := map[string]any{
+		"level":     "INFO",
+		"msg":       "Serviced request",
+		"subsystem": "traffic",
+		"request":   float64(7),
+		"status":    float64(200),
+	}
+	for key, value := range expected {
+		if record[key] != value {
+			t.Errorf("Expected %v to be %v but got %v", key, value, record[key])
+		}
+	}
+}
+
+func TestToggleDebug(t *testing.T) {
+	options := logging.NewDefaultOptions()
+	options.Levels["quiet"] = slog.LevelError
+	buffer := configure(t, options)
+	logger := logging.For("quiet")
+
+	if !logging.ToggleDebug() {
+		t.Errorf("Expected debug logging to be on")
+	}
+	if levels := logging.FormatLevels(logging.Levels()); levels != "default=debug quiet=debug" {
+		t.Errorf("Unexpected levels while debugging: %v", levels)
+	}
+	logger.Debug("while debugging")
+
+	if logging.ToggleDebug() {
+		t.Errorf("Expected debug logging to be off")
+	}
+	if levels := logging.FormatLevels(logging.Levels()); levels != "default=info quiet=error" {
+		t.Errorf("Unexpected levels after debugging: %v", levels)
+	}
+	logger.Debug("after debugging")
+
+	output := buffer.String()
+	if !strings.Contains(output, "while debugging") || strings.Contains(output, "after debugging") {
+		t.Errorf("Expected only the record logged while debugging:\n%v", output)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if level, err := logging.ParseLevel("warn"); err != nil || level != slog.LevelWarn {
+		t.Errorf("Expected warn level but got %v, %v", level, err)
+	}
+	if _, err := logging.ParseLevel("loud"); err == nil {
+		t.Errorf("Expected an error for an unknown level")
+	}
+	if _, err := logging.ParseFormat("xml"); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -1,29 +1,18 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"io"
-	"log"
 	"os"
-	"time"
+	"os/signal"
+	"syscall"
 
 	"github.com/fullstorydev/relay-core/relay"
-	"github.com/fullstorydev/relay-core/relay/config"
-	"github.com/fullstorydev/relay-core/relay/environment"
+	"github.com/fullstorydev/relay-core/relay/logging"
 	plugin_loader "github.com/fullstorydev/relay-core/relay/traffic/plugin-loader"
 )
 
-var logger = log.New(os.Stdout, "[relay] ", 0)
-
-func readConfigFile(path string) (rawConfigFileBytes []byte, err error) {
-	if path == "-" {
-		rawConfigFileBytes, err = io.ReadAll(os.Stdin)
-		return
-	}
-
-	rawConfigFileBytes, err = os.ReadFile(path)
-	return
-}
+var logger = logging.For("relay")
 
 func main() {
 	// The --config option determines the path to the configuration file. A
@@ -34,54 +23,94 @@ func main() {
 	configFilePath := flag.String("config", "relay.yaml", "Configuration file path")
 	flag.Parse()
 
-	rawConfigFileBytes, err := readConfigFile(*configFilePath)
+	// Read the configuration file, substituting the values of environment
+	// variables into it.
+	configFile, err := relay.LoadConfigFile(*configFilePath)
 	if err != nil {
-		logger.Printf(`Couldn't read configuration file "%s": %v\n`, *configFilePath, err)
+		logger.Error("Couldn't load configuration file", "error", err)
 		os.Exit(1)
 	}
 
-	// Substitute the values of environment variables into the configuration
-	// file. In versions of the relay prior to 0.3, configuration was performed
-	// entirely via environment variables. Environment variable substitution
-	// allows configurations based on those older environment variables to
-	// continue to work and generally increases the flexibility of the
-	// configuration file.
-	envProvider := environment.NewDefaultProvider()
-	env := environment.NewMap(envProvider)
-	configFileString := env.SubstituteVarsIntoYaml(string(rawConfigFileBytes))
-
-	// Parse the configuration file.
-	configFile, err := config.NewFileFromYamlString(configFileString)
+	// Configure logging first, so that it applies to everything logged while
+	// reading the rest of the configuration.
+	loggingOptions, err := relay.ReadLoggingOptions(configFile)
 	if err != nil {
-		logger.Println(err)
+		logger.Error("Invalid logging configuration", "error", err)
 		os.Exit(1)
 	}
+	logging.Configure(loggingOptions)
 
 	config, err := relay.ReadOptions(configFile)
 	if err != nil {
-		logger.Println(err)
+		logger.Error("Invalid configuration", "error", err)
 		os.Exit(1)
 	}
 
 	trafficPlugins, err := plugin_loader.Load(plugin_loader.DefaultPlugins, configFile)
 	if err != nil {
-		logger.Println(err)
+		logger.Error("Couldn't load plugins", "error", err)
 		os.Exit(1)
 	}
 
-	logger.Println("Active plugins:")
 	for _, tp := range trafficPlugins {
-		logger.Println("\tTraffic:", tp.Name())
+		logger.Info("Active plugin", "plugin", tp.Name())
+	}
+
+	pluginRoutes, err := plugin_loader.LoadRoutes(plugin_loader.DefaultPlugins, configFile)
+	if err != nil {
+		logger.Error("Couldn't load plugin routes", "error", err)
+		os.Exit(1)
 	}
 
-	relayService := relay.NewService(config.Relay, trafficPlugins)
+	relayService := relay.NewService(config.Service, config.Relay, trafficPlugins, pluginRoutes)
 	if err := relayService.Start("0.0.0.0", config.Service.Port); err != nil {
-		panic("Could not start catcher service: " + err.Error())
+		logger.Error("Couldn't start relay", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("Relay listening", "port", relayService.Port())
+
+	// SIGUSR1 toggles debug logging for every subsystem, which is handy when
+	// diagnosing a problem in a running relay.
+	debugSignals := make(chan os.Signal, 1)
+	signal.Notify(debugSignals, syscall.SIGUSR1)
+	go func() {
+		for range debugSignals {
+			debug := logging.ToggleDebug()
+			logger.Warn("Toggled debug logging", "debug", debug, "levels", logging.FormatLevels(logging.Levels()))
+		}
+	}()
+
+	// SIGHUP reloads the configuration file, replacing the plugin chain without
+	// interrupting in-flight requests. If the new configuration is invalid,
+	// the relay keeps running with the existing one.
+	reloader := relay.NewReloader(relayService, *configFilePath, plugin_loader.DefaultPlugins)
+	defer reloader.Close()
+	if config.Service.ReloadWatchInterval > 0 && *configFilePath != "-" {
+		reloader.Watch(config.Service.ReloadWatchInterval)
 	}
-	logger.Println("Relay listening on port", relayService.Port())
-	for {
-		time.Sleep(100 * time.Minute)
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
+	go func() {
+		for range reloadSignals {
+			logger.Info("Reloading configuration", "path", *configFilePath)
+			reloader.Reload()
+		}
+	}()
+
+	// Run until we're asked to stop, and then give in-flight requests a chance
+	// to finish before exiting.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+	logger.Info("Draining connections", "signal", sig.String(), "drain-timeout", config.Service.DrainTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Service.DrainTimeout)
+	defer cancel()
+	if err := relayService.Shutdown(ctx); err != nil {
+		logger.Warn("Closed remaining connections after drain timeout", "error", err)
+		return
 	}
+	logger.Info("Relay shut down cleanly")
 }
 
 /*
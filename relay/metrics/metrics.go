@@ -0,0 +1,323 @@
+// Package metrics implements counters, gauges, and histograms which can be
+// exposed to Prometheus using its text exposition format. It's deliberately
+// minimal; it supports just what the relay and its plugins need.
+//
+// Metrics are usually created at package initialization time and registered
+// with DefaultRegistry, which the relay service exposes over HTTP.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultRegistry is the registry used by NewCounter, NewGauge, and
+// NewHistogram.
+var DefaultRegistry = NewRegistry()
+
+// DefaultBuckets are histogram buckets suitable for request latencies, in
+// seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds a set of metrics and writes them in the Prometheus text
+// format.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// NewCounter creates a counter and registers it with DefaultRegistry.
+func NewCounter(name string, help string, labelNames ...string) *Counter {
+	return DefaultRegistry.NewCounter(name, help, labelNames...)
+}
+
+// NewGauge creates a gauge and registers it with DefaultRegistry.
+func NewGauge(name string, help string, labelNames ...string) *Gauge {
+	return DefaultRegistry.NewGauge(name, help, labelNames...)
+}
+
+// NewHistogram creates a histogram and registers it with DefaultRegistry.
+func NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	return DefaultRegistry.NewHistogram(name, help, buckets, labelNames...)
+}
+
+func (registry *Registry) NewCounter(name string, help string, labelNames ...string) *Counter {
+	return &Counter{registry.register(name, help, "counter", nil, labelNames)}
+}
+
+func (registry *Registry) NewGauge(name string, help string, labelNames ...string) *Gauge {
+	return &Gauge{registry.register(name, help, "gauge", nil, labelNames)}
+}
+
+func (registry *Registry) NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	return &Histogram{registry.register(name, help, "histogram", buckets, labelNames)}
+}
+
+func (registry *Registry) register(name string, help string, kind string, buckets []float64, labelNames []string) *family {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if registry.families[name] != nil {
+		panic(fmt.Sprintf("metric %v is already registered", name))
+	}
+	f := &family{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		buckets:    buckets,
+		labelNames: labelNames,
+		series:     map[string]*series{},
+	}
+	registry.families[name] = f
+	return f
+}
+
+// ServeHTTP writes every metric in the registry in the Prometheus text format.
+func (registry *Registry) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	response.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	registry.Write(response)
+}
+
+// Write writes every metric in the registry in the Prometheus text format,
+// sorted by name.
+func (registry *Registry) Write(writer io.Writer) error {
+	registry.mu.Lock()
+	families := make([]*family, 0, len(registry.families))
+	for _, f := range registry.families {
+		families = append(families, f)
+	}
+	registry.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	buffered := bufio.NewWriter(writer)
+	for _, f := range families {
+		f.write(buffered)
+	}
+	return buffered.Flush()
+}
+
+// Counter is a value that only increases.
+type Counter struct {
+	*family
+}
+
+// Inc adds one to the counter for the provided label values.
+func (counter *Counter) Inc(labelValues ...string) {
+	counter.Add(1, labelValues...)
+}
+
+// Add adds a non-negative value to the counter for the provided label values.
+func (counter *Counter) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		panic(fmt.Sprintf("counter %v cannot decrease", counter.name))
+	}
+	counter.update(labelValues, func(s *series) { s.value += value })
+}
+
+// Value returns the current value of the counter for the provided label
+// values.
+func (counter *Counter) Value(labelValues ...string) float64 {
+	var value float64
+	counter.read(labelValues, func(s *series) { value = s.value })
+	return value
+}
+
+// Gauge is a value that can increase and decrease.
+type Gauge struct {
+	*family
+}
+
+// Add adds a value, which may be negative, to the gauge for the provided label
+// values.
+func (gauge *Gauge) Add(value float64, labelValues ...string) {
+	gauge.update(labelValues, func(s *series) { s.value += value })
+}
+
+// Set sets the gauge for the provided label values.
+func (gauge *Gauge) Set(value float64, labelValues ...string) {
+	gauge.update(labelValues, func(s *series) { s.value = value })
+}
+
+// Value returns the current value of the gauge for the provided label values.
+func (gauge *Gauge) Value(labelValues ...string) float64 {
+	var value float64
+	gauge.read(labelValues, func(s *series) { value = s.value })
+	return value
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	*family
+}
+
+// Observe records an observation for the provided label values.
+func (histogram *Histogram) Observe(value float64, labelValues ...string) {
+	histogram.update(labelValues, func(s *series) {
+		for i, upperBound := range histogram.buckets {
+			if value <= upperBound {
+				s.bucketCounts[i]++
+			}
+		}
+		s.count++
+		s.value += value
+	})
+}
+
+// Count returns the number of observations for the provided label values.
+func (histogram *Histogram) Count(labelValues ...string) uint64 {
+	var count uint64
+	histogram.read(labelValues, func(s *series) { count = s.count })
+	return count
+}
+
+// family is a metric and all of its series, one for each combination of label
+// values.
+type family struct {
+	name       string
+	help       string
+	kind       string
+	buckets    []float64
+	labelNames []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues  []string
+	value        float64 // The value of a counter or gauge, or the sum of a histogram's observations.
+	count        uint64
+	bucketCounts []uint64
+}
+
+func (f *family) update(labelValues []string, update func(*series)) {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %v has labels %v but got values %v", f.name, f.labelNames, labelValues))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := f.series[key]
+	if s == nil {
+		s = &series{
+			labelValues:  append([]string{}, labelValues...),
+			bucketCounts: make([]uint64, len(f.buckets)),
+		}
+		f.series[key] = s
+	}
+	update(s)
+}
+
+func (f *family) read(labelValues []string, read func(*series)) {
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s := f.series[key]; s != nil {
+		read(s)
+	}
+}
+
+func (f *family) write(writer *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(writer, "# HELP %v %v\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(writer, "# TYPE %v %v\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bucketLabelNames := append(append([]string{}, f.labelNames...), "le")
+	for _, key := range keys {
+		s := f.series[key]
+		labels := formatLabels(f.labelNames, s.labelValues)
+		if f.kind != "histogram" {
+			fmt.Fprintf(writer, "%v%v %v\n", f.name, labels, formatValue(s.value))
+			continue
+		}
+
+		bucketLabelValues := append(append([]string{}, s.labelValues...), "")
+		for i, upperBound := range f.buckets {
+			bucketLabelValues[len(bucketLabelValues)-1] = formatValue(upperBound)
+			fmt.Fprintf(writer, "%v_bucket%v %v\n", f.name, formatLabels(bucketLabelNames, bucketLabelValues), s.bucketCounts[i])
+		}
+		bucketLabelValues[len(bucketLabelValues)-1] = "+Inf"
+		fmt.Fprintf(writer, "%v_bucket%v %v\n", f.name, formatLabels(bucketLabelNames, bucketLabelValues), s.count)
+		fmt.Fprintf(writer, "%v_sum%v %v\n", f.name, labels, formatValue(s.value))
+		fmt.Fprintf(writer, "%v_count%v %v\n", f.name, labels, s.count)
+	}
+}
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var builder strings.Builder
+	builder.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(name)
+		builder.WriteString(`="`)
+		builder.WriteString(escapeLabelValue(values[i]))
+		builder.WriteByte('"')
+	}
+	builder.WriteByte('}')
+	return builder.String()
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -0,0 +1,89 @@
+package metrics_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/fullstorydev/relay-core/relay/metrics"
+)
+
+func TestTextFormat(t *testing.T) {
+	registry := metrics.NewRegistry()
+	counter := registry.NewCounter("test_requests_total", "Requests.\nSecond line.", "method", "path")
+	gauge := registry.NewGauge("test_connections", "Open connections.")
+	histogram := registry.NewHistogram("test_duration_seconds", "Durations.", []float64{0.1, 1}, "method")
+
+	counter.Inc("GET", "/")
+	counter.Add(2, "GET", "/")
+	counter.Inc("POST", `/"quoted"\`)
+	gauge.Add(3)
+	gauge.Add(-1)
+	histogram.Observe(0.05, "GET")
+	histogram.Observe(0.5, "GET")
+	histogram.Observe(5, "GET")
+
+	expected := `# HELP test_connections Open connections.
+# TYPE test_connections gauge
+test_connections 2
+# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{method="GET",le="0.1"} 1
+test_duration_seconds_bucket{method="GET",le="1"} 2
+test_duration_seconds_bucket{method="GET",le="+Inf"} 3
+test_duration_seconds_sum{method="GET"} 5.55
+test_duration_seconds_count{method="GET"} 3
+# HELP test_requests_total Requests.\nSecond line.
+# TYPE test_requests_total counter
+test_requests_total{method="GET",path="/"} 3
+test_requests_total{method="POST",path="/\"quoted\"\\"} 1
+`
+
+	var buffer bytes.Buffer
+	if err := registry.Write(&buffer); err != nil {
+		t.Errorf("Error writing metrics: %v", err)
+	}
+	if actual := buffer.String(); actual != expected {
+		t.Errorf("Expected metrics:\n%v\nbut got:\n%v", expected, actual)
+	}
+
+	if value := counter.Value("GET", "/"); value != 3 {
+		t.Errorf("Expected counter value 3 but got %v", value)
+	}
+	if value := counter.Value("PUT", "/"); value != 0 {
+		t.Errorf("Expected counter value 0 for missing series but got %v", value)
+	}
+	if count := histogram.Count("GET"); count != 3 {
+		t.Errorf("Expected histogram count 3 but got %v", count)
+	}
+}
+
+func TestDuplicateRegistrationPanics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	registry.NewCounter("test_total", "Test.")
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected duplicate registration to panic")
+		}
+	}()
+	registry.NewGauge("test_total", "Test.")
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -1,11 +1,21 @@
 package relay
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/fullstorydev/relay-core/relay/accesslog"
 	"github.com/fullstorydev/relay-core/relay/config"
+	"github.com/fullstorydev/relay-core/relay/logging"
+	"github.com/fullstorydev/relay-core/relay/tracing"
 	"github.com/fullstorydev/relay-core/relay/traffic"
+	"gopkg.in/yaml.v3"
 )
 
 type Options struct {
@@ -13,6 +23,60 @@ type Options struct {
 	Relay   *traffic.RelayOptions
 }
 
+// ConfigTarget is an entry in a list of relay targets. In YAML, an entry may
+// be either a URL or an object with a 'url' property.
+type ConfigTarget struct {
+	URL    string
+	Backup bool
+}
+
+func (target *ConfigTarget) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		target.URL = node.Value
+		return nil
+	}
+	type plainConfigTarget ConfigTarget
+	return node.Decode((*plainConfigTarget)(target))
+}
+
+type ConfigHealthCheck struct {
+	Path               string
+	Interval           time.Duration
+	Timeout            time.Duration
+	HealthyThreshold   int `yaml:"healthy-threshold"`
+	UnhealthyThreshold int `yaml:"unhealthy-threshold"`
+}
+
+type ConfigRetry struct {
+	MaxAttempts     int `yaml:"max-attempts"`
+	Backoff         *time.Duration
+	MaxBackoff      *time.Duration `yaml:"max-backoff"`
+	StatusCodes     *[]int         `yaml:"status-codes"`
+	Errors          *[]string
+	ReplaySafePaths []string `yaml:"replay-safe-paths"`
+}
+
+type ConfigRoute struct {
+	Name string
+	Path string
+}
+
+type ConfigCertificate struct {
+	CertFile string `yaml:"cert-file"`
+	KeyFile  string `yaml:"key-file"`
+}
+
+type ConfigCircuitBreaker struct {
+	Window           time.Duration
+	MinRequests      int           `yaml:"min-requests"`
+	ErrorThreshold   *float64      `yaml:"error-threshold"`
+	LatencyThreshold time.Duration `yaml:"latency-threshold"`
+	OpenDuration     time.Duration `yaml:"open-duration"`
+	HalfOpenRequests int           `yaml:"half-open-requests"`
+	ResponseStatus   int           `yaml:"response-status"`
+	ResponseBody     *string       `yaml:"response-body"`
+}
+
 func ReadOptions(configFile *config.File) (*Options, error) {
 	options := &Options{
 		Service: NewDefaultServiceOptions(),
@@ -27,31 +91,839 @@ func ReadOptions(configFile *config.File) (*Options, error) {
 	if port, err := config.LookupRequired[int](configSection, "port"); err != nil {
 		return nil, err
 	} else {
-		logger.Printf("Port: %v\n", port)
+		logger.Info("Configured", "port", port)
 		options.Service.Port = port
 	}
 
-	if err := config.ParseRequired(configSection, "target", func(key, value string) error {
-		logger.Printf("Target: %v\n", value)
-		if targetURL, err := url.Parse(value); err != nil {
+	// The target may be either a single URL or a list of targets forming a
+	// pool.
+	if config.IsSequence(configSection, "target") {
+		targets, err := config.LookupRequired[[]ConfigTarget](configSection, "target")
+		if err != nil {
+			return nil, err
+		}
+		if err := readTargets(targets, options.Relay); err != nil {
+			return nil, err
+		}
+	} else if err := config.ParseRequired(configSection, "target", func(key, value string) error {
+		logger.Info("Configured", "target", value)
+		if scheme, host, err := parseTargetURL(value); err != nil {
 			return err
-		} else if targetURL.Scheme == "" || targetURL.Host == "" {
-			return fmt.Errorf("Invalid or relative target URL")
 		} else {
-			options.Relay.TargetScheme = targetURL.Scheme
-			options.Relay.TargetHost = targetURL.Host
+			options.Relay.TargetScheme = scheme
+			options.Relay.TargetHost = host
 			return nil
 		}
 	}); err != nil {
 		return nil, err
 	}
 
+	if http2, err := config.LookupOptional[bool](configSection, "http2"); err != nil {
+		return nil, err
+	} else if http2 != nil {
+		logger.Info("Configured", "http2", *http2)
+		options.Service.HTTP2 = *http2
+	}
+
+	if drainTimeout, err := config.LookupOptional[time.Duration](configSection, "drain-timeout"); err != nil {
+		return nil, err
+	} else if drainTimeout != nil {
+		logger.Info("Configured", "drain-timeout", *drainTimeout)
+		options.Service.DrainTimeout = *drainTimeout
+	}
+
+	if requestTimeout, err := config.LookupOptional[time.Duration](configSection, "request-timeout"); err != nil {
+		return nil, err
+	} else if requestTimeout != nil {
+		if *requestTimeout < 0 {
+			return nil, fmt.Errorf(`Option "request-timeout" in section "relay" must not be negative`)
+		}
+		logger.Info("Configured", "request-timeout", *requestTimeout)
+		options.Relay.RequestTimeout = *requestTimeout
+	}
+
 	if maxBodySize, err := config.LookupOptional[int64](configSection, "max-body-size"); err != nil {
 		return nil, err
 	} else if maxBodySize != nil {
-		logger.Printf("Maximum response body size: %v\n", *maxBodySize)
+		logger.Info("Configured", "max-body-size", *maxBodySize)
 		options.Relay.MaxBodySize = *maxBodySize
 	}
 
+	if maxRequestBodySize, err := config.LookupOptional[int64](configSection, "max-request-body-size"); err != nil {
+		return nil, err
+	} else if maxRequestBodySize != nil {
+		if *maxRequestBodySize <= 0 {
+			return nil, fmt.Errorf(`Option "max-request-body-size" in section "relay" must be positive`)
+		}
+		logger.Info("Configured", "max-request-body-size", *maxRequestBodySize)
+		options.Relay.MaxRequestBodySize = *maxRequestBodySize
+	}
+
+	if maxDecodedRequestBodySize, err := config.LookupOptional[int64](configSection, "max-decoded-request-body-size"); err != nil {
+		return nil, err
+	} else if maxDecodedRequestBodySize != nil {
+		if *maxDecodedRequestBodySize <= 0 {
+			return nil, fmt.Errorf(`Option "max-decoded-request-body-size" in section "relay" must be positive`)
+		}
+		logger.Info("Configured", "max-decoded-request-body-size", *maxDecodedRequestBodySize)
+		options.Relay.MaxDecodedRequestBodySize = *maxDecodedRequestBodySize
+	}
+
+	if err := config.ParseOptional(configSection, "oversize-response-action", func(key string, value string) error {
+		logger.Info("Configured", "oversize-response-action", value)
+		if action, err := traffic.ParseOversizeResponseAction(value); err != nil {
+			return err
+		} else {
+			options.Relay.OversizeResponseAction = action
+			return nil
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := config.ParseOptional(configSection, "routes", func(key string, routes []ConfigRoute) error {
+		for _, route := range routes {
+			if route.Name == "" || route.Path == "" {
+				return fmt.Errorf(`Each route must have a "name" and a "path"`)
+			}
+			path, err := regexp.Compile(route.Path)
+			if err != nil {
+				return fmt.Errorf(`Could not compile path regular expression "%v" for route "%v": %v`, route.Path, route.Name, err)
+			}
+			logger.Info("Configured route", "name", route.Name, "path", path)
+			options.Relay.Routes = append(options.Relay.Routes, &traffic.Route{
+				Name: route.Name,
+				Path: path,
+			})
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := readUpstreamOptions(configFile, options.Relay.Upstream); err != nil {
+		return nil, err
+	}
+
+	if err := readTLSOptions(configFile, options.Service); err != nil {
+		return nil, err
+	}
+
+	if err := readAccessLogOptions(configFile, options.Service); err != nil {
+		return nil, err
+	}
+
+	if err := readTracingOptions(configFile, options.Service); err != nil {
+		return nil, err
+	}
+
+	if err := readAdminOptions(configFile, options.Service); err != nil {
+		return nil, err
+	}
+
+	if err := readReadinessProbeOptions(configFile, options.Service); err != nil {
+		return nil, err
+	}
+
+	if reloadSection := configFile.LookupOptionalSection("reload"); reloadSection != nil {
+		if watchInterval, err := config.LookupOptional[time.Duration](reloadSection, "watch-interval"); err != nil {
+			return nil, err
+		} else if watchInterval != nil {
+			if *watchInterval < 0 {
+				return nil, fmt.Errorf(`Option "watch-interval" in section "reload" must not be negative`)
+			}
+			logger.Info("Configured reload", "watch-interval", *watchInterval)
+			options.Service.ReloadWatchInterval = *watchInterval
+		}
+	}
+
+	if metricsSection := configFile.LookupOptionalSection("metrics"); metricsSection != nil {
+		if port, err := config.LookupOptional[int](metricsSection, "port"); err != nil {
+			return nil, err
+		} else if port != nil {
+			logger.Info("Configured metrics", "port", *port)
+			options.Service.MetricsPort = *port
+		}
+		if public, err := config.LookupOptional[bool](metricsSection, "public"); err != nil {
+			return nil, err
+		} else if public != nil {
+			logger.Info("Configured metrics", "public", *public)
+			options.Service.PublicMetrics = *public
+		}
+	}
+
 	return options, nil
 }
+
+// readAccessLogOptions configures the access log from the top-level
+// 'access-log' section. The access log is enabled if the section sets a
+// format, a template, or a path.
+func readAccessLogOptions(configFile *config.File, options *ServiceOptions) error {
+	configSection := configFile.LookupOptionalSection("access-log")
+	if configSection == nil {
+		return nil
+	}
+
+	accessLog := accesslog.NewDefaultOptions()
+	enabled := false
+
+	if err := config.ParseOptional(configSection, "format", func(key string, value string) error {
+		logger.Info("Configured access log", "format", value)
+		format, err := accesslog.ParseFormat(value)
+		if err != nil {
+			return err
+		}
+		accessLog.Format = format
+		enabled = true
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if template, err := config.LookupOptional[string](configSection, "template"); err != nil {
+		return err
+	} else if template != nil {
+		logger.Info("Configured access log", "template", *template)
+		accessLog.Format = accesslog.Template
+		accessLog.Template = *template
+		enabled = true
+	}
+
+	if path, err := config.LookupOptional[string](configSection, "path"); err != nil {
+		return err
+	} else if path != nil {
+		logger.Info("Configured access log", "path", *path)
+		accessLog.Path = *path
+		enabled = true
+	}
+
+	if maxSize, err := config.LookupOptional[int64](configSection, "max-size"); err != nil {
+		return err
+	} else if maxSize != nil {
+		logger.Info("Configured access log", "max-size", *maxSize)
+		accessLog.MaxSize = *maxSize
+	}
+
+	if maxBackups, err := config.LookupOptional[int](configSection, "max-backups"); err != nil {
+		return err
+	} else if maxBackups != nil {
+		logger.Info("Configured access log", "max-backups", *maxBackups)
+		accessLog.MaxBackups = *maxBackups
+	}
+
+	if enabled {
+		options.AccessLog = accessLog
+	}
+	return nil
+}
+
+// readTracingOptions configures tracing from the top-level 'tracing' section.
+// Tracing is enabled if the section selects an exporter.
+func readTracingOptions(configFile *config.File, options *ServiceOptions) error {
+	configSection := configFile.LookupOptionalSection("tracing")
+	if configSection == nil {
+		return nil
+	}
+
+	exporter, err := config.LookupOptional[string](configSection, "exporter")
+	if err != nil || exporter == nil {
+		return err
+	}
+	logger.Info("Configured tracing", "exporter", *exporter)
+	tracingOptions := tracing.NewDefaultOptions()
+	if tracingOptions.Exporter, err = tracing.ParseExporterKind(*exporter); err != nil {
+		return fmt.Errorf(`Error parsing configuration option "exporter" in section "tracing": %v`, err)
+	}
+
+	if endpoint, err := config.LookupOptional[string](configSection, "endpoint"); err != nil {
+		return err
+	} else if endpoint != nil {
+		logger.Info("Configured tracing", "endpoint", *endpoint)
+		tracingOptions.Endpoint = *endpoint
+	}
+
+	if serviceName, err := config.LookupOptional[string](configSection, "service-name"); err != nil {
+		return err
+	} else if serviceName != nil {
+		logger.Info("Configured tracing", "service-name", *serviceName)
+		tracingOptions.ServiceName = *serviceName
+	}
+
+	if err := config.ParseOptional(configSection, "sample-rate", func(key string, value float64) error {
+		if value < 0 || value > 1 {
+			return fmt.Errorf("Sample rate must be between 0 and 1")
+		}
+		logger.Info("Configured tracing", "sample-rate", value)
+		tracingOptions.SampleRate = value
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if batchInterval, err := config.LookupOptional[time.Duration](configSection, "batch-interval"); err != nil {
+		return err
+	} else if batchInterval != nil {
+		logger.Info("Configured tracing", "batch-interval", *batchInterval)
+		tracingOptions.BatchInterval = *batchInterval
+	}
+
+	options.Tracing = tracingOptions
+	return nil
+}
+
+// readAdminOptions configures the admin service from the top-level 'admin'
+// section. The admin service runs if the section sets an address.
+func readAdminOptions(configFile *config.File, options *ServiceOptions) error {
+	configSection := configFile.LookupOptionalSection("admin")
+	if configSection == nil {
+		return nil
+	}
+
+	address, err := config.LookupOptional[string](configSection, "address")
+	if err != nil || address == nil {
+		return err
+	}
+	logger.Info("Configured admin", "address", *address)
+
+	token, err := config.LookupOptional[string](configSection, "token")
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		return fmt.Errorf(`Option "token" in section "admin" is required when "address" is set`)
+	}
+	if strings.TrimSpace(*token) == "" {
+		return fmt.Errorf(`Option "token" in section "admin" must not be empty`)
+	}
+
+	options.Admin = &AdminOptions{
+		Address: *address,
+		Token:   *token,
+		Config:  redactConfig(configFile.Values()),
+	}
+	return nil
+}
+
+// readReadinessProbeOptions configures the readiness probe from the top-level
+// 'readiness-probe' section. The probe runs if the section sets a path.
+func readReadinessProbeOptions(configFile *config.File, options *ServiceOptions) error {
+	configSection := configFile.LookupOptionalSection("readiness-probe")
+	if configSection == nil {
+		return nil
+	}
+
+	path, err := config.LookupOptional[string](configSection, "path")
+	if err != nil || path == nil {
+		return err
+	}
+	logger.Info("Configured readiness probe", "path", *path)
+	probe := NewDefaultReadinessProbeOptions()
+	probe.Path = *path
+
+	if interval, err := config.LookupOptional[time.Duration](configSection, "interval"); err != nil {
+		return err
+	} else if interval != nil {
+		if *interval <= 0 {
+			return fmt.Errorf(`Option "interval" in section "readiness-probe" must be positive`)
+		}
+		logger.Info("Configured readiness probe", "interval", *interval)
+		probe.Interval = *interval
+	}
+
+	if timeout, err := config.LookupOptional[time.Duration](configSection, "timeout"); err != nil {
+		return err
+	} else if timeout != nil {
+		logger.Info("Configured readiness probe", "timeout", *timeout)
+		probe.Timeout = *timeout
+	}
+
+	options.ReadinessProbe = probe
+	return nil
+}
+
+// ReadLoggingOptions reads the top-level 'logging' section of the configuration
+// file. It's separate from ReadOptions so that logging can be configured before
+// the rest of the configuration is read and logged.
+func ReadLoggingOptions(configFile *config.File) (*logging.Options, error) {
+	options := logging.NewDefaultOptions()
+
+	loggingSection := configFile.LookupOptionalSection("logging")
+	if loggingSection == nil {
+		return options, nil
+	}
+
+	if err := config.ParseOptional(loggingSection, "format", func(key string, value string) error {
+		format, err := logging.ParseFormat(value)
+		if err != nil {
+			return err
+		}
+		options.Format = format
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := config.ParseOptional(loggingSection, "level", func(key string, value string) error {
+		level, err := logging.ParseLevel(value)
+		if err != nil {
+			return err
+		}
+		options.Level = level
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := config.ParseOptional(loggingSection, "levels", func(key string, values map[string]string) error {
+		for subsystem, value := range values {
+			level, err := logging.ParseLevel(value)
+			if err != nil {
+				return fmt.Errorf(`Log level for "%v": %v`, subsystem, err)
+			}
+			options.Levels[subsystem] = level
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return options, nil
+}
+
+// readTargets configures a pool of relay targets. The first primary target is
+// also used as the relay's TargetScheme and TargetHost.
+func readTargets(targets []ConfigTarget, options *traffic.RelayOptions) error {
+	for _, target := range targets {
+		if target.Backup {
+			logger.Info("Configured", "target", target.URL, "backup", true)
+		} else {
+			logger.Info("Configured", "target", target.URL)
+		}
+		scheme, host, err := parseTargetURL(target.URL)
+		if err != nil {
+			return err
+		}
+		options.Targets = append(options.Targets, &traffic.TargetOptions{
+			Host:   host,
+			Scheme: scheme,
+			Backup: target.Backup,
+		})
+		if !target.Backup && options.TargetHost == "" {
+			options.TargetScheme = scheme
+			options.TargetHost = host
+		}
+	}
+
+	if options.TargetHost == "" {
+		return fmt.Errorf("At least one target must not be a backup")
+	}
+	return nil
+}
+
+func parseTargetURL(value string) (string, string, error) {
+	if targetURL, err := url.Parse(value); err != nil {
+		return "", "", err
+	} else if targetURL.Scheme == "" || targetURL.Host == "" {
+		return "", "", fmt.Errorf("Invalid or relative target URL")
+	} else {
+		return targetURL.Scheme, targetURL.Host, nil
+	}
+}
+
+// readUpstreamOptions reads the options for connections to the relay target
+// from the optional 'upstream' section.
+func readUpstreamOptions(configFile *config.File, options *traffic.UpstreamOptions) error {
+	configSection := configFile.LookupOptionalSection("upstream")
+	if configSection == nil {
+		return nil
+	}
+
+	durationOptions := []struct {
+		key   string
+		value *time.Duration
+	}{
+		{"dial-timeout", &options.DialTimeout},
+		{"tls-handshake-timeout", &options.TLSHandshakeTimeout},
+		{"response-header-timeout", &options.ResponseHeaderTimeout},
+		{"timeout", &options.Timeout},
+		{"idle-conn-timeout", &options.IdleConnTimeout},
+	}
+	for _, option := range durationOptions {
+		if value, err := config.LookupOptional[time.Duration](configSection, option.key); err != nil {
+			return err
+		} else if value != nil {
+			logger.Info("Configured upstream", option.key, *value)
+			*option.value = *value
+		}
+	}
+
+	intOptions := []struct {
+		key   string
+		value *int
+	}{
+		{"max-idle-conns", &options.MaxIdleConns},
+		{"max-idle-conns-per-host", &options.MaxIdleConnsPerHost},
+		{"max-conns-per-host", &options.MaxConnsPerHost},
+	}
+	for _, option := range intOptions {
+		if value, err := config.LookupOptional[int](configSection, option.key); err != nil {
+			return err
+		} else if value != nil {
+			logger.Info("Configured upstream", option.key, *value)
+			*option.value = *value
+		}
+	}
+
+	if maxFails, err := config.LookupOptional[int](configSection, "max-fails"); err != nil {
+		return err
+	} else if maxFails != nil {
+		logger.Info("Configured upstream", "max-fails", *maxFails)
+		options.MaxFails = *maxFails
+	}
+
+	if failTimeout, err := config.LookupOptional[time.Duration](configSection, "fail-timeout"); err != nil {
+		return err
+	} else if failTimeout != nil {
+		logger.Info("Configured upstream", "fail-timeout", *failTimeout)
+		options.FailTimeout = *failTimeout
+	}
+
+	if err := config.ParseOptional(configSection, "load-balancing", func(key string, value string) error {
+		logger.Info("Configured upstream", "load-balancing", value)
+		if policy, err := traffic.ParseLoadBalancingPolicy(value); err != nil {
+			return err
+		} else {
+			options.LoadBalancing = policy
+			return nil
+		}
+	}); err != nil {
+		return err
+	}
+
+	if hashHeader, err := config.LookupOptional[string](configSection, "hash-header"); err != nil {
+		return err
+	} else if hashHeader != nil {
+		logger.Info("Configured upstream", "hash-header", *hashHeader)
+		options.HashHeader = *hashHeader
+	}
+
+	if err := config.ParseOptional(configSection, "health-check", func(key string, value ConfigHealthCheck) error {
+		healthCheck := traffic.NewDefaultHealthCheckOptions()
+		if value.Path != "" {
+			healthCheck.Path = value.Path
+		}
+		if value.Interval > 0 {
+			healthCheck.Interval = value.Interval
+		}
+		if value.Timeout > 0 {
+			healthCheck.Timeout = value.Timeout
+		}
+		if value.HealthyThreshold > 0 {
+			healthCheck.HealthyThreshold = value.HealthyThreshold
+		}
+		if value.UnhealthyThreshold > 0 {
+			healthCheck.UnhealthyThreshold = value.UnhealthyThreshold
+		}
+		logger.Info(
+			"Configured upstream health check",
+			"path", healthCheck.Path,
+			"interval", healthCheck.Interval,
+			"timeout", healthCheck.Timeout,
+			"healthy-threshold", healthCheck.HealthyThreshold,
+			"unhealthy-threshold", healthCheck.UnhealthyThreshold,
+		)
+		options.HealthCheck = healthCheck
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := config.ParseOptional(configSection, "http2", func(key string, value string) error {
+		logger.Info("Configured upstream", "http2", value)
+		if mode, err := traffic.ParseUpstreamHTTP2(value); err != nil {
+			return err
+		} else {
+			options.HTTP2 = mode
+			return nil
+		}
+	}); err != nil {
+		return err
+	}
+
+	if err := config.ParseOptional(configSection, "retry", func(key string, value ConfigRetry) error {
+		retry, err := readRetryOptions(value)
+		if err != nil {
+			return err
+		}
+		options.Retry = retry
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := config.ParseOptional(configSection, "circuit-breaker", func(key string, value ConfigCircuitBreaker) error {
+		circuitBreaker, err := readCircuitBreakerOptions(value)
+		if err != nil {
+			return err
+		}
+		options.CircuitBreaker = circuitBreaker
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	tlsConfig := options.TLSConfig.Clone()
+
+	if err := config.ParseOptional(configSection, "ca-file", func(key string, path string) error {
+		logger.Info("Configured upstream", "ca-file", path)
+		pemBytes, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(pemBytes) {
+			return fmt.Errorf(`No certificates found in "%v"`, path)
+		}
+		tlsConfig.RootCAs = certPool
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	certFile, err := config.LookupOptional[string](configSection, "cert-file")
+	if err != nil {
+		return err
+	}
+	keyFile, err := config.LookupOptional[string](configSection, "key-file")
+	if err != nil {
+		return err
+	}
+	if (certFile == nil) != (keyFile == nil) {
+		return fmt.Errorf(`Options "cert-file" and "key-file" in section "upstream" must be used together`)
+	}
+	if certFile != nil {
+		logger.Info("Configured upstream", "cert-file", *certFile)
+		certificate, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			return fmt.Errorf(`Error loading client certificate in section "upstream": %v`, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if serverName, err := config.LookupOptional[string](configSection, "server-name"); err != nil {
+		return err
+	} else if serverName != nil {
+		logger.Info("Configured upstream", "server-name", *serverName)
+		tlsConfig.ServerName = *serverName
+	}
+
+	if err := config.ParseOptional(configSection, "min-tls-version", func(key string, value string) error {
+		logger.Info("Configured upstream", "min-tls-version", value)
+		if version, err := parseTLSVersion(value); err != nil {
+			return err
+		} else {
+			tlsConfig.MinVersion = version
+			return nil
+		}
+	}); err != nil {
+		return err
+	}
+
+	options.TLSConfig = tlsConfig
+	return nil
+}
+
+// readTLSOptions reads the options for TLS termination from the optional 'tls'
+// section. TLS is enabled if at least one certificate is configured.
+func readTLSOptions(configFile *config.File, options *ServiceOptions) error {
+	configSection := configFile.LookupOptionalSection("tls")
+	if configSection == nil {
+		return nil
+	}
+
+	tlsOptions := NewDefaultTLSOptions()
+
+	certFile, err := config.LookupOptional[string](configSection, "cert-file")
+	if err != nil {
+		return err
+	}
+	keyFile, err := config.LookupOptional[string](configSection, "key-file")
+	if err != nil {
+		return err
+	}
+	if (certFile == nil) != (keyFile == nil) {
+		return fmt.Errorf(`Options "cert-file" and "key-file" in section "tls" must be used together`)
+	}
+	if certFile != nil {
+		logger.Info("Configured TLS", "cert-file", *certFile)
+		tlsOptions.Certificates = append(tlsOptions.Certificates, &CertificateFiles{
+			CertFile: *certFile,
+			KeyFile:  *keyFile,
+		})
+	}
+
+	if certificates, err := config.LookupOptional[[]ConfigCertificate](configSection, "certificates"); err != nil {
+		return err
+	} else if certificates != nil {
+		for _, certificate := range *certificates {
+			if certificate.CertFile == "" || certificate.KeyFile == "" {
+				return fmt.Errorf(`Each entry in "certificates" in section "tls" must have a "cert-file" and a "key-file"`)
+			}
+			logger.Info("Configured TLS", "cert-file", certificate.CertFile)
+			tlsOptions.Certificates = append(tlsOptions.Certificates, &CertificateFiles{
+				CertFile: certificate.CertFile,
+				KeyFile:  certificate.KeyFile,
+			})
+		}
+	}
+
+	if err := config.ParseOptional(configSection, "min-version", func(key string, value string) error {
+		logger.Info("Configured TLS", "min-version", value)
+		if version, err := parseTLSVersion(value); err != nil {
+			return err
+		} else {
+			tlsOptions.MinVersion = version
+			return nil
+		}
+	}); err != nil {
+		return err
+	}
+
+	if err := config.ParseOptional(configSection, "cipher-suites", func(key string, value []string) error {
+		logger.Info("Configured TLS", "cipher-suites", value)
+		for _, name := range value {
+			if id, err := parseCipherSuite(name); err != nil {
+				return err
+			} else {
+				tlsOptions.CipherSuites = append(tlsOptions.CipherSuites, id)
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if reloadInterval, err := config.LookupOptional[time.Duration](configSection, "reload-interval"); err != nil {
+		return err
+	} else if reloadInterval != nil {
+		logger.Info("Configured TLS", "reload-interval", *reloadInterval)
+		tlsOptions.ReloadInterval = *reloadInterval
+	}
+
+	if len(tlsOptions.Certificates) > 0 {
+		options.TLS = tlsOptions
+	}
+	return nil
+}
+
+func readRetryOptions(value ConfigRetry) (*traffic.RetryOptions, error) {
+	retry := traffic.NewDefaultRetryOptions()
+	if value.MaxAttempts > 0 {
+		retry.MaxAttempts = value.MaxAttempts
+	}
+	if value.Backoff != nil {
+		retry.Backoff = *value.Backoff
+	}
+	if value.MaxBackoff != nil {
+		retry.MaxBackoff = *value.MaxBackoff
+	}
+	if value.StatusCodes != nil {
+		retry.StatusCodes = *value.StatusCodes
+	}
+	if value.Errors != nil {
+		retry.Errors = 0
+		for _, name := range *value.Errors {
+			if errorClass, err := traffic.ParseRetryErrorClass(name); err != nil {
+				return nil, err
+			} else {
+				retry.Errors |= errorClass
+			}
+		}
+	}
+	for _, path := range value.ReplaySafePaths {
+		if match, err := regexp.Compile(path); err != nil {
+			return nil, fmt.Errorf(`Could not compile replay-safe path regular expression "%v": %v`, path, err)
+		} else {
+			retry.ReplaySafePaths = append(retry.ReplaySafePaths, match)
+		}
+	}
+
+	logger.Info(
+		"Configured upstream retry",
+		"max-attempts", retry.MaxAttempts,
+		"backoff", retry.Backoff,
+		"max-backoff", retry.MaxBackoff,
+		"status-codes", retry.StatusCodes,
+		"replay-safe-paths", retry.ReplaySafePaths,
+	)
+	return retry, nil
+}
+
+func readCircuitBreakerOptions(value ConfigCircuitBreaker) (*traffic.CircuitBreakerOptions, error) {
+	circuitBreaker := traffic.NewDefaultCircuitBreakerOptions()
+	if value.Window > 0 {
+		circuitBreaker.Window = value.Window
+	}
+	if value.MinRequests > 0 {
+		circuitBreaker.MinRequests = value.MinRequests
+	}
+	if value.ErrorThreshold != nil {
+		if *value.ErrorThreshold <= 0 || *value.ErrorThreshold > 1 {
+			return nil, fmt.Errorf("Circuit breaker error-threshold must be greater than 0 and at most 1")
+		}
+		circuitBreaker.ErrorThreshold = *value.ErrorThreshold
+	}
+	circuitBreaker.LatencyThreshold = value.LatencyThreshold
+	if value.OpenDuration > 0 {
+		circuitBreaker.OpenDuration = value.OpenDuration
+	}
+	if value.HalfOpenRequests > 0 {
+		circuitBreaker.HalfOpenRequests = value.HalfOpenRequests
+	}
+	if value.ResponseStatus != 0 {
+		if value.ResponseStatus < 100 || value.ResponseStatus > 599 {
+			return nil, fmt.Errorf("Invalid circuit breaker response-status %v", value.ResponseStatus)
+		}
+		circuitBreaker.ResponseStatus = value.ResponseStatus
+	}
+	if value.ResponseBody != nil {
+		circuitBreaker.ResponseBody = *value.ResponseBody
+	}
+
+	logger.Info(
+		"Configured upstream circuit breaker",
+		"window", circuitBreaker.Window,
+		"min-requests", circuitBreaker.MinRequests,
+		"error-threshold", circuitBreaker.ErrorThreshold,
+		"latency-threshold", circuitBreaker.LatencyThreshold,
+		"open-duration", circuitBreaker.OpenDuration,
+	)
+	return circuitBreaker, nil
+}
+
+func parseTLSVersion(value string) (uint16, error) {
+	switch value {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf(`Unknown TLS version "%v"; expected "1.0", "1.1", "1.2", or "1.3"`, value)
+	}
+}
+
+// parseCipherSuite looks up a cipher suite by its standard name, like
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". Only cipher suites without known
+// security issues are accepted.
+func parseCipherSuite(value string) (uint16, error) {
+	for _, cipherSuite := range tls.CipherSuites() {
+		if cipherSuite.Name == value {
+			return cipherSuite.ID, nil
+		}
+	}
+	return 0, fmt.Errorf(`Unknown or insecure TLS cipher suite "%v"`, value)
+}
@@ -1,6 +1,6 @@
 // This plugin blocks content matching a regular expression from incoming
-// request bodies and headers. See the default 'relay.yaml' for configuration
-// examples.
+// request bodies and headers. Body rules also apply to WebSocket messages sent
+// by the client. See the default 'relay.yaml' for configuration examples.
 //
 // Two kinds of blocking are possible: an Exclude rule totally deletes
 // content, while a Mask rule replaces it with asterisks. Although Exclude rules
@@ -25,14 +25,12 @@ package content_blocker_plugin
 import (
 	"bytes"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
 	"regexp"
-	"strconv"
 
 	"github.com/fullstorydev/relay-core/relay/config"
+	"github.com/fullstorydev/relay-core/relay/metrics"
 	"github.com/fullstorydev/relay-core/relay/traffic"
 	"github.com/fullstorydev/relay-core/relay/version"
 )
@@ -40,9 +38,14 @@ import (
 var (
 	Factory    contentBlockerPluginFactory
 	pluginName = "block-content"
-	logger     = log.New(os.Stdout, fmt.Sprintf("[traffic-%s] ", pluginName), 0)
 
 	PluginVersionHeaderName = "X-Relay-Content-Blocker-Version"
+
+	blockedMatches = metrics.NewCounter(
+		"relay_content_blocker_matches_total",
+		"Matches of content blocking rules, by kind of content (body or header) and mode (mask or exclude).",
+		"content", "mode",
+	)
 )
 
 type ConfigBlockRule struct {
@@ -56,8 +59,8 @@ func (f contentBlockerPluginFactory) Name() string {
 	return pluginName
 }
 
-func (f contentBlockerPluginFactory) New(configSection *config.Section) (traffic.Plugin, error) {
-	plugin := &contentBlockerPlugin{}
+func (f contentBlockerPluginFactory) New(configSection *config.Section, logger *slog.Logger) (traffic.Plugin, error) {
+	plugin := &contentBlockerPlugin{name: configSection.Name}
 
 	addRules := func(contentKind string, rules []ConfigBlockRule) error {
 		blockers := []*contentBlocker{}
@@ -80,10 +83,12 @@ func (f contentBlockerPluginFactory) New(configSection *config.Section) (traffic
 			if regexp, err := regexp.Compile(pattern); err != nil {
 				return fmt.Errorf(`could not compile regular expression "%v": %v`, pattern, err)
 			} else {
-				logger.Printf("Added rule: %s %s content matching \"%s\"", mode, contentKind, regexp)
+				logger.Info("Added rule: block content", "mode", mode, "content", contentKind, "pattern", regexp)
 				blockers = append(blockers, &contentBlocker{
-					mode:   mode,
-					regexp: regexp,
+					contentKind: contentKind,
+					mode:        mode,
+					regexp:      regexp,
+					rule:        fmt.Sprintf("%v:%v %v %v", configSection.Name, mode, contentKind, regexp),
 				})
 			}
 		}
@@ -148,16 +153,31 @@ func (f contentBlockerPluginFactory) New(configSection *config.Section) (traffic
 		return nil, nil
 	}
 
+	// Handling WebSocket messages requires the relay to parse every frame and
+	// keeps the client and target from negotiating extensions like
+	// permessage-deflate, so only opt in if there are body rules to apply.
+	if len(plugin.bodyBlockers) > 0 {
+		return &contentBlockerWebSocketPlugin{plugin}, nil
+	}
 	return plugin, nil
 }
 
 type contentBlockerPlugin struct {
+	name           string
 	bodyBlockers   []*contentBlocker
 	headerBlockers []*contentBlocker
 }
 
 func (plug contentBlockerPlugin) Name() string {
-	return pluginName
+	return plug.name
+}
+
+func (plug contentBlockerPlugin) Rules() []string {
+	rules := []string{}
+	for _, blocker := range append(append([]*contentBlocker{}, plug.bodyBlockers...), plug.headerBlockers...) {
+		rules = append(rules, fmt.Sprintf(`%v %v content matching "%v"`, blocker.mode, blocker.contentKind, blocker.regexp))
+	}
+	return rules
 }
 
 func (plug contentBlockerPlugin) HandleRequest(
@@ -169,10 +189,10 @@ func (plug contentBlockerPlugin) HandleRequest(
 		return false
 	}
 
-	if serviced := plug.blockHeaderContent(response, request); serviced {
+	if serviced := plug.blockHeaderContent(response, request, info); serviced {
 		return true
 	}
-	if serviced := plug.blockBodyContent(response, request); serviced {
+	if serviced := plug.blockBodyContent(response, info); serviced {
 		return true
 	}
 
@@ -182,7 +202,7 @@ func (plug contentBlockerPlugin) HandleRequest(
 	return false
 }
 
-func (plug contentBlockerPlugin) blockHeaderContent(response http.ResponseWriter, request *http.Request) bool {
+func (plug contentBlockerPlugin) blockHeaderContent(response http.ResponseWriter, request *http.Request, info traffic.RequestInfo) bool {
 	if len(plug.headerBlockers) == 0 {
 		return false
 	}
@@ -191,7 +211,7 @@ func (plug contentBlockerPlugin) blockHeaderContent(response http.ResponseWriter
 		for i, headerValue := range headerValues {
 			processedValue := []byte(headerValue)
 			for _, blocker := range plug.headerBlockers {
-				processedValue = blocker.Block(processedValue)
+				processedValue = blocker.Block(processedValue, info)
 			}
 			headerValues[i] = string(processedValue)
 		}
@@ -200,49 +220,56 @@ func (plug contentBlockerPlugin) blockHeaderContent(response http.ResponseWriter
 	return false
 }
 
-func (plug contentBlockerPlugin) blockBodyContent(response http.ResponseWriter, request *http.Request) bool {
-	if len(plug.bodyBlockers) == 0 {
+func (plug contentBlockerPlugin) blockBodyContent(response http.ResponseWriter, info traffic.RequestInfo) bool {
+	body := info.Body
+	if len(plug.bodyBlockers) == 0 || body == nil {
 		return false
 	}
 
-	// Block all websocket connections if we're blocking body content.
-	// TODO(sethf): This is necessary because the current plugin architecture
-	// doesn't give us a hook that would allow us to handle websocket messages,
-	// so we wouldn't be able to actually do any blocking. The safest thing to
-	// do for now is to fail closed. In the short term, this won't do any harm,
-	// because we don't actually need to support websockets, but if that changes
-	// we'll need to revisit this.
-	if len(plug.bodyBlockers) > 0 && request.Header.Get("Upgrade") == "websocket" {
-		logger.Println("Rejecting websocket connection (content blocking is not supported with websockets):", request.URL)
-		http.Error(response, fmt.Sprintf("Blocking unsupported websocket connection: %v", request.URL), 500)
+	originalBody, err := body.Bytes()
+	if err != nil {
+		if traffic.IsRequestBodyTooLarge(err) {
+			http.Error(response, "Request body was too large", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(response, fmt.Sprintf("Error reading request body: %s", err), 500)
+		}
 		return true
 	}
 
-	if request.Body == nil || request.Body == http.NoBody {
-		return false
+	processedBody := originalBody
+	for _, blocker := range plug.bodyBlockers {
+		processedBody = blocker.Block(processedBody, info)
 	}
 
-	processedBody, err := io.ReadAll(request.Body)
-	if err != nil {
-		http.Error(response, fmt.Sprintf("Error reading request body: %s", err), 500)
-		request.Body = http.NoBody
-		return true
+	// Only replace the body if something was blocked, so that the relay can
+	// forward the original bytes otherwise.
+	if !bytes.Equal(processedBody, originalBody) {
+		body.Set(processedBody)
 	}
+	return false
+}
 
-	for _, blocker := range plug.bodyBlockers {
-		processedBody = blocker.Block(processedBody)
+// contentBlockerWebSocketPlugin is a contentBlockerPlugin with body blocking
+// rules, which also apply to WebSocket messages.
+type contentBlockerWebSocketPlugin struct {
+	*contentBlockerPlugin
+}
+
+// HandleWebSocketMessage applies the body blocking rules to WebSocket messages
+// sent by the client, just as they're applied to request bodies.
+func (plug contentBlockerWebSocketPlugin) HandleWebSocketMessage(
+	message *traffic.WebSocketMessage,
+	info traffic.RequestInfo,
+) traffic.WebSocketMessageAction {
+	if message.Direction != traffic.ClientToTarget {
+		return traffic.PassMessage
 	}
 
-	// If the length of the body has changed, we should update the
-	// Content-Length header too.
-	contentLength := int64(len(processedBody))
-	if contentLength != request.ContentLength {
-		request.ContentLength = contentLength
-		request.Header.Set("Content-Length", strconv.FormatInt(contentLength, 10))
+	for _, blocker := range plug.bodyBlockers {
+		message.Data = blocker.Block(message.Data, info)
 	}
 
-	request.Body = io.NopCloser(bytes.NewBuffer(processedBody))
-	return false
+	return traffic.PassMessage
 }
 
 type contentBlockerMode int64
@@ -268,21 +295,37 @@ var maskSymbol = []byte("*")
 // contentBlocker applies a content blocking transformation (either exclude or
 // mask) to content that matches a regular expression.
 type contentBlocker struct {
-	mode   contentBlockerMode
-	regexp *regexp.Regexp
+	contentKind string
+	mode        contentBlockerMode
+	regexp      *regexp.Regexp
+	rule        string // Describes the rule in the access log.
 }
 
-func (b *contentBlocker) Block(content []byte) []byte {
+// Block applies the transformation to the provided content. If anything
+// matched, the rule is recorded for the request's access log entry.
+func (b *contentBlocker) Block(content []byte, info traffic.RequestInfo) []byte {
+	matches := 0
+	var blocked []byte
 	switch b.mode {
 	case maskMode:
-		return b.regexp.ReplaceAllFunc(content, func(matched []byte) []byte {
+		blocked = b.regexp.ReplaceAllFunc(content, func(matched []byte) []byte {
+			matches++
 			return bytes.Repeat(maskSymbol, len(matched))
 		})
 	case excludeMode:
-		return b.regexp.ReplaceAllLiteral(content, []byte{})
+		blocked = b.regexp.ReplaceAllFunc(content, func(matched []byte) []byte {
+			matches++
+			return nil
+		})
 	default:
 		panic(fmt.Errorf("invalid content blocking mode: %v", b.mode))
 	}
+
+	if matches > 0 {
+		blockedMatches.Add(float64(matches), b.contentKind, b.mode.String())
+		info.RecordRule(b.rule)
+	}
+	return blocked
 }
 
 /*
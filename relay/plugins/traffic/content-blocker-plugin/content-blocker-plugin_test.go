@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"testing"
 
@@ -13,6 +14,7 @@ import (
 	"github.com/fullstorydev/relay-core/relay/test"
 	"github.com/fullstorydev/relay-core/relay/traffic"
 	"github.com/fullstorydev/relay-core/relay/version"
+	"golang.org/x/net/websocket"
 )
 
 func TestContentBlocking(t *testing.T) {
@@ -110,6 +112,19 @@ func TestContentBlocking(t *testing.T) {
 				"X-Special-Header": "Some EXCLUDED,  content",
 			},
 		},
+		{
+			desc: "Named instances each apply their own rules",
+			config: `
+block-content/ips:
+    body:
+        - mask: '[0-9]+\.[0-9]+\.[0-9]+\.[0-9]+'
+block-content/words:
+    body:
+        - exclude: '(?i)EXCLUDED'
+`,
+			originalBody: `{ "content": "Excluded IP address = 215.1.0.335." }`,
+			expectedBody: `{ "content": " IP address = ***********." }`,
+		},
 		{
 			desc: "TRAFFIC_EXCLUDE_* and TRAFFIC_MASK_* are supported",
 			config: `block-content:
@@ -139,42 +154,99 @@ func TestContentBlocking(t *testing.T) {
 	}
 }
 
-func TestBlockPluginBlocksWebsockets(t *testing.T) {
+func TestBlockPluginBlocksWebsocketMessages(t *testing.T) {
 	config := `block-content:
                   body:
                     - mask: '[0-9]+\.[0-9]+\.[0-9]+\.[0-9]+'
+                    - exclude: '(?i)EXCLUDED'
     `
 	plugins := []traffic.PluginFactory{
 		content_blocker_plugin.Factory,
 	}
 
 	test.WithCatcherAndRelay(t, config, plugins, func(catcherService *catcher.Service, relayService *relay.Service) {
-		request, err := http.NewRequest(
-			"POST",
-			relayService.HttpUrl(),
-			bytes.NewBufferString(`{ "content": "192.168.0.1" }`),
-		)
+		echoURL := fmt.Sprintf("%v/echo", relayService.WsUrl())
+		ws, err := websocket.Dial(echoURL, "", relayService.HttpUrl())
 		if err != nil {
-			t.Errorf("Error creating request: %v", err)
+			t.Errorf("Error dialing websocket: %v", err)
 			return
 		}
+		defer ws.Close()
 
-		request.Header.Set("Content-Type", "application/json")
-		request.Header.Set("Upgrade", "websocket")
+		maskedSeries := `relay_content_blocker_matches_total{content="body",mode="mask"}`
+		excludedSeries := `relay_content_blocker_matches_total{content="body",mode="exclude"}`
+		maskedBefore := test.MetricValue(maskedSeries)
+		excludedBefore := test.MetricValue(excludedSeries)
 
-		response, err := http.DefaultClient.Do(request)
-		if err != nil {
-			t.Errorf("Error POSTing: %v", err)
+		// The echo server sends back exactly what it receives, so the response
+		// reflects the message as it was relayed to the target.
+		message := `{ "content": "Excluded IP address = 192.168.0.1" }`
+		expected := `{ "content": " IP address = ***********" }`
+		if _, err := ws.Write([]byte(message)); err != nil {
+			t.Errorf("Error writing websocket message: %v", err)
 			return
 		}
-		defer response.Body.Close()
 
-		// This plugin doesn't support websockets, so we should fail closed and
-		// the attempt to establish a websocket connection should fail.
-		if response.StatusCode != 500 {
-			t.Errorf("Expected 500 response: %v", response)
+		var echoed string
+		if err := websocket.Message.Receive(ws, &echoed); err != nil {
+			t.Errorf("Error reading websocket message: %v", err)
 			return
 		}
+		if echoed != expected {
+			t.Errorf("Expected websocket message '%v' but got: %v", expected, echoed)
+		}
+
+		if delta := test.MetricValue(maskedSeries) - maskedBefore; delta != 1 {
+			t.Errorf("Expected one masked match to be counted but got %v", delta)
+		}
+		if delta := test.MetricValue(excludedSeries) - excludedBefore; delta != 1 {
+			t.Errorf("Expected one excluded match to be counted but got %v", delta)
+		}
+	})
+}
+
+func TestHeaderBlockPluginAllowsWebSocketExtensions(t *testing.T) {
+	// Header rules don't apply to WebSocket messages, so the relay shouldn't
+	// need to parse the frames, and the client and target should still be able
+	// to negotiate extensions.
+	extensions := make(chan string, 1)
+	target := httptest.NewServer(websocket.Server{
+		Handshake: func(config *websocket.Config, request *http.Request) error {
+			extensions <- request.Header.Get("Sec-WebSocket-Extensions")
+			return nil
+		},
+		Handler: catcher.EchoServer,
+	})
+	defer target.Close()
+
+	config := fmt.Sprintf(`
+relay:
+  target: %v
+block-content:
+  header:
+    - mask: 'secret'
+`, target.URL)
+	plugins := []traffic.PluginFactory{
+		content_blocker_plugin.Factory,
+	}
+
+	test.WithRelay(t, config, plugins, func(relayService *relay.Service) {
+		wsConfig, err := websocket.NewConfig(fmt.Sprintf("%v/echo", relayService.WsUrl()), relayService.HttpUrl())
+		if err != nil {
+			t.Errorf("Error configuring websocket: %v", err)
+			return
+		}
+		wsConfig.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate")
+		ws, err := websocket.DialConfig(wsConfig)
+		if err != nil {
+			t.Errorf("Error dialing websocket: %v", err)
+			return
+		}
+		defer ws.Close()
+
+		if received := <-extensions; received != "permessage-deflate" {
+			t.Errorf("Expected the target to receive the extensions header but got: '%v'", received)
+		}
 	})
 }
 
@@ -8,19 +8,24 @@ package cookies_plugin
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
+	"sort"
 	"strings"
 
 	"github.com/fullstorydev/relay-core/relay/config"
+	"github.com/fullstorydev/relay-core/relay/metrics"
 	"github.com/fullstorydev/relay-core/relay/traffic"
 )
 
 var (
 	Factory    cookiesPluginFactory
 	pluginName = "cookies"
-	logger     = log.New(os.Stdout, fmt.Sprintf("[traffic-%s] ", pluginName), 0)
+
+	droppedCookies = metrics.NewCounter(
+		"relay_cookies_dropped_total",
+		"Cookies dropped from requests because they weren't in the allowlist.",
+	)
 )
 
 type cookiesPluginFactory struct{}
@@ -29,8 +34,9 @@ func (f cookiesPluginFactory) Name() string {
 	return pluginName
 }
 
-func (f cookiesPluginFactory) New(configSection *config.Section) (traffic.Plugin, error) {
+func (f cookiesPluginFactory) New(configSection *config.Section, logger *slog.Logger) (traffic.Plugin, error) {
 	plugin := &cookiesPlugin{
+		name:      configSection.Name,
 		allowlist: map[string]bool{},
 	}
 
@@ -39,7 +45,7 @@ func (f cookiesPluginFactory) New(configSection *config.Section) (traffic.Plugin
 		"allowlist",
 		func(key string, allowlist []string) error {
 			for _, cookieName := range allowlist {
-				logger.Printf(`Added rule: allowlist cookie "%s"`, cookieName)
+				logger.Info("Added rule: allowlist cookie", "cookie", cookieName)
 				plugin.allowlist[cookieName] = true
 			}
 
@@ -54,7 +60,7 @@ func (f cookiesPluginFactory) New(configSection *config.Section) (traffic.Plugin
 		"TRAFFIC_RELAY_COOKIES",
 		func(key string, allowlist string) error {
 			for _, cookieName := range strings.Split(allowlist, " ") {
-				logger.Printf(`Added rule: allowlist cookie "%s"`, cookieName)
+				logger.Info("Added rule: allowlist cookie", "cookie", cookieName)
 				plugin.allowlist[cookieName] = true
 			}
 
@@ -72,11 +78,21 @@ func (f cookiesPluginFactory) New(configSection *config.Section) (traffic.Plugin
 }
 
 type cookiesPlugin struct {
+	name      string
 	allowlist map[string]bool // The name of cookies that should be relayed.
 }
 
 func (plug cookiesPlugin) Name() string {
-	return pluginName
+	return plug.name
+}
+
+func (plug cookiesPlugin) Rules() []string {
+	rules := []string{}
+	for cookieName := range plug.allowlist {
+		rules = append(rules, fmt.Sprintf(`allowlist cookie "%v"`, cookieName))
+	}
+	sort.Strings(rules)
+	return rules
 }
 
 func (plug cookiesPlugin) HandleRequest(
@@ -99,6 +115,8 @@ func (plug cookiesPlugin) HandleRequest(
 	var cookies []string
 	for _, cookie := range request.Cookies() {
 		if !plug.allowlist[cookie.Name] {
+			droppedCookies.Inc()
+			info.RecordRule(plug.name + ":drop")
 			continue
 		}
 		cookies = append(cookies, cookie.String())
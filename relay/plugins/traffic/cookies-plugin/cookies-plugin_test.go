@@ -122,6 +122,39 @@ func TestRelayedCookies(t *testing.T) {
 	}
 }
 
+func TestDroppedCookiesAreCounted(t *testing.T) {
+	config := `cookies:
+                  allowlist:
+                    - SPECIAL_ID
+    `
+	plugins := []traffic.PluginFactory{
+		cookies_plugin.Factory,
+	}
+
+	test.WithCatcherAndRelay(t, config, plugins, func(catcherService *catcher.Service, relayService *relay.Service) {
+		series := "relay_cookies_dropped_total"
+		before := test.MetricValue(series)
+
+		request, err := http.NewRequest("GET", relayService.HttpUrl(), nil)
+		if err != nil {
+			t.Errorf("Error creating request: %v", err)
+			return
+		}
+		request.Header.Add("Cookie", "SPECIAL_ID=298zf09hf012fh2; token=u32t4o3tb3gg43; foo=bar")
+
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Errorf("Error GETing: %v", err)
+			return
+		}
+		response.Body.Close()
+
+		if delta := test.MetricValue(series) - before; delta != 2 {
+			t.Errorf("Expected 2 dropped cookies to be counted but got %v", delta)
+		}
+	})
+}
+
 /*
 Copyright 2022 FullStory, Inc.
 
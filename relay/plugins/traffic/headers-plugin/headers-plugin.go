@@ -4,9 +4,8 @@ package headers_plugin
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
 
 	"github.com/fullstorydev/relay-core/relay/config"
 	"github.com/fullstorydev/relay-core/relay/traffic"
@@ -15,7 +14,6 @@ import (
 var (
 	Factory    headersPluginFactory
 	pluginName = "headers"
-	logger     = log.New(os.Stdout, fmt.Sprintf("[traffic-%s] ", pluginName), 0)
 )
 
 type headersPluginFactory struct{}
@@ -24,8 +22,8 @@ func (f headersPluginFactory) Name() string {
 	return pluginName
 }
 
-func (f headersPluginFactory) New(configSection *config.Section) (traffic.Plugin, error) {
-	plugin := &headersPlugin{}
+func (f headersPluginFactory) New(configSection *config.Section, logger *slog.Logger) (traffic.Plugin, error) {
+	plugin := &headersPlugin{name: configSection.Name}
 
 	if value, err := config.LookupOptional[string](configSection, "override-origin"); err != nil {
 		return nil, err
@@ -35,17 +33,22 @@ func (f headersPluginFactory) New(configSection *config.Section) (traffic.Plugin
 		plugin.originOverride = *value
 	}
 
-	logger.Printf(`Added rule: override "Origin" header to "%s"`, plugin.originOverride)
+	logger.Info("Added rule: override Origin header", "origin", plugin.originOverride)
 
 	return plugin, nil
 }
 
 type headersPlugin struct {
+	name           string
 	originOverride string
 }
 
 func (plug headersPlugin) Name() string {
-	return pluginName
+	return plug.name
+}
+
+func (plug headersPlugin) Rules() []string {
+	return []string{fmt.Sprintf(`override "Origin" header to "%v"`, plug.originOverride)}
 }
 
 func (plug headersPlugin) HandleRequest(
@@ -61,6 +64,7 @@ func (plug headersPlugin) HandleRequest(
 		"Origin",
 		fmt.Sprintf("%v://%v", request.URL.Scheme, plug.originOverride),
 	)
+	info.RecordRule(plug.name + ":override-origin")
 
 	return false
 }
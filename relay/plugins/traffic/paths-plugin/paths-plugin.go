@@ -5,10 +5,9 @@ package paths_plugin
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
-	"os"
 	"regexp"
 	"strings"
 
@@ -19,7 +18,6 @@ import (
 var (
 	Factory    pathsPluginFactory
 	pluginName = "paths"
-	logger     = log.New(os.Stdout, fmt.Sprintf("[traffic-%s] ", pluginName), 0)
 )
 
 type ConfigRouteRule struct {
@@ -34,8 +32,8 @@ func (f pathsPluginFactory) Name() string {
 	return pluginName
 }
 
-func (f pathsPluginFactory) New(configSection *config.Section) (traffic.Plugin, error) {
-	plugin := &pathsPlugin{}
+func (f pathsPluginFactory) New(configSection *config.Section, logger *slog.Logger) (traffic.Plugin, error) {
+	plugin := &pathsPlugin{name: configSection.Name, logger: logger}
 
 	addRules := func(_ string, rules []ConfigRouteRule) error {
 		for _, rule := range rules {
@@ -56,7 +54,7 @@ func (f pathsPluginFactory) New(configSection *config.Section) (traffic.Plugin,
 			if match, err := regexp.Compile(rule.Path); err != nil {
 				return fmt.Errorf(`Could not compile path regular expression "%v": %v`, rule.Path, err)
 			} else {
-				logger.Printf(`Added rule: route "%s" to %s "%s"`, match, target, replacement)
+				logger.Info("Added rule: route path", "path", match, "target", target, "replacement", replacement)
 				plugin.rules = append(plugin.rules, &pathRule{
 					match:       match,
 					replacement: replacement,
@@ -150,7 +148,9 @@ func (f pathsPluginFactory) addSpecialsRule(
 }
 
 type pathsPlugin struct {
-	rules []*pathRule
+	name   string
+	rules  []*pathRule
+	logger *slog.Logger
 }
 
 type pathRule struct {
@@ -178,7 +178,15 @@ func (target pathRuleTarget) String() string {
 }
 
 func (plug pathsPlugin) Name() string {
-	return pluginName
+	return plug.name
+}
+
+func (plug pathsPlugin) Rules() []string {
+	rules := []string{}
+	for _, rule := range plug.rules {
+		rules = append(rules, fmt.Sprintf(`route "%v" to %v "%v"`, rule.match, rule.target, rule.replacement))
+	}
+	return rules
 }
 
 func (plug pathsPlugin) HandleRequest(
@@ -194,7 +202,11 @@ func (plug pathsPlugin) HandleRequest(
 		switch rule.target {
 		case pathTarget:
 			// If there's a match, replace the requested URL's path.
+			if !rule.match.MatchString(request.URL.Path) {
+				break
+			}
 			request.URL.Path = rule.match.ReplaceAllString(request.URL.Path, rule.replacement)
+			info.RecordRule(fmt.Sprintf("%v:%v", plug.name, rule.match))
 
 		case urlTarget:
 			// If the rule matches the requested URL's path...
@@ -208,12 +220,13 @@ func (plug pathsPlugin) HandleRequest(
 			urlVal := rule.match.ReplaceAllString(request.URL.Path, rule.replacement)
 			newURL, err := url.Parse(urlVal)
 			if err != nil {
-				logger.Printf("Failed to create URL for path rule %v: %v", rule.match, err)
+				plug.logger.Warn("Failed to create URL for path rule", "path", rule.match, "error", err)
 			} else {
 				request.URL.Scheme = newURL.Scheme
 				request.URL.Host = newURL.Host
 				request.Host = newURL.Host
 				request.URL.Path = newURL.Path
+				info.RecordRule(fmt.Sprintf("%v:%v", plug.name, rule.match))
 			}
 		}
 	}
@@ -1,13 +1,13 @@
-// This plugin offers a hook that allows tests to observe the requests received
-// by the relay. In production, this plugin is not useful.
+// This plugin offers hooks that allow tests to observe the requests received
+// by the relay, the responses it receives from the target, and the WebSocket
+// messages it relays, and to observe the plugin's lifecycle. In production, this plugin is not useful.
 
 package test_interceptor_plugin
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
-	"os"
 
 	"github.com/fullstorydev/relay-core/relay/config"
 	"github.com/fullstorydev/relay-core/relay/traffic"
@@ -16,37 +16,86 @@ import (
 var (
 	Factory    testInterceptorPluginFactory
 	pluginName = "test-interceptor"
-	logger     = log.New(os.Stdout, fmt.Sprintf("[traffic-%s] ", pluginName), 0)
 )
 
 type HandleRequestListener func(request *http.Request)
 
+type HandleRequestInfoListener func(request *http.Request, info traffic.RequestInfo)
+
+type HandleResponseListener func(response *http.Response) error
+
+type HandleWebSocketMessageListener func(message *traffic.WebSocketMessage) traffic.WebSocketMessageAction
+
+// HandleLifecycleListener is called with the event ("start", "close", or
+// "reload") each time a lifecycle method is invoked on the plugin. For "reload",
+// previous is the plugin instance being replaced; otherwise it's nil. The
+// error is returned from the lifecycle method.
+type HandleLifecycleListener func(event string, plugin traffic.Plugin, previous traffic.Plugin) error
+
 func NewFactoryWithListener(listener HandleRequestListener) traffic.PluginFactory {
 	return testInterceptorPluginFactory{
 		listener: listener,
 	}
 }
 
+func NewFactoryWithRequestInfoListener(infoListener HandleRequestInfoListener) traffic.PluginFactory {
+	return testInterceptorPluginFactory{
+		infoListener: infoListener,
+	}
+}
+
+func NewFactoryWithResponseListener(responseListener HandleResponseListener) traffic.PluginFactory {
+	return testInterceptorPluginFactory{
+		responseListener: responseListener,
+	}
+}
+
+func NewFactoryWithWebSocketMessageListener(webSocketMessageListener HandleWebSocketMessageListener) traffic.PluginFactory {
+	return testInterceptorPluginFactory{
+		webSocketMessageListener: webSocketMessageListener,
+	}
+}
+
+func NewFactoryWithLifecycleListener(lifecycleListener HandleLifecycleListener) traffic.PluginFactory {
+	return testInterceptorPluginFactory{
+		lifecycleListener: lifecycleListener,
+	}
+}
+
 type testInterceptorPluginFactory struct {
-	listener HandleRequestListener
+	listener                 HandleRequestListener
+	infoListener             HandleRequestInfoListener
+	responseListener         HandleResponseListener
+	webSocketMessageListener HandleWebSocketMessageListener
+	lifecycleListener        HandleLifecycleListener
 }
 
 func (f testInterceptorPluginFactory) Name() string {
 	return pluginName
 }
 
-func (f testInterceptorPluginFactory) New(configFile *config.Section) (traffic.Plugin, error) {
+func (f testInterceptorPluginFactory) New(configFile *config.Section, logger *slog.Logger) (traffic.Plugin, error) {
 	return &testInterceptorPlugin{
-		listener: f.listener,
+		name:                     configFile.Name,
+		listener:                 f.listener,
+		infoListener:             f.infoListener,
+		responseListener:         f.responseListener,
+		webSocketMessageListener: f.webSocketMessageListener,
+		lifecycleListener:        f.lifecycleListener,
 	}, nil
 }
 
 type testInterceptorPlugin struct {
-	listener HandleRequestListener
+	name                     string
+	listener                 HandleRequestListener
+	infoListener             HandleRequestInfoListener
+	responseListener         HandleResponseListener
+	webSocketMessageListener HandleWebSocketMessageListener
+	lifecycleListener        HandleLifecycleListener
 }
 
 func (plug testInterceptorPlugin) Name() string {
-	return pluginName
+	return plug.name
 }
 
 func (plug testInterceptorPlugin) HandleRequest(
@@ -54,10 +103,56 @@ func (plug testInterceptorPlugin) HandleRequest(
 	request *http.Request,
 	info traffic.RequestInfo,
 ) bool {
-	plug.listener(request)
+	if plug.listener != nil {
+		plug.listener(request)
+	}
+	if plug.infoListener != nil {
+		plug.infoListener(request, info)
+	}
 	return false
 }
 
+func (plug testInterceptorPlugin) HandleResponse(
+	response *http.Response,
+	info traffic.RequestInfo,
+) error {
+	if plug.responseListener != nil {
+		return plug.responseListener(response)
+	}
+	return nil
+}
+
+func (plug testInterceptorPlugin) HandleWebSocketMessage(
+	message *traffic.WebSocketMessage,
+	info traffic.RequestInfo,
+) traffic.WebSocketMessageAction {
+	if plug.webSocketMessageListener != nil {
+		return plug.webSocketMessageListener(message)
+	}
+	return traffic.PassMessage
+}
+
+func (plug *testInterceptorPlugin) Start(ctx context.Context) error {
+	if plug.lifecycleListener != nil {
+		return plug.lifecycleListener("start", plug, nil)
+	}
+	return nil
+}
+
+func (plug *testInterceptorPlugin) Close() error {
+	if plug.lifecycleListener != nil {
+		return plug.lifecycleListener("close", plug, nil)
+	}
+	return nil
+}
+
+func (plug *testInterceptorPlugin) Reload(ctx context.Context, previous traffic.Plugin) error {
+	if plug.lifecycleListener != nil {
+		return plug.lifecycleListener("reload", plug, previous)
+	}
+	return nil
+}
+
 /*
 Copyright 2022 FullStory, Inc.
 
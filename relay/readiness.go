@@ -0,0 +1,185 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ReadinessProbeOptions configures a periodic probe of the relay's targets.
+// While the probe is failing, the readiness endpoint reports that the relay
+// isn't ready to receive traffic.
+type ReadinessProbeOptions struct {
+	Path     string        // The path to request from each target.
+	Interval time.Duration // How often to probe the targets.
+	Timeout  time.Duration // How long to wait for each probe to complete.
+}
+
+func NewDefaultReadinessProbeOptions() *ReadinessProbeOptions {
+	return &ReadinessProbeOptions{
+		Path:     "/",
+		Interval: 10 * time.Second,
+		Timeout:  2 * time.Second,
+	}
+}
+
+// readinessCheck is the result of a single check reported by the readiness
+// endpoint.
+type readinessCheck struct {
+	Status  string     `json:"status"`
+	Error   string     `json:"error,omitempty"`
+	Checked *time.Time `json:"checked,omitempty"`
+}
+
+const (
+	checkPass    = "pass"
+	checkFail    = "fail"
+	checkPending = "pending"
+)
+
+// readinessProbe periodically probes the relay's targets and remembers the
+// result of the most recent probe.
+type readinessProbe struct {
+	options *ReadinessProbeOptions
+	probe   func(ctx context.Context, path string) error
+
+	mutex sync.Mutex
+	last  readinessCheck
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+func newReadinessProbe(options *ReadinessProbeOptions, probe func(ctx context.Context, path string) error) *readinessProbe {
+	return &readinessProbe{
+		options: options,
+		probe:   probe,
+		last:    readinessCheck{Status: checkPending},
+		stop:    make(chan struct{}),
+	}
+}
+
+// Start probes the targets immediately, and then once per interval until the
+// probe is closed.
+func (probe *readinessProbe) Start() {
+	probe.done.Add(1)
+	go func() {
+		defer probe.done.Done()
+
+		ticker := time.NewTicker(probe.options.Interval)
+		defer ticker.Stop()
+		for {
+			probe.run()
+			select {
+			case <-probe.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (probe *readinessProbe) run() {
+	ctx, cancel := context.WithTimeout(context.Background(), probe.options.Timeout)
+	defer cancel()
+
+	err := probe.probe(ctx, probe.options.Path)
+	now := time.Now()
+	result := readinessCheck{Status: checkPass, Checked: &now}
+	if err != nil {
+		result.Status = checkFail
+		result.Error = err.Error()
+	}
+
+	probe.mutex.Lock()
+	defer probe.mutex.Unlock()
+	if result.Status != probe.last.Status {
+		if err != nil {
+			logger.Warn("Readiness probe failed", "error", err)
+		} else {
+			logger.Info("Readiness probe succeeded")
+		}
+	}
+	probe.last = result
+}
+
+// Result returns the result of the most recent probe.
+func (probe *readinessProbe) Result() readinessCheck {
+	probe.mutex.Lock()
+	defer probe.mutex.Unlock()
+	return probe.last
+}
+
+func (probe *readinessProbe) Close() {
+	close(probe.stop)
+	probe.done.Wait()
+}
+
+// serveLiveness reports that the relay process is running. It succeeds as long
+// as the relay can serve requests at all, even while it's draining.
+func (service *Service) serveLiveness(response http.ResponseWriter, request *http.Request) {
+	writeHealthJSON(response, http.StatusOK, map[string]string{"status": checkPass})
+}
+
+// serveReadiness reports whether the relay should receive traffic. Each check
+// is reported separately; the relay is ready only if every check passes:
+//
+//	startup   The service has finished starting.
+//	draining  The service isn't shutting down.
+//	target    The most recent readiness probe succeeded, if one is configured.
+func (service *Service) serveReadiness(response http.ResponseWriter, request *http.Request) {
+	checks := map[string]readinessCheck{
+		"startup":  {Status: checkPass},
+		"draining": {Status: checkPass},
+	}
+	if !service.ready.Load() {
+		checks["startup"] = readinessCheck{Status: checkFail, Error: "service is starting"}
+	}
+	if service.draining.Load() {
+		checks["draining"] = readinessCheck{Status: checkFail, Error: "service is draining"}
+	}
+	if service.probe != nil {
+		checks["target"] = service.probe.Result()
+	}
+
+	status, code := checkPass, http.StatusOK
+	for _, check := range checks {
+		if check.Status != checkPass {
+			status, code = checkFail, http.StatusServiceUnavailable
+		}
+	}
+	writeHealthJSON(response, code, struct {
+		Status string                    `json:"status"`
+		Checks map[string]readinessCheck `json:"checks"`
+	}{status, checks})
+}
+
+func writeHealthJSON(response http.ResponseWriter, code int, value interface{}) {
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Cache-Control", "no-store")
+	response.WriteHeader(code)
+	if err := json.NewEncoder(response).Encode(value); err != nil {
+		logger.Warn("Error writing health response", "error", err)
+	}
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -0,0 +1,211 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fullstorydev/relay-core/relay/config"
+	"github.com/fullstorydev/relay-core/relay/environment"
+	"github.com/fullstorydev/relay-core/relay/logging"
+	"github.com/fullstorydev/relay-core/relay/metrics"
+	"github.com/fullstorydev/relay-core/relay/traffic"
+	plugin_loader "github.com/fullstorydev/relay-core/relay/traffic/plugin-loader"
+)
+
+var configReloads = metrics.NewCounter(
+	"relay_config_reloads_total",
+	"Attempts to reload the configuration file, by result: success or failure.",
+	"result",
+)
+
+// LoadConfigFile reads the configuration file at the provided path, substitutes
+// the values of environment variables into it, and parses it. If the path is
+// "-", the configuration file is read from stdin.
+func LoadConfigFile(path string) (*config.File, error) {
+	var rawConfigFileBytes []byte
+	var err error
+	if path == "-" {
+		rawConfigFileBytes, err = io.ReadAll(os.Stdin)
+	} else {
+		rawConfigFileBytes, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read configuration file %v: %v", path, err)
+	}
+
+	// Substitute the values of environment variables into the configuration
+	// file. In versions of the relay prior to 0.3, configuration was performed
+	// entirely via environment variables. Environment variable substitution
+	// allows configurations based on those older environment variables to
+	// continue to work and generally increases the flexibility of the
+	// configuration file.
+	envProvider := environment.NewDefaultProvider()
+	env := environment.NewMap(envProvider)
+	configFileString := env.SubstituteVarsIntoYaml(string(rawConfigFileBytes))
+
+	configFile, err := config.NewFileFromYamlString(configFileString)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse configuration file %v: %v", path, err)
+	}
+	return configFile, nil
+}
+
+// Reloader reloads the configuration file of a running relay service. A reload
+// replaces the service's plugin chains and logging configuration; other options,
+// like the port and the target, take effect only when the relay restarts.
+type Reloader struct {
+	service         *Service
+	path            string
+	pluginFactories []traffic.PluginFactory
+
+	mutex   sync.Mutex
+	modTime time.Time
+	size    int64
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// NewReloader creates a Reloader that reloads the configuration file at the
+// provided path into the service, loading plugins from the provided factories.
+func NewReloader(service *Service, path string, pluginFactories []traffic.PluginFactory) *Reloader {
+	reloader := &Reloader{
+		service:         service,
+		path:            path,
+		pluginFactories: pluginFactories,
+	}
+	if info, err := os.Stat(path); err == nil {
+		reloader.modTime, reloader.size = info.ModTime(), info.Size()
+	}
+	return reloader
+}
+
+// Reload reads the configuration file and, if it's valid, applies it to the
+// service. If the configuration file can't be read or is invalid, an error is
+// returned and the service keeps its existing configuration.
+func (reloader *Reloader) Reload() error {
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+
+	if err := reloader.reload(); err != nil {
+		configReloads.Inc("failure")
+		logger.Error("Couldn't reload configuration; keeping the existing configuration", "path", reloader.path, "error", err)
+		return err
+	}
+	configReloads.Inc("success")
+	logger.Info("Reloaded configuration", "path", reloader.path)
+	return nil
+}
+
+func (reloader *Reloader) reload() error {
+	if reloader.path == "-" {
+		return errors.New("Configuration read from stdin can't be reloaded")
+	}
+
+	if info, err := os.Stat(reloader.path); err == nil {
+		reloader.modTime, reloader.size = info.ModTime(), info.Size()
+	}
+
+	configFile, err := LoadConfigFile(reloader.path)
+	if err != nil {
+		return err
+	}
+
+	// Read the whole configuration, even though only part of it is applied,
+	// so that an invalid configuration file is rejected.
+	loggingOptions, err := ReadLoggingOptions(configFile)
+	if err != nil {
+		return fmt.Errorf("Invalid logging configuration: %v", err)
+	}
+	if _, err := ReadOptions(configFile); err != nil {
+		return fmt.Errorf("Invalid configuration: %v", err)
+	}
+	trafficPlugins, err := plugin_loader.Load(reloader.pluginFactories, configFile)
+	if err != nil {
+		return fmt.Errorf("Couldn't load plugins: %v", err)
+	}
+	pluginRoutes, err := plugin_loader.LoadRoutes(reloader.pluginFactories, configFile)
+	if err != nil {
+		return fmt.Errorf("Couldn't load plugin routes: %v", err)
+	}
+
+	if err := reloader.service.handler.ReplacePlugins(context.Background(), trafficPlugins, pluginRoutes); err != nil {
+		return fmt.Errorf("Couldn't start plugins: %v", err)
+	}
+	logging.Configure(loggingOptions)
+	if reloader.service.config.Admin != nil {
+		adminConfig := redactConfig(configFile.Values())
+		reloader.service.adminConfig.Store(&adminConfig)
+	}
+	for _, trafficPlugin := range trafficPlugins {
+		logger.Info("Active plugin", "plugin", trafficPlugin.Name())
+	}
+	return nil
+}
+
+// Watch checks the configuration file for changes at the provided interval,
+// and reloads it whenever its modification time or size changes.
+func (reloader *Reloader) Watch(interval time.Duration) {
+	reloader.stop = make(chan struct{})
+	reloader.done.Add(1)
+	go func() {
+		defer reloader.done.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-reloader.stop:
+				return
+			case <-ticker.C:
+				if reloader.changed() {
+					reloader.Reload()
+				}
+			}
+		}
+	}()
+}
+
+func (reloader *Reloader) changed() bool {
+	info, err := os.Stat(reloader.path)
+	if err != nil {
+		return false
+	}
+	reloader.mutex.Lock()
+	defer reloader.mutex.Unlock()
+	return !info.ModTime().Equal(reloader.modTime) || info.Size() != reloader.size
+}
+
+// Close stops watching the configuration file, if Watch was called.
+func (reloader *Reloader) Close() {
+	if reloader.stop == nil {
+		return
+	}
+	close(reloader.stop)
+	reloader.done.Wait()
+	reloader.stop = nil
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
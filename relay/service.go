@@ -1,12 +1,20 @@
 package relay
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
+	"sync/atomic"
 	"time"
 
+	"github.com/fullstorydev/relay-core/relay/accesslog"
+	"github.com/fullstorydev/relay-core/relay/metrics"
+	"github.com/fullstorydev/relay-core/relay/tracing"
 	"github.com/fullstorydev/relay-core/relay/traffic"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 var MonitorPath = "/__relay__up__/"
@@ -16,36 +24,116 @@ var MonitorPath = "/__relay__up__/"
 // See also traffic.RelayOptions, which provides options for the actual relay
 // functionality.
 type ServiceOptions struct {
-	Port int // The port that the relay service should listen on.
+	Port  int         // The port that the relay service should listen on.
+	HTTP2 bool        // If true, accept HTTP/2 connections from clients, including unencrypted ("h2c") connections.
+	TLS   *TLSOptions // If non-nil, terminate TLS connections from clients.
+
+	// If non-zero, serve metrics at /metrics on this port.
+	MetricsPort int
+
+	// If true, also serve metrics on the relay's own port at MonitorPath +
+	// "metrics". They're exposed without authentication to every client that
+	// can reach the relay.
+	PublicMetrics bool
+
+	// How long to wait for in-flight requests and WebSocket tunnels to finish
+	// when shutting down.
+	DrainTimeout time.Duration
+
+	// If non-nil, write an access log entry for each request.
+	AccessLog *accesslog.Options
+
+	// If non-nil, trace each request.
+	Tracing *tracing.Options
+
+	// If non-nil, run the admin service.
+	Admin *AdminOptions
+
+	// If non-zero, check the configuration file for changes this often and
+	// reload it when it changes.
+	ReloadWatchInterval time.Duration
+
+	// If non-nil, periodically probe the targets and report that the relay
+	// isn't ready while the probe fails.
+	ReadinessProbe *ReadinessProbeOptions
 }
 
 func NewDefaultServiceOptions() *ServiceOptions {
-	return &ServiceOptions{}
+	return &ServiceOptions{
+		DrainTimeout: 30 * time.Second,
+	}
 }
 
 // Service implements the relay service, exposing both the traffic handler and
 // the monitoring page.
 type Service struct {
-	listener net.Listener
-	mux      *http.ServeMux
+	config       *ServiceOptions
+	listener     net.Listener
+	server       *http.Server
+	mux          *http.ServeMux
+	metrics      *http.Server
+	handler      *traffic.Handler
+	certificates *certificateStore
+	accessLog    *accesslog.Logger
+	tracer       *tracing.Tracer
+	admin        *http.Server
+	adminAddress string
+	adminConfig  atomic.Pointer[map[string]map[string]interface{}]
+	started      time.Time
+	probe        *readinessProbe
+	ready        atomic.Bool
+	draining     atomic.Bool
 }
 
-func NewService(relayConfig *traffic.RelayOptions, trafficPlugins []traffic.Plugin) *Service {
+func NewService(serviceConfig *ServiceOptions, relayConfig *traffic.RelayOptions, trafficPlugins []traffic.Plugin, pluginRoutes []*traffic.PluginRoute) *Service {
+	service := &Service{
+		config: serviceConfig,
+	}
+	if serviceConfig.Admin != nil {
+		service.adminConfig.Store(&serviceConfig.Admin.Config)
+	}
 	mux := http.NewServeMux()
 
-	// Write a simple page for monitoring.
-	// TODO add a control/monitoring service
+	// Write a simple page for monitoring. While the service is shutting down,
+	// report that it's unavailable so that no new traffic is sent to it. More
+	// detailed diagnostics are available from the admin service.
 	mux.HandleFunc(MonitorPath, func(response http.ResponseWriter, request *http.Request) {
 		response.Header().Add("Content-Type", "text/html")
+		if service.draining.Load() {
+			response.WriteHeader(http.StatusServiceUnavailable)
+			response.Write([]byte("<html><body>Draining</body></html>"))
+			return
+		}
 		response.Write([]byte("<html><body>Up</body></html>"))
 	})
 
+	// Report liveness and readiness separately, for load balancers and
+	// orchestrators that distinguish them.
+	mux.HandleFunc(MonitorPath+"live", service.serveLiveness)
+	mux.HandleFunc(MonitorPath+"ready", service.serveReadiness)
+
 	// Set up the traffic handler.
-	mux.Handle("/", traffic.NewHandler(relayConfig, trafficPlugins))
+	handler := traffic.NewHandler(relayConfig, trafficPlugins, pluginRoutes)
+	mux.Handle("/", handler)
 
-	return &Service{
-		mux: mux,
+	// Report how many circuit breakers are in each state, for monitoring. The
+	// hosts, which may be internal, are only reported by the admin service.
+	mux.HandleFunc(MonitorPath+"circuit-breakers", func(response http.ResponseWriter, request *http.Request) {
+		summary := map[string]int{"closed": 0, "open": 0, "half-open": 0}
+		for _, status := range handler.CircuitBreakers() {
+			summary[status.State]++
+		}
+		writeHealthJSON(response, http.StatusOK, summary)
+	})
+
+	// Expose metrics for Prometheus, if they're public.
+	if serviceConfig.PublicMetrics {
+		mux.Handle(MonitorPath+"metrics", metrics.DefaultRegistry)
 	}
+
+	service.mux = mux
+	service.handler = handler
+	return service
 }
 
 func (service *Service) Address() string {
@@ -56,13 +144,69 @@ func (service *Service) Address() string {
 }
 
 func (service *Service) Close() error {
+	service.ready.Store(false)
+	if service.probe != nil {
+		service.probe.Close()
+		service.probe = nil
+	}
+	service.handler.Close()
+	if service.metrics != nil {
+		service.metrics.Close()
+	}
+	if service.certificates != nil {
+		service.certificates.Close()
+	}
+	if service.admin != nil {
+		service.admin.Close()
+	}
+	if service.accessLog != nil {
+		service.accessLog.Close()
+	}
+	if service.tracer != nil {
+		service.tracer.Close()
+	}
 	if service.listener == nil {
 		return nil
 	}
 	return service.listener.Close()
 }
 
+// Shutdown gracefully shuts down the service. It stops accepting connections,
+// reports that the service is unavailable on the monitoring page, and waits
+// for in-flight requests and WebSocket tunnels to finish. If the context ends
+// first, the remaining connections are closed forcibly and the context's error
+// is returned. The service is closed once Shutdown returns.
+func (service *Service) Shutdown(ctx context.Context) error {
+	service.draining.Store(true)
+	defer service.Close()
+
+	if service.server == nil {
+		return nil
+	}
+
+	err := service.server.Shutdown(ctx)
+	if err != nil {
+		service.server.Close()
+	}
+	if tunnelErr := service.handler.DrainTunnels(ctx); err == nil {
+		err = tunnelErr
+	}
+	return err
+}
+
+// AdminUrl returns the URL of the admin service, or the empty string if it
+// isn't running.
+func (service *Service) AdminUrl() string {
+	if service.adminAddress == "" {
+		return ""
+	}
+	return fmt.Sprintf("http://%v", service.adminAddress)
+}
+
 func (service *Service) HttpUrl() string {
+	if service.config.TLS != nil {
+		return fmt.Sprintf("https://%v", service.Address())
+	}
 	return fmt.Sprintf("http://%v", service.Address())
 }
 
@@ -73,30 +217,142 @@ func (service *Service) Port() int {
 	return service.listener.Addr().(*net.TCPAddr).Port
 }
 
-func (service *Service) Start(host string, port int) error {
+// Start starts the plugins, opens the service's listeners, and starts serving.
+// If anything fails, the service is closed and the error is returned.
+func (service *Service) Start(host string, port int) (err error) {
+	service.started = time.Now()
+	if err := service.handler.StartPlugins(context.Background()); err != nil {
+		return fmt.Errorf("Couldn't start plugins: %v", err)
+	}
+
+	// Undo everything, including starting the plugins, if a later step fails.
+	var adminListener, metricsListener net.Listener
+	defer func() {
+		if err == nil {
+			return
+		}
+		if adminListener != nil {
+			adminListener.Close()
+		}
+		if metricsListener != nil {
+			metricsListener.Close()
+		}
+		service.Close()
+	}()
+
 	address := fmt.Sprintf("%v:%v", host, port)
 	server := &http.Server{
 		Addr:              address,
 		Handler:           service.mux,
 		ReadHeaderTimeout: 2 * time.Second,
 	}
+	if service.config.HTTP2 {
+		// Accept HTTP/2 without TLS, either via prior knowledge or via an
+		// HTTP/1.1 upgrade.
+		server.Handler = h2c.NewHandler(service.mux, &http2.Server{})
+	}
+
+	var tlsConfig *tls.Config
+	if service.config.TLS != nil {
+		certificates, err := newCertificateStore(service.config.TLS)
+		if err != nil {
+			return err
+		}
+		tlsConfig = certificates.TLSConfig()
+		if service.config.HTTP2 {
+			// Negotiate HTTP/2 via ALPN.
+			if err := http2.ConfigureServer(server, &http2.Server{}); err != nil {
+				return err
+			}
+			tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+		} else {
+			tlsConfig.NextProtos = []string{"http/1.1"}
+		}
+		service.certificates = certificates
+	}
+
+	if service.config.AccessLog != nil {
+		accessLog, err := accesslog.Open(service.config.AccessLog)
+		if err != nil {
+			return err
+		}
+		service.accessLog = accessLog
+		service.handler.SetAccessLog(accessLog)
+	}
+
+	if service.config.Tracing != nil {
+		service.tracer = tracing.NewTracer(service.config.Tracing, tracing.NewExporter(service.config.Tracing))
+		service.handler.SetTracer(service.tracer)
+	}
+
+	// Open every listener before serving on any of them, so that a failure
+	// leaves nothing running.
 	listener, err := net.Listen("tcp", address)
 	if err != nil {
 		return err
 	}
 	service.listener = listener
 
+	if service.config.Admin != nil {
+		if adminListener, err = net.Listen("tcp", service.config.Admin.Address); err != nil {
+			return err
+		}
+	}
+
+	if service.config.MetricsPort != 0 {
+		if metricsListener, err = net.Listen("tcp", fmt.Sprintf("%v:%v", host, service.config.MetricsPort)); err != nil {
+			return err
+		}
+	}
+
+	var serviceListener net.Listener = TcpKeepAliveListener{
+		listener.(*net.TCPListener),
+	}
+	if tlsConfig != nil {
+		serviceListener = tls.NewListener(serviceListener, tlsConfig)
+		service.certificates.StartReloading()
+	}
+
+	service.server = server
 	go func() {
-		server.Serve(
-			TcpKeepAliveListener{
-				listener.(*net.TCPListener),
-			},
-		)
+		server.Serve(serviceListener)
 	}()
 
+	if adminListener != nil {
+		service.adminAddress = adminListener.Addr().String()
+		service.admin = &http.Server{
+			Handler:           service.newAdminHandler(),
+			ReadHeaderTimeout: 2 * time.Second,
+		}
+		go func() {
+			service.admin.Serve(adminListener)
+		}()
+	}
+
+	if metricsListener != nil {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.DefaultRegistry)
+		service.metrics = &http.Server{
+			Handler:           metricsMux,
+			ReadHeaderTimeout: 2 * time.Second,
+		}
+		go func() {
+			service.metrics.Serve(metricsListener)
+		}()
+	}
+
+	if service.config.ReadinessProbe != nil {
+		service.probe = newReadinessProbe(service.config.ReadinessProbe, service.handler.ProbeTargets)
+		service.probe.Start()
+	}
+
+	service.ready.Store(true)
 	return nil
 }
 
 func (service *Service) WsUrl() string {
+	if service.config.TLS != nil {
+		return fmt.Sprintf("wss://%v", service.Address())
+	}
 	return fmt.Sprintf("ws://%v", service.Address())
 }
@@ -0,0 +1,32 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/fullstorydev/relay-core/relay/metrics"
+)
+
+// MetricValue returns the current value of a metric series in the default
+// metrics registry, identified as it appears in the Prometheus text format
+// (e.g. `relay_requests_total{method="GET",status="200",route="other"}`). If
+// the series doesn't exist, it returns zero.
+//
+// Metrics accumulate across tests, so tests should compare the value before
+// and after the action they're testing.
+func MetricValue(series string) float64 {
+	var buffer bytes.Buffer
+	metrics.DefaultRegistry.Write(&buffer)
+
+	scanner := bufio.NewScanner(&buffer)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, found := strings.CutPrefix(line, series+" "); found {
+			parsed, _ := strconv.ParseFloat(value, 64)
+			return parsed
+		}
+	}
+	return 0
+}
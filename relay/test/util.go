@@ -36,9 +36,41 @@ func WithCatcherAndRelay(
 	}
 
 	relaySection := configFile.GetOrAddSection("relay")
-	relaySection.Set("port", 0)
 	relaySection.Set("target", catcherService.HttpUrl())
 
+	withRelay(t, configFile, pluginFactories, func(relayService *relay.Service) {
+		action(catcherService, relayService)
+	})
+}
+
+// WithRelay is like WithCatcherAndRelay, but it doesn't start a catcher
+// service. The provided configuration must specify the relay target. This is
+// useful for tests that need a target which behaves differently than the
+// catcher, like an httptest.Server.
+func WithRelay(
+	t *testing.T,
+	configYaml string,
+	pluginFactories []traffic.PluginFactory,
+	action func(relayService *relay.Service),
+) {
+	configFile, err := config.NewFileFromYamlString(configYaml)
+	if err != nil {
+		t.Errorf("Error parsing configuration YAML: %v", err)
+		return
+	}
+
+	withRelay(t, configFile, pluginFactories, action)
+}
+
+func withRelay(
+	t *testing.T,
+	configFile *config.File,
+	pluginFactories []traffic.PluginFactory,
+	action func(relayService *relay.Service),
+) {
+	relaySection := configFile.GetOrAddSection("relay")
+	relaySection.Set("port", 0)
+
 	relayService, err := setupRelay(configFile, pluginFactories)
 	if err != nil {
 		t.Errorf("Error setting up relay: %v", err)
@@ -51,7 +83,7 @@ func WithCatcherAndRelay(
 	}
 	defer relayService.Close()
 
-	action(catcherService, relayService)
+	action(relayService)
 }
 
 func setupRelay(
@@ -68,5 +100,10 @@ func setupRelay(
 		return nil, err
 	}
 
-	return relay.NewService(options.Relay, trafficPlugins), nil
+	pluginRoutes, err := plugin_loader.LoadRoutes(pluginFactories, configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return relay.NewService(options.Service, options.Relay, trafficPlugins, pluginRoutes), nil
 }
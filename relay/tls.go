@@ -0,0 +1,227 @@
+package relay
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TLSOptions configures TLS termination for the relay service.
+type TLSOptions struct {
+	Certificates   []*CertificateFiles // The certificates to serve. The first is the default; others are selected by SNI.
+	MinVersion     uint16              // The minimum TLS version to accept.
+	CipherSuites   []uint16            // The cipher suites to offer for TLS 1.2 and below. If empty, Go's defaults are used.
+	ReloadInterval time.Duration       // How often to check the certificate files for changes. Zero disables reloading.
+}
+
+// CertificateFiles identifies a certificate and its private key on disk.
+type CertificateFiles struct {
+	CertFile string
+	KeyFile  string
+}
+
+func NewDefaultTLSOptions() *TLSOptions {
+	return &TLSOptions{
+		MinVersion:     tls.VersionTLS12,
+		ReloadInterval: 10 * time.Second,
+	}
+}
+
+// certificateStore holds the certificates served by the relay, selects among
+// them by SNI, and reloads them when the files on disk change.
+type certificateStore struct {
+	options *TLSOptions
+
+	certificates atomic.Pointer[certificateSet]
+	fileStates   []fileState
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// certificateSet is an immutable set of loaded certificates.
+type certificateSet struct {
+	defaultCertificate *tls.Certificate
+	byName             map[string]*tls.Certificate
+}
+
+// fileState records what a file looked like when it was last loaded.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func newCertificateStore(options *TLSOptions) (*certificateStore, error) {
+	store := &certificateStore{options: options}
+	certificates, fileStates, err := loadCertificates(options.Certificates)
+	if err != nil {
+		return nil, err
+	}
+	store.certificates.Store(certificates)
+	store.fileStates = fileStates
+	return store, nil
+}
+
+// TLSConfig returns a TLS configuration which serves the store's certificates.
+func (store *certificateStore) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     store.options.MinVersion,
+		CipherSuites:   store.options.CipherSuites,
+		GetCertificate: store.GetCertificate,
+	}
+}
+
+// GetCertificate selects a certificate matching the server name the client
+// requested, falling back to the default certificate.
+func (store *certificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certificates := store.certificates.Load()
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if certificate := certificates.byName[name]; certificate != nil {
+		return certificate, nil
+	}
+	if _, parent, found := strings.Cut(name, "."); found {
+		if certificate := certificates.byName["*."+parent]; certificate != nil {
+			return certificate, nil
+		}
+	}
+	return certificates.defaultCertificate, nil
+}
+
+// StartReloading begins checking the certificate files for changes.
+func (store *certificateStore) StartReloading() {
+	if store.options.ReloadInterval <= 0 {
+		return
+	}
+
+	store.stop = make(chan struct{})
+	store.done.Add(1)
+	go func() {
+		defer store.done.Done()
+
+		ticker := time.NewTicker(store.options.ReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-store.stop:
+				return
+			case <-ticker.C:
+				store.reloadIfChanged()
+			}
+		}
+	}()
+}
+
+// Close stops checking for changes.
+func (store *certificateStore) Close() {
+	if store.stop == nil {
+		return
+	}
+	close(store.stop)
+	store.done.Wait()
+	store.stop = nil
+}
+
+func (store *certificateStore) reloadIfChanged() {
+	changed := false
+	for i, path := range certificatePaths(store.options.Certificates) {
+		if state, err := statFile(path); err != nil || state != store.fileStates[i] {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return
+	}
+
+	certificates, fileStates, err := loadCertificates(store.options.Certificates)
+	if err != nil {
+		// The files may be in the middle of being replaced; keep serving the
+		// current certificates and try again later.
+		logger.Error("Error reloading TLS certificates", "error", err)
+		return
+	}
+	store.certificates.Store(certificates)
+	store.fileStates = fileStates
+	logger.Info("Reloaded TLS certificates")
+}
+
+func loadCertificates(files []*CertificateFiles) (*certificateSet, []fileState, error) {
+	// Record the state of the files before reading them, so that changes made
+	// while they're being read trigger another reload.
+	var fileStates []fileState
+	for _, path := range certificatePaths(files) {
+		state, err := statFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		fileStates = append(fileStates, state)
+	}
+
+	certificates := &certificateSet{byName: map[string]*tls.Certificate{}}
+	for _, certificateFiles := range files {
+		certificate, err := tls.LoadX509KeyPair(certificateFiles.CertFile, certificateFiles.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf(`Error loading certificate "%v": %v`, certificateFiles.CertFile, err)
+		}
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			return nil, nil, fmt.Errorf(`Error parsing certificate "%v": %v`, certificateFiles.CertFile, err)
+		}
+		certificate.Leaf = leaf
+
+		if certificates.defaultCertificate == nil {
+			certificates.defaultCertificate = &certificate
+		}
+		names := leaf.DNSNames
+		if len(names) == 0 && leaf.Subject.CommonName != "" {
+			names = []string{leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if certificates.byName[name] == nil {
+				certificates.byName[name] = &certificate
+			}
+		}
+	}
+	return certificates, fileStates, nil
+}
+
+func certificatePaths(files []*CertificateFiles) []string {
+	var paths []string
+	for _, certificateFiles := range files {
+		paths = append(paths, certificateFiles.CertFile, certificateFiles.KeyFile)
+	}
+	return paths
+}
+
+func statFile(path string) (fileState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
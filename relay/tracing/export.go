@@ -0,0 +1,311 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fullstorydev/relay-core/relay/logging"
+	"github.com/fullstorydev/relay-core/relay/metrics"
+)
+
+var (
+	logger = logging.For("tracing")
+
+	droppedSpans = metrics.NewCounter(
+		"relay_tracing_spans_dropped_total",
+		"Finished spans dropped because the export queue was full.",
+	)
+)
+
+// ExporterKind selects where finished spans are sent.
+type ExporterKind int
+
+const (
+	// StdoutExporter writes each span as a JSON object on its own line.
+	StdoutExporter ExporterKind = iota
+
+	// OTLPExporter sends spans to an OTLP/HTTP endpoint, like an
+	// OpenTelemetry collector, using the OTLP JSON encoding.
+	OTLPExporter
+)
+
+func ParseExporterKind(value string) (ExporterKind, error) {
+	switch value {
+	case "stdout":
+		return StdoutExporter, nil
+	case "otlp":
+		return OTLPExporter, nil
+	default:
+		return StdoutExporter, fmt.Errorf(`Unknown trace exporter "%v"; expected "stdout" or "otlp"`, value)
+	}
+}
+
+func (kind ExporterKind) String() string {
+	switch kind {
+	case StdoutExporter:
+		return "stdout"
+	case OTLPExporter:
+		return "otlp"
+	default:
+		return "(unknown exporter)"
+	}
+}
+
+// Options configures tracing.
+type Options struct {
+	Exporter    ExporterKind
+	Endpoint    string  // The OTLP/HTTP traces endpoint, like "http://localhost:4318/v1/traces".
+	ServiceName string  // Reported as the service.name resource attribute.
+	SampleRate  float64 // The fraction of new traces to sample. Incoming traces keep their sampling decision.
+
+	BatchSize     int           // Spans are exported once this many have ended...
+	BatchInterval time.Duration // ...or this often, whichever comes first.
+	QueueSize     int           // Spans that end while this many are waiting for export are dropped.
+
+	// If non-nil, the stdout exporter writes here instead of to stdout.
+	Output io.Writer
+}
+
+func NewDefaultOptions() *Options {
+	return &Options{
+		Exporter:      StdoutExporter,
+		Endpoint:      "http://localhost:4318/v1/traces",
+		ServiceName:   "relay",
+		SampleRate:    1,
+		BatchSize:     512,
+		BatchInterval: 5 * time.Second,
+		QueueSize:     2048,
+	}
+}
+
+// Exporter sends finished spans somewhere. Export is never called
+// concurrently.
+type Exporter interface {
+	Export(spans []*SpanData) error
+}
+
+// NewExporter creates the exporter selected by the provided options.
+func NewExporter(options *Options) Exporter {
+	switch options.Exporter {
+	case OTLPExporter:
+		return &otlpExporter{
+			endpoint:    options.Endpoint,
+			serviceName: options.ServiceName,
+			client:      &http.Client{Timeout: 10 * time.Second},
+		}
+	default:
+		output := options.Output
+		if output == nil {
+			output = os.Stdout
+		}
+		return &stdoutExporter{output: output, serviceName: options.ServiceName}
+	}
+}
+
+type stdoutExporter struct {
+	mu          sync.Mutex
+	output      io.Writer
+	serviceName string
+}
+
+type stdoutSpan struct {
+	Service      string         `json:"service"`
+	Name         string         `json:"name"`
+	Kind         string         `json:"kind"`
+	TraceID      string         `json:"trace_id"`
+	SpanID       string         `json:"span_id"`
+	ParentSpanID string         `json:"parent_span_id,omitempty"`
+	Start        string         `json:"start"`
+	DurationMs   float64        `json:"duration_ms"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Error        string         `json:"error,omitempty"`
+}
+
+func (exporter *stdoutExporter) Export(spans []*SpanData) error {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, span := range spans {
+		record := stdoutSpan{
+			Service:    exporter.serviceName,
+			Name:       span.Name,
+			Kind:       span.Kind.String(),
+			TraceID:    span.Context.TraceID.String(),
+			SpanID:     span.Context.SpanID.String(),
+			Start:      span.Start.Format(time.RFC3339Nano),
+			DurationMs: float64(span.End.Sub(span.Start).Microseconds()) / 1000,
+			Error:      span.Error,
+		}
+		if span.ParentSpanID.IsValid() {
+			record.ParentSpanID = span.ParentSpanID.String()
+		}
+		if len(span.Attributes) > 0 {
+			record.Attributes = map[string]any{}
+			for _, attribute := range span.Attributes {
+				record.Attributes[attribute.Key] = attribute.Value
+			}
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	_, err := exporter.output.Write(buffer.Bytes())
+	return err
+}
+
+type otlpExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+}
+
+// The OTLP JSON encoding. IDs are hex-encoded, and 64-bit integers are
+// encoded as strings.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"` // 2 means error.
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+func newOTLPValue(value any) otlpValue {
+	switch v := value.(type) {
+	case string:
+		return otlpValue{StringValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	default:
+		s := fmt.Sprint(v)
+		return otlpValue{StringValue: &s}
+	}
+}
+
+func (exporter *otlpExporter) Export(spans []*SpanData) error {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.Context.TraceID.String(),
+			SpanID:            span.Context.SpanID.String(),
+			Name:              span.Name,
+			Kind:              int(span.Kind),
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		}
+		if span.ParentSpanID.IsValid() {
+			s.ParentSpanID = span.ParentSpanID.String()
+		}
+		for _, attribute := range span.Attributes {
+			s.Attributes = append(s.Attributes, otlpAttribute{attribute.Key, newOTLPValue(attribute.Value)})
+		}
+		if span.Error != "" {
+			s.Status = otlpStatus{Code: 2, Message: span.Error}
+		}
+		otlpSpans = append(otlpSpans, s)
+	}
+
+	body, err := json.Marshal(otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpAttribute{{"service.name", newOTLPValue(exporter.serviceName)}},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/fullstorydev/relay-core"},
+				Spans: otlpSpans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	response, err := exporter.client.Post(exporter.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("OTLP endpoint responded with status %v", response.StatusCode)
+	}
+	return nil
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -0,0 +1,401 @@
+// Package tracing implements distributed tracing for the relay, compatible
+// with OpenTelemetry. Trace context is propagated using the W3C traceparent
+// header, and finished spans are exported to stdout or to an OTLP/HTTP
+// endpoint using the OTLP JSON encoding.
+//
+// A nil *Tracer and a nil *Span are valid and do nothing, so callers don't
+// need to check whether tracing is enabled.
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+func (id SpanID) IsValid() bool  { return id != SpanID{} }
+
+// SpanContext identifies a span and carries the trace flags propagated with
+// it.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%v-%v-%v", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value. It returns false if
+// the value is malformed or identifies an invalid trace or span.
+func ParseTraceparent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	// version "-" trace-id "-" parent-id "-" trace-flags
+	if len(value) < 55 || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return sc, false
+	}
+	version, err := hex.DecodeString(value[0:2])
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(value) != 55) {
+		return sc, false
+	}
+	if len(value) > 55 && value[55] != '-' {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(value[3:35])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(value[36:52])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(value[53:55])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// Extract returns the span context from a request's traceparent header.
+func Extract(header http.Header) (SpanContext, bool) {
+	return ParseTraceparent(header.Get("traceparent"))
+}
+
+// Inject sets the traceparent header to identify the provided span context.
+// Any tracestate header is left alone, so it's propagated unchanged.
+func Inject(header http.Header, sc SpanContext) {
+	if sc.IsValid() {
+		header.Set("traceparent", sc.Traceparent())
+	}
+}
+
+// SpanKind describes the relationship between a span and its parent and
+// children, as in OpenTelemetry.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+func (kind SpanKind) String() string {
+	switch kind {
+	case SpanKindInternal:
+		return "internal"
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	default:
+		return "(unknown kind)"
+	}
+}
+
+// Attribute is a key-value pair describing a span. Values should be strings,
+// integers, floats, or bools.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// SpanData is the record of a finished span, as passed to an Exporter.
+type SpanData struct {
+	Name         string
+	Kind         SpanKind
+	Context      SpanContext
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   []Attribute
+	Error        string // If non-empty, the operation failed with this error.
+}
+
+// Span is an operation being traced.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// Context returns the span's span context, which should be propagated to
+// any services called as part of the operation.
+func (span *Span) Context() SpanContext {
+	if span == nil {
+		return SpanContext{}
+	}
+	return span.data.Context
+}
+
+// SetAttribute adds an attribute to the span.
+func (span *Span) SetAttribute(key string, value any) {
+	if span == nil {
+		return
+	}
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	span.data.Attributes = append(span.data.Attributes, Attribute{key, value})
+}
+
+// RecordError marks the span as failed.
+func (span *Span) RecordError(err error) {
+	if span == nil || err == nil {
+		return
+	}
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	span.data.Error = err.Error()
+}
+
+// End finishes the span and, if it's sampled, queues it for export. Calls
+// after the first have no effect.
+func (span *Span) End() {
+	if span == nil {
+		return
+	}
+	span.mu.Lock()
+	if span.ended {
+		span.mu.Unlock()
+		return
+	}
+	span.ended = true
+	span.data.End = time.Now()
+	data := span.data
+	span.mu.Unlock()
+
+	if data.Context.Sampled {
+		span.tracer.enqueue(&data)
+	}
+}
+
+type spanContextKey struct{}
+type remoteParentKey struct{}
+
+// ContextWithSpan returns a context in which the provided span is current.
+// Spans started from that context are its children.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// SpanFromContext returns the current span, or nil if there isn't one.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteParent returns a context in which spans without a local
+// parent become children of a span in another service, typically identified
+// by an incoming traceparent header.
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteParentKey{}, sc)
+}
+
+// Tracer creates spans and exports them once they're finished.
+type Tracer struct {
+	options  *Options
+	exporter Exporter
+
+	queue   chan *SpanData
+	flushes chan chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewTracer creates a tracer which exports spans in the background.
+func NewTracer(options *Options, exporter Exporter) *Tracer {
+	tracer := &Tracer{
+		options:  options,
+		exporter: exporter,
+		queue:    make(chan *SpanData, options.QueueSize),
+		flushes:  make(chan chan struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go tracer.run()
+	return tracer
+}
+
+// Start begins a span. Its parent is the span current in the provided
+// context, or a remote parent added using ContextWithRemoteParent; if there's
+// neither, it starts a new trace. The returned context has the new span as its
+// current span.
+func (tracer *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		tracer: tracer,
+		data: SpanData{
+			Name:  name,
+			Kind:  kind,
+			Start: time.Now(),
+		},
+	}
+
+	var parent SpanContext
+	if parentSpan := SpanFromContext(ctx); parentSpan != nil {
+		parent = parentSpan.Context()
+	} else if remote, ok := ctx.Value(remoteParentKey{}).(SpanContext); ok {
+		parent = remote
+	}
+
+	if parent.IsValid() {
+		span.data.Context.TraceID = parent.TraceID
+		span.data.Context.Sampled = parent.Sampled
+		span.data.ParentSpanID = parent.SpanID
+	} else {
+		span.data.Context.TraceID = newTraceID()
+		span.data.Context.Sampled = rand.Float64() < tracer.options.SampleRate
+	}
+	span.data.Context.SpanID = newSpanID()
+
+	return ContextWithSpan(ctx, span), span
+}
+
+// Flush exports every span that has ended so far.
+func (tracer *Tracer) Flush() {
+	if tracer == nil {
+		return
+	}
+	flushed := make(chan struct{})
+	select {
+	case tracer.flushes <- flushed:
+		<-flushed
+	case <-tracer.done:
+	}
+}
+
+// Close exports any remaining spans and stops the tracer.
+func (tracer *Tracer) Close() {
+	if tracer == nil {
+		return
+	}
+	tracer.once.Do(func() { close(tracer.stop) })
+	<-tracer.done
+}
+
+func (tracer *Tracer) enqueue(data *SpanData) {
+	select {
+	case tracer.queue <- data:
+	default:
+		droppedSpans.Inc()
+	}
+}
+
+// run collects finished spans into batches and exports them.
+func (tracer *Tracer) run() {
+	defer close(tracer.done)
+
+	ticker := time.NewTicker(tracer.options.BatchInterval)
+	defer ticker.Stop()
+
+	batch := []*SpanData{}
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := tracer.exporter.Export(batch); err != nil {
+			logger.Warn("Error exporting spans", "spans", len(batch), "error", err)
+		}
+		batch = []*SpanData{}
+	}
+	drain := func() {
+		for {
+			select {
+			case data := <-tracer.queue:
+				batch = append(batch, data)
+			default:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case data := <-tracer.queue:
+			batch = append(batch, data)
+			if len(batch) >= tracer.options.BatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case flushed := <-tracer.flushes:
+			drain()
+			export()
+			close(flushed)
+		case <-tracer.stop:
+			drain()
+			export()
+			return
+		}
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		putUint64(id[:8], rand.Uint64())
+		putUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		putUint64(id[:], rand.Uint64())
+	}
+	return id
+}
+
+func putUint64(buffer []byte, value uint64) {
+	for i := range buffer {
+		buffer[i] = byte(value >> (8 * i))
+	}
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -0,0 +1,155 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/fullstorydev/relay-core/relay/tracing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		value    string
+		valid    bool
+		expected string
+	}{
+		{
+			desc:     "Sampled",
+			value:    "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			valid:    true,
+			expected: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		{
+			desc:     "Not sampled",
+			value:    "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			valid:    true,
+			expected: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+		},
+		{
+			desc:     "Future versions may have more fields",
+			value:    "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			valid:    true,
+			expected: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		{
+			desc:  "Version 00 may not have more fields",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		},
+		{
+			desc:  "Invalid trace ID",
+			value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		},
+		{
+			desc:  "Invalid span ID",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		},
+		{
+			desc:  "Not hex",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01",
+		},
+		{
+			desc:  "Truncated",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		},
+	}
+
+	for _, testCase := range testCases {
+		sc, valid := tracing.ParseTraceparent(testCase.value)
+		if valid != testCase.valid {
+			t.Errorf("Test '%v': Expected valid to be %v but got %v", testCase.desc, testCase.valid, valid)
+			continue
+		}
+		if valid && sc.Traceparent() != testCase.expected {
+			t.Errorf("Test '%v': Expected %v but got %v", testCase.desc, testCase.expected, sc.Traceparent())
+		}
+	}
+}
+
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans map[string]*tracing.SpanData
+}
+
+func (exporter *recordingExporter) Export(spans []*tracing.SpanData) error {
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	for _, span := range spans {
+		exporter.spans[span.Name] = span
+	}
+	return nil
+}
+
+func TestSpans(t *testing.T) {
+	exporter := &recordingExporter{spans: map[string]*tracing.SpanData{}}
+	options := tracing.NewDefaultOptions()
+	tracer := tracing.NewTracer(options, exporter)
+	defer tracer.Close()
+
+	remote, _ := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := tracing.ContextWithRemoteParent(context.Background(), remote)
+	ctx, server := tracer.Start(ctx, "server", tracing.SpanKindServer)
+	_, child := tracer.Start(ctx, "child", tracing.SpanKindClient)
+	child.SetAttribute("attempt", 1)
+	child.RecordError(errors.New("connection refused"))
+	child.End()
+	server.End()
+	server.End()
+
+	unsampled, _ := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, dropped := tracer.Start(tracing.ContextWithRemoteParent(context.Background(), unsampled), "unsampled", tracing.SpanKindServer)
+	dropped.End()
+
+	tracer.Flush()
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+
+	if len(exporter.spans) != 2 {
+		t.Errorf("Expected 2 sampled spans but got %v", len(exporter.spans))
+	}
+	serverData, childData := exporter.spans["server"], exporter.spans["child"]
+	if serverData == nil || childData == nil {
+		t.Fatalf("Expected server and child spans but got %v", exporter.spans)
+	}
+	if serverData.Context.TraceID != remote.TraceID || serverData.ParentSpanID != remote.SpanID {
+		t.Errorf("Expected server span to continue the remote trace")
+	}
+	if childData.Context.TraceID != remote.TraceID || childData.ParentSpanID != serverData.Context.SpanID {
+		t.Errorf("Expected child span to be a child of the server span")
+	}
+	if childData.Error != "connection refused" || len(childData.Attributes) != 1 {
+		t.Errorf("Expected child span to record its error and attributes: %+v", childData)
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *tracing.Tracer
+	ctx, span := tracer.Start(context.Background(), "nothing", tracing.SpanKindInternal)
+	span.SetAttribute("ignored", true)
+	span.End()
+	if span != nil || tracing.SpanFromContext(ctx) != nil {
+		t.Errorf("Expected a nil tracer to create no spans")
+	}
+	tracer.Close()
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -0,0 +1,139 @@
+package traffic
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fullstorydev/relay-core/relay/accesslog"
+)
+
+// SetAccessLog directs the handler to write an entry to the provided access
+// log for each request. It must be called before the handler starts serving
+// requests.
+func (handler *Handler) SetAccessLog(accessLog *accesslog.Logger) {
+	handler.accessLog = accessLog
+}
+
+// requestOutcome collects the details of a request's handling that are
+// reported in the access log. It's shared by every copy of the request's
+// RequestInfo.
+type requestOutcome struct {
+	requestBytes atomic.Int64
+
+	mu               sync.Mutex
+	servicedBy       string
+	rules            []string
+	upstreamHost     string
+	upstreamDuration time.Duration
+}
+
+// RecordRule notes that a plugin rule applied to the request, so that it
+// appears in the access log. Rules should be described briefly, like
+// "cookies:allowlist" or "paths:/api/*".
+func (info RequestInfo) RecordRule(rule string) {
+	if info.outcome == nil {
+		return
+	}
+	info.outcome.mu.Lock()
+	defer info.outcome.mu.Unlock()
+	for _, existing := range info.outcome.rules {
+		if existing == rule {
+			return
+		}
+	}
+	info.outcome.rules = append(info.outcome.rules, rule)
+}
+
+// setServicedBy records the name of the plugin that responded to the request,
+// or "relay" if the relay itself did. Only the first is recorded.
+func (outcome *requestOutcome) setServicedBy(name string) {
+	if outcome == nil {
+		return
+	}
+	outcome.mu.Lock()
+	defer outcome.mu.Unlock()
+	if outcome.servicedBy == "" {
+		outcome.servicedBy = name
+	}
+}
+
+// recordUpstream notes the target host that the request was sent to and adds
+// to the time spent waiting for it.
+func (outcome *requestOutcome) recordUpstream(host string, duration time.Duration) {
+	if outcome == nil {
+		return
+	}
+	outcome.mu.Lock()
+	defer outcome.mu.Unlock()
+	outcome.upstreamHost = host
+	outcome.upstreamDuration += duration
+}
+
+// logAccess writes the access log entry for a request, if there's an access
+// log.
+func (handler *Handler) logAccess(
+	request *http.Request,
+	originalURI string,
+	recorder *responseRecorder,
+	requestID string,
+	outcome *requestOutcome,
+	attributes *requestAttributes,
+	start time.Time,
+) {
+	if handler.accessLog == nil {
+		return
+	}
+
+	clientIP, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		clientIP = request.RemoteAddr
+	}
+
+	outcome.mu.Lock()
+	entry := &accesslog.Entry{
+		Time:             start,
+		RequestID:        requestID,
+		ClientIP:         clientIP,
+		Method:           request.Method,
+		URI:              originalURI,
+		Proto:            request.Proto,
+		Status:           recorder.Status(),
+		RequestBytes:     outcome.requestBytes.Load(),
+		ResponseBytes:    recorder.bytes,
+		Duration:         time.Since(start),
+		UpstreamDuration: outcome.upstreamDuration,
+		UpstreamHost:     outcome.upstreamHost,
+		ServicedBy:       outcome.servicedBy,
+		Rules:            append([]string{}, outcome.rules...),
+		Attributes:       attributes.logged(),
+		Referer:          request.Referer(),
+		UserAgent:        request.UserAgent(),
+	}
+	outcome.mu.Unlock()
+
+	if err := handler.accessLog.Log(entry); err != nil {
+		logger.Error("Error writing access log", "error", err)
+	}
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
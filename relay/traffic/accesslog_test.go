@@ -0,0 +1,111 @@
+package traffic_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/relay-core/catcher"
+	"github.com/fullstorydev/relay-core/relay"
+	"github.com/fullstorydev/relay-core/relay/plugins/traffic/cookies-plugin"
+	"github.com/fullstorydev/relay-core/relay/test"
+	"github.com/fullstorydev/relay-core/relay/traffic"
+)
+
+func TestAccessLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	configYaml := fmt.Sprintf(`access-log:
+  format: json
+  path: %v
+cookies:
+  allowlist:
+    - SPECIAL_ID
+`, path)
+	plugins := []traffic.PluginFactory{
+		cookies_plugin.Factory,
+	}
+
+	test.WithCatcherAndRelay(t, configYaml, plugins, func(catcherService *catcher.Service, relayService *relay.Service) {
+		request, err := http.NewRequest("POST", relayService.HttpUrl()+"/rec/bundle?x=1", strings.NewReader("hello"))
+		if err != nil {
+			t.Errorf("Error creating request: %v", err)
+			return
+		}
+		request.Header.Set("Cookie", "SPECIAL_ID=1; tracker=2")
+		request.Header.Set("User-Agent", "access-log-test")
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Errorf("Error POSTing: %v", err)
+			return
+		}
+		response.Body.Close()
+
+		// The entry is written after the response has been sent, so it may
+		// not be there yet.
+		var contents []byte
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if contents, _ = os.ReadFile(path); len(contents) > 0 {
+				break
+			}
+		}
+
+		var entry map[string]any
+		if err := json.Unmarshal(contents, &entry); err != nil {
+			t.Errorf("Expected a JSON access log entry but got %q: %v", contents, err)
+			return
+		}
+
+		catcherHost := strings.TrimPrefix(catcherService.HttpUrl(), "http://")
+		expected := map[string]any{
+			"client_ip":     "127.0.0.1",
+			"method":        "POST",
+			"uri":           "/rec/bundle?x=1",
+			"proto":         "HTTP/1.1",
+			"status":        float64(200),
+			"request_bytes": float64(5),
+			"upstream_host": catcherHost,
+			"serviced_by":   "relay",
+			"user_agent":    "access-log-test",
+		}
+		for key, value := range expected {
+			if entry[key] != value {
+				t.Errorf("Expected access log %v to be %v but got %v", key, value, entry[key])
+			}
+		}
+		if rules := fmt.Sprint(entry["rules"]); rules != "[cookies:drop]" {
+			t.Errorf("Expected the cookie rule to be logged but got %v", rules)
+		}
+		if responseBytes, _ := entry["response_bytes"].(float64); responseBytes <= 0 {
+			t.Errorf("Expected response bytes to be logged but got %v", entry["response_bytes"])
+		}
+		duration, _ := entry["duration_ms"].(float64)
+		upstreamDuration, _ := entry["upstream_duration_ms"].(float64)
+		if upstreamDuration <= 0 || duration < upstreamDuration {
+			t.Errorf("Expected upstream time %v to be part of total time %v", upstreamDuration, duration)
+		}
+	})
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -0,0 +1,214 @@
+package traffic
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// RequestBody gives plugins shared, lazy access to the body of an incoming
+// request. The body is read and decoded at most once, when a plugin first
+// needs it. If no plugin modifies the body, the relay forwards the original
+// encoded bytes to the target; if nothing reads the body at all, it's
+// streamed to the target without being buffered.
+//
+// Plugins should use Bytes and Set rather than reading and replacing the
+// request's Body directly, although reading the request's Body also works:
+// until a plugin replaces it, it reads the decoded content of the
+// RequestBody.
+type RequestBody struct {
+	source         io.ReadCloser // The body as received from the client, still encoded.
+	encodings      []Encoding
+	maxDecodedSize int64
+
+	loaded   bool
+	encoded  []byte
+	decoded  []byte
+	readErr  error // An error reading the body from the client.
+	err      error // An error reading or decoding the body.
+	modified bool
+
+	reader *requestBodyReader
+}
+
+func newRequestBody(source io.ReadCloser, encodings []Encoding, maxDecodedSize int64) *RequestBody {
+	body := &RequestBody{
+		source:         source,
+		encodings:      encodings,
+		maxDecodedSize: maxDecodedSize,
+	}
+	body.reader = &requestBodyReader{body: body}
+	return body
+}
+
+// Encodings returns the content encodings that the client applied to the
+// body, in the order in which they were applied.
+func (body *RequestBody) Encodings() []Encoding {
+	return body.encodings
+}
+
+// Bytes returns the decoded content of the body. The returned slice must not
+// be modified; use Set to change the body. If the body couldn't be read or
+// decoded, an error is returned; IsRequestBodyTooLarge can be used to check
+// whether the body exceeded the relay's size limits.
+func (body *RequestBody) Bytes() ([]byte, error) {
+	if !body.modified {
+		body.load()
+	}
+	return body.decoded, body.err
+}
+
+// Set replaces the decoded content of the body. The relay encodes the new
+// content using the original encodings before forwarding it to the target.
+func (body *RequestBody) Set(content []byte) {
+	body.decoded = content
+	body.err = nil
+	body.modified = true
+	body.reader.offset = 0
+}
+
+// Modified returns true if Set has been called.
+func (body *RequestBody) Modified() bool {
+	return body.modified
+}
+
+// exceededDecodedLimit returns true if the body was received intact but
+// decoded to something larger than the decoded size limit.
+func (body *RequestBody) exceededDecodedLimit() bool {
+	return body.readErr == nil && IsRequestBodyTooLarge(body.err)
+}
+
+func (body *RequestBody) load() {
+	if body.loaded {
+		return
+	}
+	body.loaded = true
+
+	if body.source == nil || body.source == http.NoBody {
+		return
+	}
+
+	body.encoded, body.readErr = io.ReadAll(body.source)
+	body.source.Close()
+	if body.readErr != nil {
+		body.err = body.readErr
+		return
+	}
+	if IsIdentity(body.encodings...) {
+		body.decoded = body.encoded
+		return
+	}
+
+	reader, err := decodeReader(io.NopCloser(bytes.NewReader(body.encoded)), body.encodings)
+	if err != nil {
+		body.err = err
+		return
+	}
+	defer reader.Close()
+
+	// A small compressed body can decode to something enormous, so the
+	// decoded body needs a limit of its own.
+	body.decoded, body.err = io.ReadAll(&decodedBodyReader{
+		ReadCloser: reader,
+		limit:      body.maxDecodedSize,
+	})
+}
+
+// forward prepares the request's Body to be sent to the target. Unmodified
+// bodies are forwarded exactly as they were received; modified bodies are
+// encoded again.
+func (body *RequestBody) forward(clientRequest *http.Request) error {
+	switch {
+	case clientRequest.Body != body.reader && clientRequest.Body != body.source:
+		// A plugin replaced the body with decoded content of its own.
+		content, err := io.ReadAll(clientRequest.Body)
+		if err != nil {
+			return err
+		}
+		return body.forwardEncoded(clientRequest, content)
+
+	case body.modified:
+		return body.forwardEncoded(clientRequest, body.decoded)
+
+	case body.loaded:
+		// If the body couldn't be read in full, or it decoded to something too
+		// large, it can't be forwarded. Other decoding errors are the
+		// target's problem, so the original bytes are forwarded anyway.
+		if body.readErr != nil {
+			return body.readErr
+		}
+		if IsRequestBodyTooLarge(body.err) {
+			return body.err
+		}
+		if body.source != nil && body.source != http.NoBody {
+			clientRequest.Body = io.NopCloser(bytes.NewReader(body.encoded))
+		}
+		return nil
+
+	default:
+		clientRequest.Body = body.source
+		return nil
+	}
+}
+
+func (body *RequestBody) forwardEncoded(clientRequest *http.Request, content []byte) error {
+	encodedContent, err := EncodeData(content, body.encodings...)
+	if err != nil {
+		return err
+	}
+
+	// If the length of the body has changed, we should update the
+	// Content-Length header too.
+	contentLength := int64(len(encodedContent))
+	if contentLength != clientRequest.ContentLength {
+		clientRequest.ContentLength = contentLength
+		clientRequest.Header.Set("Content-Length", strconv.FormatInt(contentLength, 10))
+	}
+
+	clientRequest.Body = io.NopCloser(bytes.NewReader(encodedContent))
+	return nil
+}
+
+// requestBodyReader reads the decoded content of a RequestBody. It stands in
+// for the request's Body while plugins handle the request.
+type requestBodyReader struct {
+	body   *RequestBody
+	offset int
+}
+
+func (reader *requestBodyReader) Read(buffer []byte) (int, error) {
+	content, err := reader.body.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	if reader.offset >= len(content) {
+		return 0, io.EOF
+	}
+	n := copy(buffer, content[reader.offset:])
+	reader.offset += n
+	return n, nil
+}
+
+func (reader *requestBodyReader) Close() error {
+	return nil
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -0,0 +1,277 @@
+package traffic
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned in place of sending a request to a host whose
+// circuit breaker is open.
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed is the normal state; requests are sent to the host.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen means the host is failing; requests fail fast.
+	CircuitOpen
+
+	// CircuitHalfOpen means a limited number of trial requests are being sent
+	// to find out whether the host has recovered.
+	CircuitHalfOpen
+)
+
+func (state CircuitState) String() string {
+	switch state {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "(unknown state)"
+	}
+}
+
+// CircuitBreakerStatus is a snapshot of the state of a host's circuit breaker.
+type CircuitBreakerStatus struct {
+	Host     string `json:"host"`
+	State    string `json:"state"`
+	Requests int    `json:"requests"` // Requests in the current window.
+	Failures int    `json:"failures"` // Failed requests in the current window.
+}
+
+// circuitBreakers holds a circuit breaker for each host the relay sends
+// requests to. Plugins may direct requests to arbitrary hosts, so breakers that
+// have been idle for a whole window, and that have nothing to remember, are
+// discarded.
+type circuitBreakers struct {
+	options *CircuitBreakerOptions
+
+	mu        sync.Mutex
+	breakers  map[string]*circuitBreaker
+	lastSweep time.Time
+}
+
+func newCircuitBreakers(options *CircuitBreakerOptions) *circuitBreakers {
+	return &circuitBreakers{
+		options:  options,
+		breakers: map[string]*circuitBreaker{},
+	}
+}
+
+// Get returns the circuit breaker for the provided host, creating it if
+// necessary. If circuit breakers are disabled, it returns nil.
+func (breakers *circuitBreakers) Get(host string) *circuitBreaker {
+	if breakers.options == nil {
+		return nil
+	}
+
+	breakers.mu.Lock()
+	defer breakers.mu.Unlock()
+	breakers.sweep(time.Now())
+	breaker := breakers.breakers[host]
+	if breaker == nil {
+		breaker = &circuitBreaker{options: breakers.options}
+		breakers.breakers[host] = breaker
+	}
+	return breaker
+}
+
+// sweep discards idle breakers, at most once per window. The caller must hold
+// the mutex.
+func (breakers *circuitBreakers) sweep(now time.Time) {
+	if now.Sub(breakers.lastSweep) < breakers.options.Window {
+		return
+	}
+	breakers.lastSweep = now
+	for host, breaker := range breakers.breakers {
+		if breaker.idle(now) {
+			delete(breakers.breakers, host)
+		}
+	}
+}
+
+// Statuses returns the status of every circuit breaker, sorted by host.
+func (breakers *circuitBreakers) Statuses() []CircuitBreakerStatus {
+	breakers.mu.Lock()
+	defer breakers.mu.Unlock()
+
+	now := time.Now()
+	statuses := make([]CircuitBreakerStatus, 0, len(breakers.breakers))
+	for host, breaker := range breakers.breakers {
+		statuses = append(statuses, breaker.status(host, now))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Host < statuses[j].Host })
+	return statuses
+}
+
+type circuitBreaker struct {
+	options *CircuitBreakerOptions
+
+	mu          sync.Mutex
+	state       CircuitState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	trials      int // Trial requests sent while half-open.
+	successes   int // Trial requests that succeeded while half-open.
+	pending     int // Requests permitted by Allow whose outcome hasn't been reported.
+	lastUsed    time.Time
+}
+
+// Allow returns true if a request may be sent to the host. Callers that are
+// allowed to send a request must report its outcome using Record.
+func (breaker *circuitBreaker) Allow(now time.Time) bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	breaker.lastUsed = now
+
+	switch breaker.state {
+	case CircuitOpen:
+		if now.Sub(breaker.openedAt) < breaker.options.OpenDuration {
+			return false
+		}
+		breaker.state = CircuitHalfOpen
+		breaker.trials = 0
+		breaker.successes = 0
+		fallthrough
+	case CircuitHalfOpen:
+		if breaker.trials >= breaker.options.HalfOpenRequests {
+			return false
+		}
+		breaker.trials++
+		breaker.pending++
+		return true
+	default:
+		breaker.pending++
+		return true
+	}
+}
+
+// Record reports the outcome of a request that Allow permitted.
+func (breaker *circuitBreaker) Record(success bool, latency time.Duration, now time.Time) {
+	if threshold := breaker.options.LatencyThreshold; threshold > 0 && latency > threshold {
+		success = false
+	}
+
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	breaker.lastUsed = now
+	if breaker.pending > 0 {
+		breaker.pending--
+	}
+
+	switch breaker.state {
+	case CircuitHalfOpen:
+		if !success {
+			breaker.open(now)
+			return
+		}
+		breaker.successes++
+		if breaker.successes >= breaker.options.HalfOpenRequests {
+			breaker.state = CircuitClosed
+			breaker.resetWindow(now)
+		}
+	case CircuitClosed:
+		if now.Sub(breaker.windowStart) >= breaker.options.Window {
+			breaker.resetWindow(now)
+		}
+		breaker.requests++
+		if !success {
+			breaker.failures++
+		}
+		if breaker.requests >= breaker.options.MinRequests &&
+			float64(breaker.failures) >= breaker.options.ErrorThreshold*float64(breaker.requests) {
+			breaker.open(now)
+		}
+	}
+}
+
+// Abandon reports that a request that Allow permitted was never completed for
+// reasons unrelated to the host, like the client going away.
+func (breaker *circuitBreaker) Abandon() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	if breaker.pending > 0 {
+		breaker.pending--
+	}
+	if breaker.state == CircuitHalfOpen && breaker.trials > 0 {
+		breaker.trials--
+	}
+}
+
+// RetryAfter returns how long remains until an open breaker will let trial
+// requests through.
+func (breaker *circuitBreaker) RetryAfter(now time.Time) time.Duration {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	if breaker.state != CircuitOpen {
+		return 0
+	}
+	return breaker.options.OpenDuration - now.Sub(breaker.openedAt)
+}
+
+func (breaker *circuitBreaker) open(now time.Time) {
+	breaker.state = CircuitOpen
+	breaker.openedAt = now
+	breaker.resetWindow(now)
+}
+
+func (breaker *circuitBreaker) resetWindow(now time.Time) {
+	breaker.windowStart = now
+	breaker.requests = 0
+	breaker.failures = 0
+}
+
+// idle returns true if the breaker is closed, has no requests in progress, and
+// hasn't been used for a whole window, so discarding it loses nothing.
+func (breaker *circuitBreaker) idle(now time.Time) bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	return breaker.state == CircuitClosed &&
+		breaker.pending == 0 &&
+		now.Sub(breaker.lastUsed) >= breaker.options.Window &&
+		now.Sub(breaker.windowStart) >= breaker.options.Window
+}
+
+func (breaker *circuitBreaker) status(host string, now time.Time) CircuitBreakerStatus {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	status := CircuitBreakerStatus{
+		Host:  host,
+		State: breaker.state.String(),
+	}
+	if breaker.state == CircuitClosed && now.Sub(breaker.windowStart) < breaker.options.Window {
+		status.Requests = breaker.requests
+		status.Failures = breaker.failures
+	}
+	return status
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -1,13 +1,19 @@
 package traffic
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"compress/zlib"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 )
 
 type Encoding int
@@ -16,9 +22,54 @@ const (
 	Unsupported Encoding = iota
 	Identity
 	Gzip
+	Deflate
+	Brotli
+	Zstd
 )
 
-func GetContentEncoding(request *http.Request) (Encoding, error) {
+// zstdMaxWindowSize limits the memory used to decode zstd content. RFC 8878
+// recommends that HTTP clients and servers support windows of up to 8MB.
+const zstdMaxWindowSize = 8 << 20
+
+func (encoding Encoding) String() string {
+	switch encoding {
+	case Identity:
+		return "identity"
+	case Gzip:
+		return "gzip"
+	case Deflate:
+		return "deflate"
+	case Brotli:
+		return "br"
+	case Zstd:
+		return "zstd"
+	default:
+		return "(unsupported encoding)"
+	}
+}
+
+func parseEncoding(name string) (Encoding, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "identity":
+		return Identity, nil
+	case "gzip", "x-gzip":
+		return Gzip, nil
+	case "deflate":
+		return Deflate, nil
+	case "br":
+		return Brotli, nil
+	case "zstd":
+		return Zstd, nil
+	default:
+		return Unsupported, fmt.Errorf("unsupported encoding: %v", name)
+	}
+}
+
+// GetContentEncoding returns the encodings that have been applied to the
+// request body, in the order in which they were applied. (For example,
+// "Content-Encoding: gzip, br" yields [Gzip, Brotli].) Identity encodings are
+// omitted, so an unencoded body yields an empty list.
+func GetContentEncoding(request *http.Request) ([]Encoding, error) {
 	// NOTE: This is a workaround for a bug in post-Go 1.17. See golang.org/issue/25192.
 	// Our algorithm differs from the logic of AllowQuerySemicolons by replacing semicolons with encoded semicolons instead
 	// of with ampersands. This is because we want to preserve the original query string as much as possible.
@@ -28,85 +79,186 @@ func GetContentEncoding(request *http.Request) (Encoding, error) {
 
 	queryParams, err := url.ParseQuery(request.URL.RawQuery)
 	if err != nil {
-		return Unsupported, err
+		return nil, err
 	}
 
 	// request query parameter takes precedence over request header
-	encoding := queryParams.Get("ContentEncoding")
-	if encoding == "" {
-		encoding = request.Header.Get("Content-Encoding")
+	contentEncoding := queryParams.Get("ContentEncoding")
+	if contentEncoding == "" {
+		contentEncoding = strings.Join(request.Header.Values("Content-Encoding"), ",")
 	}
 
-	switch encoding {
-	case "gzip":
-		return Gzip, nil
-	case "":
-		return Identity, nil
-	default:
-		return Unsupported, fmt.Errorf("unsupported encoding: %v", encoding)
+	var encodings []Encoding
+	for _, name := range strings.Split(contentEncoding, ",") {
+		encoding, err := parseEncoding(name)
+		if err != nil {
+			return nil, err
+		}
+		if encoding != Identity {
+			encodings = append(encodings, encoding)
+		}
 	}
+	return encodings, nil
 }
 
-// WrapReader returns a wrapped request.Body for the encoding provided.
-func WrapReader(request *http.Request, encoding Encoding) (io.ReadCloser, error) {
+// IsIdentity returns true if the provided encodings leave content unchanged.
+func IsIdentity(encodings ...Encoding) bool {
+	for _, encoding := range encodings {
+		if encoding != Identity {
+			return false
+		}
+	}
+	return true
+}
+
+// WrapReader returns a wrapped request.Body which decodes the provided
+// encodings. The encodings are listed in the order in which they were applied,
+// so they're decoded in reverse order.
+func WrapReader(request *http.Request, encodings ...Encoding) (io.ReadCloser, error) {
 	if request.Body == nil {
 		return nil, nil
 	}
+	return decodeReader(request.Body, encodings)
+}
+
+func decodeReader(reader io.ReadCloser, encodings []Encoding) (io.ReadCloser, error) {
+	if IsIdentity(encodings...) {
+		// If the content is not encoded, return the original reader
+		return reader, nil
+	}
 
+	decoder := &decodingReader{
+		Reader:  reader,
+		closers: []io.Closer{reader},
+	}
+	for i := len(encodings) - 1; i >= 0; i-- {
+		reader, err := newDecoder(decoder.Reader, encodings[i])
+		if err != nil {
+			decoder.Close()
+			return nil, err
+		}
+		decoder.Reader = reader
+		if closer, ok := reader.(io.Closer); ok {
+			decoder.closers = append(decoder.closers, closer)
+		}
+	}
+	return decoder, nil
+}
+
+// decodingReader reads from a chain of decoders. Closing it closes every
+// decoder in the chain, as well as the underlying reader.
+type decodingReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (reader *decodingReader) Close() error {
+	var firstErr error
+	for i := len(reader.closers) - 1; i >= 0; i-- {
+		if err := reader.closers[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func newDecoder(reader io.Reader, encoding Encoding) (io.Reader, error) {
 	switch encoding {
-	case Gzip:
-		// Create a new gzip.Reader to decompress the request body
-		return gzip.NewReader(request.Body)
 	case Identity:
-		// If the content is not gzip-compressed, return the original request body
-		return request.Body, nil
+		return reader, nil
+	case Gzip:
+		return gzip.NewReader(reader)
+	case Deflate:
+		// The "deflate" content encoding is supposed to be the zlib format,
+		// but some clients send raw deflate data instead, so accept both.
+		bufferedReader := bufio.NewReader(reader)
+		if header, err := bufferedReader.Peek(2); err == nil && isZlibHeader(header) {
+			return zlib.NewReader(bufferedReader)
+		}
+		return flate.NewReader(bufferedReader), nil
+	case Brotli:
+		return brotli.NewReader(reader), nil
+	case Zstd:
+		decoder, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindowSize))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
 	default:
 		return nil, fmt.Errorf("unsupported encoding: %v", encoding)
 	}
 }
 
-func EncodeData(data []byte, encoding Encoding) ([]byte, error) {
+func isZlibHeader(header []byte) bool {
+	return header[0]&0x0F == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
+
+func newEncoder(writer io.Writer, encoding Encoding) (io.WriteCloser, error) {
 	switch encoding {
 	case Gzip:
+		return gzip.NewWriter(writer), nil
+	case Deflate:
+		return zlib.NewWriter(writer), nil
+	case Brotli:
+		return brotli.NewWriter(writer), nil
+	case Zstd:
+		return zstd.NewWriter(writer, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(zstdMaxWindowSize))
+	default:
+		return nil, fmt.Errorf("unsupported encoding: %v", encoding)
+	}
+}
+
+// EncodeData applies the provided encodings to data, in order.
+func EncodeData(data []byte, encodings ...Encoding) ([]byte, error) {
+	for _, encoding := range encodings {
+		if encoding == Identity {
+			continue
+		}
+
 		var buf bytes.Buffer
-		gz := gzip.NewWriter(&buf)
+		encoder, err := newEncoder(&buf, encoding)
+		if err != nil {
+			return nil, err
+		}
 
-		_, err := gz.Write(data)
+		_, err = encoder.Write(data)
 		if err != nil {
 			return nil, err
 		}
 
-		err = gz.Close()
+		err = encoder.Close()
 		if err != nil {
 			return nil, err
 		}
 
-		compressedData := buf.Bytes()
-		return compressedData, nil
-	case Identity:
-		return data, nil
-	default:
-		return nil, fmt.Errorf("unsupported encoding: %v", encoding)
+		data = buf.Bytes()
 	}
+	return data, nil
 }
 
-func DecodeData(data []byte, encoding Encoding) ([]byte, error) {
-	switch encoding {
-	case Gzip:
-		reader, err := gzip.NewReader(bytes.NewReader(data))
+// DecodeData removes the provided encodings from data. The encodings are
+// listed in the order in which they were applied, so they're removed in
+// reverse order.
+func DecodeData(data []byte, encodings ...Encoding) ([]byte, error) {
+	for i := len(encodings) - 1; i >= 0; i-- {
+		if encodings[i] == Identity {
+			continue
+		}
+
+		reader, err := newDecoder(bytes.NewReader(data), encodings[i])
 		if err != nil {
 			return nil, err
 		}
 
 		decodedData, err := io.ReadAll(reader)
+		if closer, ok := reader.(io.Closer); ok {
+			closer.Close()
+		}
 		if err != nil {
 			return nil, err
 		}
 
-		return decodedData, nil
-	case Identity:
-		return data, nil
-	default:
-		return nil, fmt.Errorf("unsupported encoding: %v", encoding)
+		data = decodedData
 	}
+	return data, nil
 }
@@ -1,48 +1,199 @@
 package traffic
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"net/http"
-	"os"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/fullstorydev/relay-core/relay/accesslog"
+	"github.com/fullstorydev/relay-core/relay/logging"
+	"github.com/fullstorydev/relay-core/relay/tracing"
 	"github.com/fullstorydev/relay-core/relay/version"
+	"golang.org/x/net/http2"
 )
 
 const RelayVersionHeaderName = "X-Relay-Version"
 
-var logger = log.New(os.Stdout, "[relay-traffic] ", 0)
+var logger = logging.For("traffic")
 
 // Handler handles HTTP traffic sent to the relay. It handles the core relaying
 // process itself, and can be extended using plugins to add additional
 // functionality.
 type Handler struct {
 	config    *RelayOptions
-	plugins   []Plugin
-	transport *http.Transport
-}
-
-func NewHandler(config *RelayOptions, trafficPlugins []Plugin) *Handler {
-	return &Handler{
-		config:  config,
-		plugins: trafficPlugins,
-		transport: &http.Transport{
-			TLSClientConfig: &tls.Config{},
-			Proxy:           http.ProxyFromEnvironment,
-			IdleConnTimeout: 2 * time.Second, // TODO set from configs
+	plugins   atomic.Pointer[pluginChains]
+	transport upstreamTransport
+	pool      *targetPool
+	breakers  *circuitBreakers
+	tunnels   *tunnelSet
+	accessLog *accesslog.Logger
+	tracer    *tracing.Tracer
+
+	oversizeResponses atomic.Int64
+}
+
+func NewHandler(config *RelayOptions, trafficPlugins []Plugin, pluginRoutes []*PluginRoute) *Handler {
+	handler := &Handler{
+		config:    config,
+		transport: newTransport(config.Upstream),
+		pool:      newTargetPool(config),
+		breakers:  newCircuitBreakers(config.Upstream.CircuitBreaker),
+		tunnels:   newTunnelSet(),
+	}
+	handler.plugins.Store(&pluginChains{
+		defaultChain: trafficPlugins,
+		routes:       pluginRoutes,
+	})
+	if config.Upstream.HealthCheck != nil {
+		handler.pool.StartHealthChecks(config.Upstream.HealthCheck, handler.transport)
+	}
+	return handler
+}
+
+// Plugins returns the plugins that the handler runs for requests that don't
+// match any plugin route, in order.
+func (handler *Handler) Plugins() []Plugin {
+	return handler.plugins.Load().defaultChain
+}
+
+// PluginRoutes returns the plugin routes that the handler checks, in order,
+// to choose the plugins for each request.
+func (handler *Handler) PluginRoutes() []*PluginRoute {
+	return handler.plugins.Load().routes
+}
+
+// pluginChain returns the plugins that should handle the request described by
+// the provided RequestInfo.
+func (handler *Handler) pluginChain(info RequestInfo) []Plugin {
+	if info.plugins != nil {
+		return info.plugins
+	}
+	return handler.Plugins()
+}
+
+// Close stops any background work, like health checks, that the handler is
+// performing. If the plugins were started, they're closed once the requests
+// using them have finished.
+func (handler *Handler) Close() {
+	handler.plugins.Load().retire()
+	handler.pool.Close()
+	handler.transport.CloseIdleConnections()
+}
+
+// DrainTunnels stops new WebSocket tunnels from opening and waits for the open
+// ones to close. The HTTP server doesn't track these tunnels once their
+// connections are hijacked, so this must be called during shutdown in addition
+// to http.Server#Shutdown. If the context ends before all tunnels have closed,
+// the remaining tunnels are closed forcibly and the context's error is
+// returned.
+func (handler *Handler) DrainTunnels(ctx context.Context) error {
+	return handler.tunnels.Drain(ctx)
+}
+
+// upstreamTransport is the interface shared by the transports the handler
+// uses to send requests to the target.
+type upstreamTransport interface {
+	http.RoundTripper
+	CloseIdleConnections()
+}
+
+func newTransport(options *UpstreamOptions) upstreamTransport {
+	transport := &http.Transport{
+		TLSClientConfig:       options.TLSConfig.Clone(),
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           newDialer(options).DialContext,
+		TLSHandshakeTimeout:   options.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: options.ResponseHeaderTimeout,
+		IdleConnTimeout:       options.IdleConnTimeout,
+		MaxIdleConns:          options.MaxIdleConns,
+		MaxIdleConnsPerHost:   options.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       options.MaxConnsPerHost,
+		ForceAttemptHTTP2:     options.HTTP2 != HTTP2Off,
+	}
+	if options.HTTP2 != HTTP2PriorKnowledge {
+		return transport
+	}
+
+	dialer := newDialer(options)
+	return &h2cTransport{
+		Transport: transport,
+		h2c: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+			IdleConnTimeout: options.IdleConnTimeout,
 		},
 	}
 }
 
-func (handler *Handler) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+// h2cTransport sends requests for 'http' URLs using unencrypted HTTP/2, and
+// all other requests using the embedded http.Transport.
+type h2cTransport struct {
+	*http.Transport
+	h2c *http2.Transport
+}
+
+func (transport *h2cTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.URL.Scheme == "http" {
+		return transport.h2c.RoundTrip(request)
+	}
+	return transport.Transport.RoundTrip(request)
+}
+
+func (transport *h2cTransport) CloseIdleConnections() {
+	transport.h2c.CloseIdleConnections()
+	transport.Transport.CloseIdleConnections()
+}
+
+func newDialer(options *UpstreamOptions) *net.Dialer {
+	return &net.Dialer{
+		Timeout:   options.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+}
+
+func (handler *Handler) ServeHTTP(clientResponse http.ResponseWriter, request *http.Request) {
+	start := time.Now()
+	route := handler.routeName(request.URL.Path)
+	originalURI := request.RequestURI
+	response := newResponseRecorder(clientResponse)
+	outcome := &requestOutcome{}
+	chains, releasePlugins := handler.acquirePlugins()
+	defer releasePlugins()
+	plugins := chains.selectPlugins(request)
+	request, span := handler.startRequestSpan(request, route)
+
+	// Give the request an ID and, unless it's a WebSocket upgrade, which may
+	// stay open indefinitely, a deadline.
+	requestID := newRequestID()
+	ctx := contextWithRequestID(request.Context(), requestID)
+	if timeout := handler.config.RequestTimeout; timeout > 0 && request.Header.Get("Upgrade") != "websocket" {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	request = request.WithContext(ctx)
+	attributes := &requestAttributes{}
+
+	var body *RequestBody
+	defer func() {
+		handler.recordRequestMetrics(request, route, response, body, start)
+		handler.logAccess(request, originalURI, response, requestID, outcome, attributes, start)
+		endRequestSpan(span, response)
+	}()
+
 	// Drop all cookies; because the relay generally runs in a first-party
 	// context, the risk of receiving cookies intended for other services is
 	// high, so relaying them is a potential privacy and security risk. (In
@@ -51,61 +202,103 @@ func (handler *Handler) ServeHTTP(response http.ResponseWriter, request *http.Re
 	originalCookieHeaders := append([]string{}, request.Header.Values("Cookie")...)
 	request.Header.Del("Cookie")
 
-	// Rewrite the request URL to point to the relay target. Plugins may change
-	// these values to direct certain requests differently.
+	// Rewrite the request URL to point to a target selected from the pool.
+	// Plugins may change these values to direct certain requests differently.
 	originalURL := *request.URL
-	request.URL.Scheme = handler.config.TargetScheme
-	request.URL.Host = handler.config.TargetHost
-	request.Host = handler.config.TargetHost
+	target := handler.pool.Select(request)
+	request.URL.Scheme = target.scheme
+	request.URL.Host = target.host
+	request.Host = target.host
+
+	// Limit the size of the request body before anything reads it. Requests
+	// that declare an oversize body are rejected immediately; otherwise, the
+	// limit is enforced as the body is read.
+	if request.ContentLength > handler.config.MaxRequestBodySize {
+		logger.Info("Rejecting oversize request body", "content-length", request.ContentLength, "path", request.URL.Path)
+		http.Error(response, "Request body was too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if request.Body != nil && request.Body != http.NoBody {
+		request.Body = http.MaxBytesReader(clientResponse, countingReader{request.Body, &outcome.requestBytes}, handler.config.MaxRequestBodySize)
+	}
 
-	encoding, err := GetContentEncoding(request)
+	encodings, err := GetContentEncoding(request)
 	if err != nil {
 		http.Error(response, fmt.Sprintf("URL %v error in request content encoding: %v", request.URL, err), 500)
 		request.Body = http.NoBody
 		return
 	}
 
-	if err := handler.prepareRequestBody(request, encoding); err != nil {
-		http.Error(response, fmt.Sprintf("Error setting up clientRequest body reader: %s", err), 500)
-		request.Body = http.NoBody
-		return
+	// Plugins see the decoded body, which is only read and decoded if a
+	// plugin asks for it.
+	body = newRequestBody(request.Body, encodings, handler.config.MaxDecodedRequestBodySize)
+	if request.Body != nil && request.Body != http.NoBody {
+		request.Body = body.reader
 	}
 
-	serviced := false
-	for _, trafficPlugin := range handler.plugins {
-		if trafficPlugin.HandleRequest(response, request, RequestInfo{
-			OriginalCookieHeaders: originalCookieHeaders,
-			OriginalURL:           &originalURL,
-			Serviced:              serviced,
-		}) {
-			serviced = true
-		}
+	info := RequestInfo{
+		OriginalCookieHeaders: originalCookieHeaders,
+		OriginalURL:           &originalURL,
+		Body:                  body,
+		RequestID:             requestID,
+		target:                target,
+		outcome:               outcome,
+		ctx:                   ctx,
+		attributes:            attributes,
+		plugins:               plugins,
 	}
+	handler.handlePlugins(response, request, &info)
 
-	if handler.HandleRequest(response, request, serviced, encoding) {
-		serviced = true
+	if handler.HandleRequest(response, request, info) {
+		info.Serviced = true
+		outcome.setServicedBy("relay")
 	}
 
-	if serviced {
-		logger.Printf("%s %s %s: serviced", request.Method, request.Host, request.URL)
+	if info.Serviced {
+		logger.Debug("Serviced request", "method", request.Method, "host", request.Host, "path", request.URL.Path)
 	} else {
-		logger.Printf("%s %s %s: not serviced", request.Method, request.Host, request.URL)
+		logger.Info("Request not serviced", "method", request.Method, "host", request.Host, "path", request.URL.Path)
 		http.NotFound(response, request)
 	}
 }
 
-// prepareRequestBody wraps the request Body with a reader that will decode the content if necessary.
-func (handler *Handler) prepareRequestBody(clientRequest *http.Request, encoding Encoding) error {
-	if reader, err := WrapReader(clientRequest, encoding); err != nil {
-		return err
-	} else if reader != nil && reader != http.NoBody {
-		clientRequest.Body = reader
+// decodedBodyReader limits the number of bytes that can be read from a decoded
+// request body. Like http.MaxBytesReader, it reports an *http.MaxBytesError
+// when the limit is exceeded.
+type decodedBodyReader struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (reader *decodedBodyReader) Read(buffer []byte) (int, error) {
+	if reader.read > reader.limit {
+		return 0, &http.MaxBytesError{Limit: reader.limit}
 	}
-	return nil
+
+	// Read one byte more than the limit allows, so we can tell whether the
+	// body ends exactly at the limit.
+	if remaining := reader.limit - reader.read + 1; int64(len(buffer)) > remaining {
+		buffer = buffer[:remaining]
+	}
+	n, err := reader.ReadCloser.Read(buffer)
+	reader.read += int64(n)
+	if reader.read > reader.limit {
+		return n - int(reader.read-reader.limit), &http.MaxBytesError{Limit: reader.limit}
+	}
+	return n, err
 }
 
-func (handler *Handler) HandleRequest(clientResponse http.ResponseWriter, clientRequest *http.Request, serviced bool, encoding Encoding) bool {
-	if serviced {
+// IsRequestBodyTooLarge returns true if the provided error was caused by a
+// request body exceeding the configured size limits. Plugins that read the
+// request body can use it to respond with a 413 error.
+func IsRequestBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+func (handler *Handler) HandleRequest(clientResponse http.ResponseWriter, clientRequest *http.Request, info RequestInfo) bool {
+	if info.Serviced {
 		return false
 	}
 
@@ -114,54 +307,25 @@ func (handler *Handler) HandleRequest(clientResponse http.ResponseWriter, client
 		return true
 	}
 
-	handler.ensureBodyContentEncoding(clientRequest, encoding)
+	if info.Body != nil {
+		if err := info.Body.forward(clientRequest); err != nil {
+			if IsRequestBodyTooLarge(err) {
+				http.Error(clientResponse, "Request body was too large", http.StatusRequestEntityTooLarge)
+				return true
+			}
+			logger.Error("Error encoding request body", "error", err)
+			clientRequest.Body = http.NoBody
+		}
+	}
 	handler.addRelayHeaders(clientRequest)
 
 	if clientRequest.Header.Get("Upgrade") == "websocket" {
-		return handler.handleUpgrade(clientResponse, clientRequest)
+		return handler.handleUpgrade(clientResponse, clientRequest, info)
 	} else {
-		return handler.handleHttp(clientResponse, clientRequest)
+		return handler.handleHttp(clientResponse, clientRequest, info)
 	}
 }
 
-// ensureBodyContentEncoding operates on the assumption that the downstream proxy target will be using the same
-// encoding as what the relay received and ensures we proxy the content encoded correctly.
-func (handler *Handler) ensureBodyContentEncoding(clientRequest *http.Request, encoding Encoding) {
-	switch encoding {
-	case Unsupported:
-		logger.Println("Error unsupported content-encoding")
-		return
-	case Identity:
-		return
-	case Gzip:
-		servicedBody, err := io.ReadAll(clientRequest.Body)
-		if err != nil {
-			logger.Printf("Error reading request body: %s", err)
-			clientRequest.Body = http.NoBody
-			return
-		}
-
-		if encodedData, err := EncodeData(servicedBody, encoding); err != nil {
-			logger.Printf("Error encoding request body: %s", err)
-			clientRequest.Body = http.NoBody
-			return
-		} else {
-			servicedBody = encodedData
-		}
-
-		// If the length of the body has changed, we should update the
-		// Content-Length header too.
-		contentLength := int64(len(servicedBody))
-		if contentLength != clientRequest.ContentLength {
-			clientRequest.ContentLength = contentLength
-			clientRequest.Header.Set("Content-Length", strconv.FormatInt(contentLength, 10))
-		}
-
-		clientRequest.Body = io.NopCloser(bytes.NewBuffer(servicedBody))
-	}
-
-}
-
 func (handler *Handler) addRelayHeaders(clientRequest *http.Request) {
 	// Add X-Forwarded-* headers
 	remoteAddrTokens := strings.Split(clientRequest.RemoteAddr, ":")
@@ -175,13 +339,47 @@ func (handler *Handler) addRelayHeaders(clientRequest *http.Request) {
 	clientRequest.Header.Add(RelayVersionHeaderName, version.RelayRelease)
 }
 
-func (handler *Handler) handleHttp(clientResponse http.ResponseWriter, clientRequest *http.Request) bool {
-	targetResponse, err := handler.transport.RoundTrip(clientRequest)
+func (handler *Handler) handleHttp(clientResponse http.ResponseWriter, clientRequest *http.Request, info RequestInfo) bool {
+	if timeout := handler.config.Upstream.Timeout; timeout > 0 {
+		ctx, cancel := context.WithTimeout(clientRequest.Context(), timeout)
+		defer cancel()
+		clientRequest = clientRequest.WithContext(ctx)
+	}
+
+	upstreamStart := time.Now()
+	targetResponse, err := handler.roundTrip(clientRequest, info)
+	info.outcome.recordUpstream(clientRequest.URL.Host, time.Since(upstreamStart))
 	if err != nil {
-		logger.Printf("Cannot read response from server %v", err)
+		if IsRequestBodyTooLarge(err) {
+			http.Error(clientResponse, "Request body was too large", http.StatusRequestEntityTooLarge)
+			return true
+		}
+		if errors.Is(err, errCircuitOpen) {
+			handler.writeCircuitOpenResponse(clientResponse, clientRequest.URL.Host)
+			return true
+		}
+		if isTimeout(err) {
+			logger.Warn("Timed out waiting for response from target", "error", err)
+			http.Error(clientResponse, "Timed out waiting for response from target", http.StatusGatewayTimeout)
+			return true
+		}
+		logger.Warn("Cannot read response from target", "error", err)
 		return false
 	}
-	defer targetResponse.Body.Close()
+	originalBody := targetResponse.Body
+	defer originalBody.Close()
+
+	originalContentLength := targetResponse.ContentLength
+	if err := handler.handleResponse(targetResponse, info); err != nil {
+		// The error may describe plugin rules or the target, so it's logged
+		// rather than sent to the client.
+		logger.Warn("Error handling response from target", "error", err)
+		http.Error(clientResponse, "Bad Gateway", http.StatusBadGateway)
+		return true
+	}
+	if targetResponse.Body != originalBody {
+		defer targetResponse.Body.Close()
+	}
 
 	// Set the relayed headers
 	for key, values := range targetResponse.Header {
@@ -190,98 +388,472 @@ func (handler *Handler) handleHttp(clientResponse http.ResponseWriter, clientReq
 		}
 	}
 
+	// If a plugin changed the length of the body, we should update the
+	// Content-Length header too.
+	if targetResponse.ContentLength != originalContentLength {
+		if targetResponse.ContentLength >= 0 {
+			clientResponse.Header().Set("Content-Length", strconv.FormatInt(targetResponse.ContentLength, 10))
+		} else {
+			clientResponse.Header().Del("Content-Length")
+		}
+	}
+
+	// Announce any trailers, so they can be relayed after the body.
+	for key := range targetResponse.Trailer {
+		clientResponse.Header().Add("Trailer", key)
+	}
+	defer relayTrailers(clientResponse, targetResponse)
+
 	if targetResponse.ContentLength > handler.config.MaxBodySize {
+		handler.countOversizeResponse()
+		logger.Warn("Response body content-length exceeds maximum size", "content-length", targetResponse.ContentLength, "path", clientRequest.URL.Path)
 		clientResponse.WriteHeader(http.StatusServiceUnavailable)
 		clientResponse.Write([]byte("Response body content-length was too large"))
 	} else if targetResponse.ContentLength > 0 {
 		clientResponse.WriteHeader(targetResponse.StatusCode)
 		if _, err := io.CopyN(clientResponse, targetResponse.Body, targetResponse.ContentLength); err != nil {
-			logger.Printf("Error relaying response body to client: %s", err)
+			logger.Warn("Error relaying response body to client", "error", err)
 		}
 	} else if targetResponse.ContentLength < 0 {
-		clientResponse.WriteHeader(targetResponse.StatusCode)
-		if _, err := io.CopyN(clientResponse, targetResponse.Body, handler.config.MaxBodySize); err != nil {
-			// NOTE: it is highly likely the server would come back without a content-length especially with
-			// mobile traffic. In this case, full copy happens but we get an EOF error that can be safely
-			// ignored. See this example: https://go.dev/play/p/xotsgkwhJis
-			if !errors.Is(err, io.EOF) {
-				logger.Printf("Error relaying response body with unknown content-length: %s", err)
-			}
-		}
+		handler.relayStreamedBody(clientResponse, clientRequest, targetResponse)
 	} else {
 		clientResponse.WriteHeader(targetResponse.StatusCode)
 	}
 	return true
 }
 
-func (handler *Handler) handleUpgrade(clientResponse http.ResponseWriter, clientRequest *http.Request) bool {
-	logger.Println("Upgrading to websocket:", clientRequest.URL)
+// relayTrailers copies the target response's trailers, which are available
+// once its body has been read, to the client response.
+func relayTrailers(clientResponse http.ResponseWriter, targetResponse *http.Response) {
+	for key, values := range targetResponse.Trailer {
+		for _, value := range values {
+			clientResponse.Header().Add(http.TrailerPrefix+key, value)
+		}
+	}
+}
+
+// flushWriter flushes the client response after every write, so that streamed
+// responses (like server-sent events) reach the client as they're produced.
+type flushWriter struct {
+	writer     io.Writer
+	controller *http.ResponseController
+}
 
-	// Connect to the target WS service
-	var targetConn net.Conn
-	var err error
-	if clientRequest.URL.Scheme == "https" {
-		targetConn, err = tls.Dial("tcp", clientRequest.URL.Host, &tls.Config{})
+func newFlushWriter(clientResponse http.ResponseWriter) *flushWriter {
+	return &flushWriter{
+		writer:     clientResponse,
+		controller: http.NewResponseController(clientResponse),
+	}
+}
+
+func (writer *flushWriter) Write(buffer []byte) (int, error) {
+	n, err := writer.writer.Write(buffer)
+	if err != nil {
+		return n, err
+	}
+	if err := writer.controller.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return n, err
+	}
+	return n, nil
+}
+
+// relayStreamedBody relays a response body of unknown length, enforcing
+// MaxBodySize as configured by OversizeResponseAction.
+func (handler *Handler) relayStreamedBody(clientResponse http.ResponseWriter, clientRequest *http.Request, targetResponse *http.Response) {
+	maxBodySize := handler.config.MaxBodySize
+
+	if handler.config.OversizeResponseAction == RejectOversizeResponse {
+		body, err := io.ReadAll(io.LimitReader(targetResponse.Body, maxBodySize+1))
 		if err != nil {
-			logger.Println("Error setting up target tls websocket", err)
-			http.Error(clientResponse, fmt.Sprintf("Could not dial connect %v: %v", clientRequest.URL.Host, err), 404)
-			return true
+			logger.Warn("Error reading response body with unknown content-length", "error", err)
+			clearHeader(clientResponse.Header())
+			http.Error(clientResponse, "Error reading response body", http.StatusBadGateway)
+			return
 		}
-	} else {
-		targetConn, err = net.Dial("tcp", clientRequest.URL.Host)
-		if err != nil {
-			logger.Println("Error setting up target websocket", err)
-			http.Error(clientResponse, fmt.Sprintf("Could not dial connect %v: %v", clientRequest.URL.Host, err), 404)
-			return true
+		if int64(len(body)) > maxBodySize {
+			handler.countOversizeResponse()
+			logger.Warn("Rejecting response body exceeding maximum size", "path", clientRequest.URL.Path)
+			clearHeader(clientResponse.Header())
+			http.Error(clientResponse, "Response body was too large", http.StatusBadGateway)
+			return
+		}
+		clientResponse.WriteHeader(targetResponse.StatusCode)
+		if _, err := clientResponse.Write(body); err != nil {
+			logger.Warn("Error relaying response body to client", "error", err)
 		}
+		return
+	}
+
+	clientResponse.WriteHeader(targetResponse.StatusCode)
+	if _, err := io.CopyN(newFlushWriter(clientResponse), targetResponse.Body, maxBodySize); err != nil {
+		// NOTE: it is highly likely the server would come back without a content-length especially with
+		// mobile traffic. In this case, full copy happens but we get an EOF error that can be safely
+		// ignored. See this example: https://go.dev/play/p/xotsgkwhJis
+		if !errors.Is(err, io.EOF) {
+			logger.Warn("Error relaying response body with unknown content-length", "error", err)
+		}
+		return
+	}
+
+	// We've relayed exactly MaxBodySize bytes; if there's anything left, the
+	// body is too large.
+	var extra [1]byte
+	if _, err := io.ReadFull(targetResponse.Body, extra[:]); err != nil {
+		return
+	}
+
+	handler.countOversizeResponse()
+	if handler.config.OversizeResponseAction == TruncateOversizeResponse {
+		logger.Warn("Truncated response body exceeding maximum size", "path", clientRequest.URL.Path)
+		return
+	}
+
+	// Aborting the handler closes the client connection (or resets the
+	// stream, for HTTP/2) without completing the response, so the client
+	// can tell that it didn't receive the whole body.
+	logger.Warn("Aborted response body exceeding maximum size", "path", clientRequest.URL.Path)
+	panic(http.ErrAbortHandler)
+}
+
+// writeCircuitOpenResponse tells the client that the request wasn't sent
+// because the circuit breaker for the host is open.
+func (handler *Handler) writeCircuitOpenResponse(clientResponse http.ResponseWriter, host string) {
+	logger.Info("Circuit breaker is open", "host", host)
+	if retryAfter := handler.breakers.Get(host).RetryAfter(time.Now()); retryAfter > 0 {
+		seconds := int64((retryAfter + time.Second - 1) / time.Second)
+		clientResponse.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	}
+	options := handler.config.Upstream.CircuitBreaker
+	http.Error(clientResponse, options.ResponseBody, options.ResponseStatus)
+}
+
+// CircuitBreakers returns the status of the circuit breaker for each host the
+// relay has sent requests to.
+func (handler *Handler) CircuitBreakers() []CircuitBreakerStatus {
+	return handler.breakers.Statuses()
+}
+
+// pooledTarget returns the pool target that the request will be sent to, or
+// nil if a plugin has redirected the request elsewhere.
+func pooledTarget(clientRequest *http.Request, info RequestInfo) *upstreamTarget {
+	target := info.target
+	if target == nil || clientRequest.URL.Scheme != target.scheme || clientRequest.URL.Host != target.host {
+		return nil
+	}
+	return target
+}
+
+// isTargetFailure returns true if the response status indicates that the
+// target (rather than the request) is in trouble.
+func isTargetFailure(statusCode int) bool {
+	return statusCode == http.StatusBadGateway ||
+		statusCode == http.StatusServiceUnavailable ||
+		statusCode == http.StatusGatewayTimeout
+}
+
+// OversizeResponses returns the number of target responses that exceeded
+// MaxBodySize.
+func (handler *Handler) OversizeResponses() int64 {
+	return handler.oversizeResponses.Load()
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+func clearHeader(header http.Header) {
+	for key := range header {
+		delete(header, key)
+	}
+}
+
+// handleResponse gives each plugin implementing ResponsePlugin an opportunity
+// to handle the target response, in plugin chain order.
+func (handler *Handler) handleResponse(targetResponse *http.Response, info RequestInfo) error {
+	for _, trafficPlugin := range handler.pluginChain(info) {
+		responsePlugin, ok := trafficPlugin.(ResponsePlugin)
+		if !ok {
+			continue
+		}
+		if err := responsePlugin.HandleResponse(targetResponse, info); err != nil {
+			return fmt.Errorf(`plugin "%s": %w`, trafficPlugin.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (handler *Handler) handleUpgrade(clientResponse http.ResponseWriter, clientRequest *http.Request, info RequestInfo) bool {
+	logger.Debug("Upgrading to websocket", "host", clientRequest.URL.Host, "path", clientRequest.URL.Path)
+
+	// If any plugins want to handle individual messages, we'll need to parse
+	// the WebSocket frames ourselves. We don't support any extensions (like
+	// permessage-deflate) that would transform the message payloads, so don't
+	// let the client and the target negotiate any.
+	webSocketPlugins := handler.webSocketPlugins(info)
+	if len(webSocketPlugins) > 0 {
+		clientRequest.Header.Del("Sec-WebSocket-Extensions")
+	}
+
+	// Connect to the target WS service
+	breaker := handler.breakers.Get(clientRequest.URL.Host)
+	if breaker != nil && !breaker.Allow(time.Now()) {
+		upstreamErrors.Inc(upstreamErrorKind(errCircuitOpen))
+		handler.writeCircuitOpenResponse(clientResponse, clientRequest.URL.Host)
+		return true
+	}
+	upstreamSpan := handler.startUpstreamSpan(clientRequest.Context(), clientRequest)
+	dialStart := time.Now()
+	targetConn, err := handler.dialTarget(clientRequest.Context(), clientRequest.URL)
+	info.outcome.recordUpstream(clientRequest.URL.Host, time.Since(dialStart))
+	endUpstreamSpan(upstreamSpan, nil, err)
+	if err != nil {
+		upstreamErrors.Inc(upstreamErrorKind(err))
+	}
+	if target := pooledTarget(clientRequest, info); target != nil {
+		handler.pool.ReportResult(target, err == nil)
+	}
+	if breaker != nil {
+		breaker.Record(err == nil, time.Since(dialStart), time.Now())
+	}
+	if err != nil {
+		logger.Warn("Error setting up target websocket", "error", err)
+		http.Error(clientResponse, fmt.Sprintf("Could not dial connect %v: %v", clientRequest.URL.Host, err), 404)
+		return true
 	}
 
 	// Write the original client request to the target
 	requestLine := fmt.Sprintf("%v %v %v\r\nHost: %v\r\n", clientRequest.Method, clientRequest.URL.String(), clientRequest.Proto, clientRequest.Host)
 	if _, err := io.WriteString(targetConn, requestLine); err != nil {
-		logger.Printf("Could not write the WS request: %v", err)
+		logger.Warn("Could not write the WS request", "error", err)
 		http.Error(clientResponse, fmt.Sprintf("Could not write the WS request: %v %v", clientRequest.URL.Host, err), 500)
 		return true
 	}
 	headerBuffer := new(bytes.Buffer)
 	if err := clientRequest.Header.Write(headerBuffer); err != nil {
-		logger.Println("Could not write WS header to buffer", err)
+		logger.Warn("Could not write WS header to buffer", "error", err)
 		http.Error(clientResponse, fmt.Sprintf("Could not write the WS header: %v %v", clientRequest.URL.Host, err), 500)
 		return true
 	}
 	_, err = headerBuffer.WriteTo(targetConn)
 	if err != nil {
-		logger.Println("Could not write WS header to target", err)
+		logger.Warn("Could not write WS header to target", "error", err)
 		http.Error(clientResponse, fmt.Sprintf("Could not write the final header line: %v %v", clientRequest.URL.Host, err), 500)
 		return true
 	}
 	_, err = io.WriteString(targetConn, "\r\n")
 	if err != nil {
-		logger.Println("Could not complete WS header", err)
+		logger.Warn("Could not complete WS header", "error", err)
 		http.Error(clientResponse, fmt.Sprintf("Could not write the final header line: %v %v", clientRequest.URL.Host, err), 500)
 		return true
 	}
 
 	hij, ok := clientResponse.(http.Hijacker)
 	if !ok {
-		logger.Println("httpserver does not support hijacking")
+		logger.Warn("HTTP server does not support hijacking")
 		http.Error(clientResponse, "Does not support hijacking", 500)
 		return true
 	}
 
-	clientConn, _, err := hij.Hijack()
+	clientConn, clientBuffer, err := hij.Hijack()
 	if err != nil {
-		logger.Println("Cannot hijack connection ", err)
+		logger.Warn("Cannot hijack connection", "error", err)
 		http.Error(clientResponse, "Could not hijack", 500)
 		return true
 	}
 
-	// And then relay everything between the client and target
-	go transfer(targetConn, clientConn)
-	transfer(clientConn, targetConn)
+	if !handler.tunnels.Add(clientConn, targetConn) {
+		// The relay is shutting down.
+		clientConn.Close()
+		targetConn.Close()
+		return true
+	}
+	defer handler.tunnels.Remove(clientConn, targetConn)
+
+	if len(webSocketPlugins) == 0 {
+		// And then relay everything between the client and target
+		go transfer(targetConn, clientConn)
+		transfer(clientConn, targetConn)
+		return true
+	}
+
+	// Relay the target's handshake response to the client. If the target
+	// agreed to switch protocols, relay messages until either side hangs up.
+	targetReader := bufio.NewReader(targetConn)
+	if upgraded := relayWebSocketHandshake(clientConn, targetReader, clientRequest); !upgraded {
+		clientConn.Close()
+		targetConn.Close()
+		return true
+	}
+
+	go handler.relayWebSocketMessages(clientConn, targetConn, targetReader, TargetToClient, webSocketPlugins, info)
+	handler.relayWebSocketMessages(targetConn, clientConn, clientBuffer.Reader, ClientToTarget, webSocketPlugins, info)
 	return true
 }
 
+// dialTarget opens a connection to the target for a websocket, using the same
+// timeouts and TLS configuration as ordinary HTTP requests.
+func (handler *Handler) dialTarget(ctx context.Context, targetURL *url.URL) (net.Conn, error) {
+	options := handler.config.Upstream
+	conn, err := newDialer(options).DialContext(ctx, "tcp", targetURL.Host)
+	if err != nil || targetURL.Scheme != "https" {
+		return conn, err
+	}
+
+	tlsConfig := options.TLSConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = targetURL.Hostname()
+	}
+
+	handshakeCtx := ctx
+	if options.TLSHandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		handshakeCtx, cancel = context.WithTimeout(ctx, options.TLSHandshakeTimeout)
+		defer cancel()
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(handshakeCtx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// webSocketPlugins returns the plugins that implement WebSocketPlugin, in
+// plugin chain order.
+func (handler *Handler) webSocketPlugins(info RequestInfo) []WebSocketPlugin {
+	var webSocketPlugins []WebSocketPlugin
+	for _, trafficPlugin := range handler.pluginChain(info) {
+		if webSocketPlugin, ok := trafficPlugin.(WebSocketPlugin); ok {
+			webSocketPlugins = append(webSocketPlugins, webSocketPlugin)
+		}
+	}
+	return webSocketPlugins
+}
+
+// relayWebSocketHandshake reads the target's response to the upgrade request
+// and writes it to the client. It returns true if the target switched
+// protocols.
+func relayWebSocketHandshake(clientConn net.Conn, targetReader *bufio.Reader, clientRequest *http.Request) bool {
+	targetResponse, err := http.ReadResponse(targetReader, clientRequest)
+	if err != nil {
+		logger.Warn("Could not read WS handshake response from target", "error", err)
+		return false
+	}
+
+	if targetResponse.StatusCode != http.StatusSwitchingProtocols {
+		defer targetResponse.Body.Close()
+		if err := targetResponse.Write(clientConn); err != nil {
+			logger.Warn("Could not relay WS handshake response to client", "error", err)
+		}
+		return false
+	}
+
+	// Write the response by hand; http.Response#Write would add a
+	// "Connection: close" header to a response without a body.
+	statusLine := fmt.Sprintf("HTTP/%d.%d %v\r\n", targetResponse.ProtoMajor, targetResponse.ProtoMinor, targetResponse.Status)
+	if _, err := io.WriteString(clientConn, statusLine); err != nil {
+		logger.Warn("Could not relay WS handshake response to client", "error", err)
+		return false
+	}
+	if err := targetResponse.Header.Write(clientConn); err != nil {
+		logger.Warn("Could not relay WS handshake header to client", "error", err)
+		return false
+	}
+	if _, err := io.WriteString(clientConn, "\r\n"); err != nil {
+		logger.Warn("Could not complete WS handshake response", "error", err)
+		return false
+	}
+	return true
+}
+
+// relayWebSocketMessages reads WebSocket frames from the source, reassembles
+// them into messages, gives plugins an opportunity to handle each message, and
+// then writes the resulting messages to the destination. Control frames are
+// relayed immediately, even in the middle of a fragmented message.
+func (handler *Handler) relayWebSocketMessages(
+	destination net.Conn,
+	sourceConn net.Conn,
+	source io.Reader,
+	direction WebSocketDirection,
+	webSocketPlugins []WebSocketPlugin,
+	info RequestInfo,
+) {
+	defer destination.Close()
+	defer sourceConn.Close()
+
+	// Frames sent by the client must be masked; frames sent by the target
+	// must not be. The frames read from the source are masked exactly when the
+	// frames written to the destination must be.
+	masked := direction == ClientToTarget
+
+	var message *WebSocketMessage
+	for {
+		frame, err := readWebSocketFrame(source, handler.config.MaxBodySize, masked)
+		if err != nil {
+			if errors.Is(err, errWebSocketProtocol) {
+				// Fail the connection, telling the peer that broke the protocol
+				// why.
+				logger.Warn("Closing WS connection: protocol error", "direction", direction, "error", err)
+				writeWebSocketClose(sourceConn, wsCloseProtocolError, !masked)
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				logger.Warn("Error reading WS frame", "direction", direction, "error", err)
+			}
+			return
+		}
+
+		if frame.isControl() {
+			if err := writeWebSocketFrame(destination, frame, masked); err != nil {
+				logger.Warn("Error relaying WS control frame", "direction", direction, "error", err)
+				return
+			}
+			continue
+		}
+
+		switch {
+		case frame.opcode == wsOpcodeContinuation && message != nil:
+			if int64(len(message.Data)+len(frame.payload)) > handler.config.MaxBodySize {
+				logger.Warn("Closing WS connection: message exceeds maximum size", "direction", direction)
+				return
+			}
+			message.Data = append(message.Data, frame.payload...)
+		case (frame.opcode == wsOpcodeText || frame.opcode == wsOpcodeBinary) && message == nil:
+			message = &WebSocketMessage{
+				Direction: direction,
+				Type:      WebSocketMessageType(frame.opcode),
+				Data:      frame.payload,
+			}
+		default:
+			logger.Warn("Closing WS connection: unexpected frame", "direction", direction, "opcode", fmt.Sprintf("%x", frame.opcode))
+			return
+		}
+
+		if !frame.fin {
+			continue
+		}
+
+		action := PassMessage
+		for _, webSocketPlugin := range webSocketPlugins {
+			if action = webSocketPlugin.HandleWebSocketMessage(message, info); action == DropMessage {
+				break
+			}
+		}
+
+		if action == PassMessage {
+			if err := writeWebSocketFrame(destination, &wsFrame{
+				fin:     true,
+				opcode:  byte(message.Type),
+				payload: message.Data,
+			}, masked); err != nil {
+				logger.Warn("Error relaying WS message", "direction", direction, "error", err)
+				return
+			}
+		}
+		message = nil
+	}
+}
+
 func transfer(destination io.WriteCloser, source io.ReadCloser) {
 	defer destination.Close()
 	defer source.Close()
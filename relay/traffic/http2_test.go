@@ -0,0 +1,206 @@
+package traffic_test
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/relay-core/catcher"
+	"github.com/fullstorydev/relay-core/relay"
+	"github.com/fullstorydev/relay-core/relay/test"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newProtoTarget returns a handler that responds with the protocol version of
+// the request it received.
+func newProtoTarget() http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Write([]byte(request.Proto))
+	})
+}
+
+func TestHTTP2FromClients(t *testing.T) {
+	client := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, addr)
+			},
+		},
+	}
+
+	configYaml := `relay:
+                      http2: true
+    `
+
+	test.WithCatcherAndRelay(t, configYaml, nil, func(catcherService *catcher.Service, relayService *relay.Service) {
+		response, err := client.Get(relayService.HttpUrl())
+		if err != nil {
+			t.Errorf("Error GETing via h2c: %v", err)
+			return
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != 200 || response.Proto != "HTTP/2.0" {
+			t.Errorf("Expected 200 HTTP/2.0 response: %v", response)
+		}
+	})
+}
+
+func TestHTTP2ToTargets(t *testing.T) {
+	tlsTarget := httptest.NewUnstartedServer(newProtoTarget())
+	tlsTarget.EnableHTTP2 = true
+	tlsTarget.StartTLS()
+	defer tlsTarget.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsTarget.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPem, 0600); err != nil {
+		t.Fatalf("Error writing CA file: %v", err)
+	}
+
+	h2cTarget := httptest.NewServer(h2c.NewHandler(newProtoTarget(), &http2.Server{}))
+	defer h2cTarget.Close()
+
+	testCases := []struct {
+		desc          string
+		target        string
+		mode          string
+		expectedProto string
+	}{
+		{
+			desc:          "HTTP/1.1 is used by default",
+			target:        tlsTarget.URL,
+			mode:          "off",
+			expectedProto: "HTTP/1.1",
+		},
+		{
+			desc:          "HTTP/2 is negotiated with TLS targets",
+			target:        tlsTarget.URL,
+			mode:          "on",
+			expectedProto: "HTTP/2.0",
+		},
+		{
+			desc:          "h2c is used with unencrypted targets",
+			target:        h2cTarget.URL,
+			mode:          "h2c",
+			expectedProto: "HTTP/2.0",
+		},
+	}
+
+	for _, testCase := range testCases {
+		config := fmt.Sprintf(`
+relay:
+  target: %v
+upstream:
+  ca-file: %v
+  http2: %v
+`, testCase.target, caFile, testCase.mode)
+
+		test.WithRelay(t, config, nil, func(relayService *relay.Service) {
+			response, err := http.Get(relayService.HttpUrl())
+			if err != nil {
+				t.Errorf("Test '%v': Error GETing: %v", testCase.desc, err)
+				return
+			}
+			defer response.Body.Close()
+
+			body, _ := io.ReadAll(response.Body)
+			if string(body) != testCase.expectedProto {
+				t.Errorf("Test '%v': Expected %v but target received %v", testCase.desc, testCase.expectedProto, string(body))
+			}
+		})
+	}
+}
+
+func TestStreamedResponsesAreFlushed(t *testing.T) {
+	release := make(chan struct{})
+	target := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Write([]byte("first"))
+		response.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+		}
+		response.Write([]byte("second"))
+	}))
+	defer target.Close()
+	defer close(release)
+
+	config := fmt.Sprintf(`
+relay:
+  target: %v
+`, target.URL)
+
+	test.WithRelay(t, config, nil, func(relayService *relay.Service) {
+		response, err := http.Get(relayService.HttpUrl())
+		if err != nil {
+			t.Errorf("Error GETing: %v", err)
+			return
+		}
+		defer response.Body.Close()
+
+		received := make(chan string, 1)
+		go func() {
+			buffer := make([]byte, len("first"))
+			io.ReadFull(response.Body, buffer)
+			received <- string(buffer)
+		}()
+
+		select {
+		case chunk := <-received:
+			if chunk != "first" {
+				t.Errorf("Unexpected first chunk: %v", chunk)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("First chunk wasn't relayed before the response completed")
+		}
+	})
+}
+
+func TestTrailersAreRelayed(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		// Echo the request trailer back as a response trailer.
+		io.ReadAll(request.Body)
+		response.Header().Set("Trailer", "X-Checksum")
+		response.Write([]byte("body"))
+		response.Header().Set("X-Checksum", request.Trailer.Get("X-Request-Checksum"))
+	}))
+	defer target.Close()
+
+	config := fmt.Sprintf(`
+relay:
+  target: %v
+`, target.URL)
+
+	test.WithRelay(t, config, nil, func(relayService *relay.Service) {
+		// Hiding the length of the body causes it to be chunked, which
+		// allows trailers to be sent.
+		request, _ := http.NewRequest("POST", relayService.HttpUrl(), io.MultiReader(strings.NewReader("payload")))
+		request.Trailer = http.Header{"X-Request-Checksum": {"abc123"}}
+
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Errorf("Error POSTing: %v", err)
+			return
+		}
+		defer response.Body.Close()
+
+		io.ReadAll(response.Body)
+		if checksum := response.Trailer.Get("X-Checksum"); checksum != "abc123" {
+			t.Errorf("Expected trailer to be relayed in both directions, got: %v", response.Trailer)
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package traffic
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fullstorydev/relay-core/relay/metrics"
+)
+
+var (
+	requestsTotal = metrics.NewCounter(
+		"relay_requests_total",
+		"Requests handled by the relay.",
+		"method", "status", "route",
+	)
+	requestDuration = metrics.NewHistogram(
+		"relay_request_duration_seconds",
+		"Time taken to handle requests, including relaying them to the target.",
+		metrics.DefaultBuckets,
+		"method", "status", "route",
+	)
+	requestBytes = metrics.NewCounter(
+		"relay_request_bytes_total",
+		"Bytes of request bodies received from clients.",
+	)
+	responseBytes = metrics.NewCounter(
+		"relay_response_bytes_total",
+		"Bytes of response bodies sent to clients.",
+	)
+	upstreamErrors = metrics.NewCounter(
+		"relay_upstream_errors_total",
+		"Errors sending requests to the target, by kind: connect, timeout, reset, circuit_open, or other.",
+		"kind",
+	)
+	activeTunnels = metrics.NewGauge(
+		"relay_websocket_tunnels_active",
+		"WebSocket connections currently being relayed.",
+	)
+	bodyLimitRejections = metrics.NewCounter(
+		"relay_body_limit_rejections_total",
+		"Requests and responses rejected for exceeding a body size limit: request, decoded_request, or response.",
+		"limit",
+	)
+)
+
+// upstreamErrorKind classifies an error sending a request to the target.
+func upstreamErrorKind(err error) string {
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, errCircuitOpen):
+		return "circuit_open"
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return "connect"
+	case isTimeout(err):
+		return "timeout"
+	case errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return "reset"
+	default:
+		return "other"
+	}
+}
+
+// routeName returns the name of the route that a request with the provided
+// path belongs to, or "other" if it doesn't belong to any route.
+func (handler *Handler) routeName(path string) string {
+	for _, route := range handler.config.Routes {
+		if route.Path.MatchString(path) {
+			return route.Name
+		}
+	}
+	return "other"
+}
+
+// methodLabel returns the request method, unless it's nonstandard, in which
+// case it returns "other". This keeps arbitrary methods from creating new
+// metric series.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "other"
+	}
+}
+
+// recordRequestMetrics records the outcome of a request handled by the relay.
+func (handler *Handler) recordRequestMetrics(request *http.Request, route string, recorder *responseRecorder, body *RequestBody, start time.Time) {
+	status := strconv.Itoa(recorder.Status())
+	method := methodLabel(request.Method)
+	requestsTotal.Inc(method, status, route)
+	requestDuration.Observe(time.Since(start).Seconds(), method, status, route)
+	responseBytes.Add(float64(recorder.bytes))
+
+	if recorder.Status() == http.StatusRequestEntityTooLarge {
+		if body != nil && body.exceededDecodedLimit() {
+			bodyLimitRejections.Inc("decoded_request")
+		} else {
+			bodyLimitRejections.Inc("request")
+		}
+	}
+}
+
+// countOversizeResponse records a target response that exceeded MaxBodySize.
+func (handler *Handler) countOversizeResponse() {
+	handler.oversizeResponses.Add(1)
+	bodyLimitRejections.Inc("response")
+}
+
+// responseRecorder wraps the client response to record its status and the
+// number of body bytes written. http.ResponseController can see through it to
+// the underlying response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func newResponseRecorder(response http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: response}
+}
+
+func (recorder *responseRecorder) WriteHeader(status int) {
+	// Informational responses may precede the final response.
+	if recorder.status == 0 && status >= 200 {
+		recorder.status = status
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *responseRecorder) Write(buffer []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	n, err := recorder.ResponseWriter.Write(buffer)
+	recorder.bytes += int64(n)
+	return n, err
+}
+
+// Hijack takes over the client connection, as for a WebSocket. The relay only
+// hijacks connections once the target has agreed to switch protocols, so the
+// response is recorded as a 101.
+func (recorder *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buffer, err := http.NewResponseController(recorder.ResponseWriter).Hijack()
+	if err == nil && recorder.status == 0 {
+		recorder.status = http.StatusSwitchingProtocols
+	}
+	return conn, buffer, err
+}
+
+func (recorder *responseRecorder) Unwrap() http.ResponseWriter {
+	return recorder.ResponseWriter
+}
+
+// Status returns the status of the response, or 200 if nothing has been
+// written yet, since that's what the server will send.
+func (recorder *responseRecorder) Status() int {
+	if recorder.status == 0 {
+		return http.StatusOK
+	}
+	return recorder.status
+}
+
+// countingReader counts the bytes read from a request body, both in the
+// relay's metrics and for the request's access log entry.
+type countingReader struct {
+	io.ReadCloser
+	count *atomic.Int64
+}
+
+func (reader countingReader) Read(buffer []byte) (int, error) {
+	n, err := reader.ReadCloser.Read(buffer)
+	requestBytes.Add(float64(n))
+	reader.count.Add(int64(n))
+	return n, err
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -0,0 +1,152 @@
+package traffic_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/fullstorydev/relay-core/catcher"
+	"github.com/fullstorydev/relay-core/relay"
+	"github.com/fullstorydev/relay-core/relay/test"
+)
+
+func TestRequestMetrics(t *testing.T) {
+	configYaml := `relay:
+  max-request-body-size: 10
+  routes:
+    - name: bundle
+      path: '^/rec/bundle'
+metrics:
+  public: true
+`
+
+	test.WithCatcherAndRelay(t, configYaml, nil, func(catcherService *catcher.Service, relayService *relay.Service) {
+		testCases := []struct {
+			desc          string
+			method        string
+			path          string
+			body          string
+			expectedDelta map[string]float64
+		}{
+			{
+				desc:   "Requests are counted by route",
+				method: "POST",
+				path:   "/rec/bundle",
+				body:   "hello",
+				expectedDelta: map[string]float64{
+					`relay_requests_total{method="POST",status="200",route="bundle"}`:                 1,
+					`relay_request_duration_seconds_count{method="POST",status="200",route="bundle"}`: 1,
+					`relay_request_bytes_total`: 5,
+				},
+			},
+			{
+				desc:   "Requests outside any route are counted as 'other'",
+				method: "GET",
+				path:   "/foo",
+				expectedDelta: map[string]float64{
+					`relay_requests_total{method="GET",status="200",route="other"}`: 1,
+				},
+			},
+			{
+				desc:   "Oversize request bodies are counted",
+				method: "POST",
+				path:   "/rec/bundle",
+				body:   "this body is too large",
+				expectedDelta: map[string]float64{
+					`relay_requests_total{method="POST",status="413",route="bundle"}`: 1,
+					`relay_body_limit_rejections_total{limit="request"}`:              1,
+				},
+			},
+		}
+
+		for _, testCase := range testCases {
+			before := map[string]float64{}
+			for series := range testCase.expectedDelta {
+				before[series] = test.MetricValue(series)
+			}
+
+			request, err := http.NewRequest(testCase.method, relayService.HttpUrl()+testCase.path, strings.NewReader(testCase.body))
+			if err != nil {
+				t.Errorf("Test '%v': Error creating request: %v", testCase.desc, err)
+				continue
+			}
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Errorf("Test '%v': Error sending request: %v", testCase.desc, err)
+				continue
+			}
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+
+			for series, expectedDelta := range testCase.expectedDelta {
+				if delta := test.MetricValue(series) - before[series]; delta != expectedDelta {
+					t.Errorf("Test '%v': Expected %v to increase by %v but it increased by %v", testCase.desc, series, expectedDelta, delta)
+				}
+			}
+		}
+
+		// The metrics should be exposed for Prometheus.
+		response, err := http.Get(relayService.HttpUrl() + relay.MonitorPath + "metrics")
+		if err != nil {
+			t.Errorf("Error GETing metrics: %v", err)
+			return
+		}
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		if !strings.Contains(string(body), `relay_requests_total{method="GET",status="200",route="other"}`) {
+			t.Errorf("Expected metrics page to include request counts: %s", body)
+		}
+	})
+}
+
+func TestUpstreamErrorMetrics(t *testing.T) {
+	// Find a port that nothing is listening on.
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	configYaml := fmt.Sprintf(`relay:
+                                    target: http://%v
+    `, address)
+
+	test.WithRelay(t, configYaml, nil, func(relayService *relay.Service) {
+		series := `relay_upstream_errors_total{kind="connect"}`
+		before := test.MetricValue(series)
+
+		response, err := http.Get(relayService.HttpUrl())
+		if err != nil {
+			t.Errorf("Error GETing: %v", err)
+			return
+		}
+		response.Body.Close()
+
+		if delta := test.MetricValue(series) - before; delta != 1 {
+			t.Errorf("Expected %v to increase by 1 but it increased by %v", series, delta)
+		}
+	})
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -1,20 +1,328 @@
 package traffic
 
+import (
+	"crypto/tls"
+	"fmt"
+	"regexp"
+	"time"
+)
+
 // RelayOptions contains configuration options for the core relay code.
 //
 // It's preferable to keep the core relay code simple; before adding a new
 // option here, consider whether you could implement the same functionality as a
 // plugin.
 type RelayOptions struct {
-	MaxBodySize  int64  // Maximum length in bytes of relayed bodies.
-	TargetHost   string // The host to relay traffic to. (e.g. 192.168.0.1:1234)
-	TargetScheme string // The scheme ('http' or 'https') to use to communicate with the target host.
+	MaxBodySize               int64                  // Maximum length in bytes of relayed bodies.
+	MaxRequestBodySize        int64                  // Maximum length in bytes of request bodies, as received.
+	MaxDecodedRequestBodySize int64                  // Maximum length in bytes of request bodies, after decompression.
+	OversizeResponseAction    OversizeResponseAction // What to do with streamed responses larger than MaxBodySize.
+	TargetHost                string                 // The host to relay traffic to. (e.g. 192.168.0.1:1234)
+	TargetScheme              string                 // The scheme ('http' or 'https') to use to communicate with the target host.
+	Targets                   []*TargetOptions       // A pool of targets to relay traffic to. If empty, TargetHost and TargetScheme are used.
+	Upstream                  *UpstreamOptions       // Options for connections to the target.
+	Routes                    []*Route               // Named groups of requests, used to label metrics.
+	RequestTimeout            time.Duration          // Deadline for handling each request, including its plugins. Zero means no limit. WebSocket connections aren't limited.
+}
+
+// Route is a named group of requests, identified by their path. A request
+// belongs to the first route whose Path matches its original URL path.
+type Route struct {
+	Name string
+	Path *regexp.Regexp
+}
+
+// TargetOptions describes one of the targets in a pool.
+type TargetOptions struct {
+	Host   string // The host to relay traffic to. (e.g. 192.168.0.1:1234)
+	Scheme string // The scheme ('http' or 'https') to use to communicate with the host.
+	Backup bool   // If true, this target is only used when no primary target is available.
+}
+
+// UpstreamOptions contains configuration options for the connections that the
+// relay makes to the target.
+type UpstreamOptions struct {
+	DialTimeout           time.Duration // Maximum time to wait for a connection to be established.
+	TLSHandshakeTimeout   time.Duration // Maximum time to wait for a TLS handshake.
+	ResponseHeaderTimeout time.Duration // Maximum time to wait for response headers once the request is sent. Zero means no limit.
+	Timeout               time.Duration // Maximum time for an entire request, including the response body. Zero means no limit.
+	IdleConnTimeout       time.Duration // How long idle connections are kept open for reuse.
+	MaxIdleConns          int           // Maximum number of idle connections across all hosts. Zero means no limit.
+	MaxIdleConnsPerHost   int           // Maximum number of idle connections per host. Zero means http.DefaultMaxIdleConnsPerHost.
+	MaxConnsPerHost       int           // Maximum number of connections per host. Zero means no limit.
+	TLSConfig             *tls.Config   // TLS configuration (CAs, client certificates, etc.) for connections to the target.
+	HTTP2                 UpstreamHTTP2 // Whether to use HTTP/2 for connections to the target.
+
+	LoadBalancing LoadBalancingPolicy // How requests are distributed among the targets in a pool.
+	HashHeader    string              // For consistent hashing, the request header to hash. If empty, the client IP is used.
+	MaxFails      int                 // Consecutive failures after which a target is ejected from the pool. Zero disables ejection.
+	FailTimeout   time.Duration       // How long an ejected target stays out of the pool.
+	HealthCheck   *HealthCheckOptions // Active health checking for the targets in a pool. Nil disables health checks.
+	Retry         *RetryOptions       // The policy for retrying failed requests. Nil disables retries.
+
+	CircuitBreaker *CircuitBreakerOptions // Per-host circuit breakers. Nil disables circuit breakers.
+}
+
+// CircuitBreakerOptions configures the circuit breakers that protect the relay
+// from slow or failing target hosts. Each host gets its own breaker. A breaker
+// opens when, within Window, at least MinRequests requests were sent and the
+// fraction that failed reaches ErrorThreshold; requests that fail with an
+// error, receive a 5xx response, or take longer than LatencyThreshold count as
+// failures. While a breaker is open, requests to its host fail fast with
+// ResponseStatus and ResponseBody. After OpenDuration, the breaker lets
+// HalfOpenRequests requests through; if they all succeed, it closes again.
+type CircuitBreakerOptions struct {
+	Window           time.Duration // The period over which requests are counted.
+	MinRequests      int           // The minimum number of requests in a window before the breaker may open.
+	ErrorThreshold   float64       // The fraction of failed requests (0 to 1) that opens the breaker.
+	LatencyThreshold time.Duration // Requests slower than this count as failures. Zero disables this check.
+	OpenDuration     time.Duration // How long the breaker stays open before letting requests through again.
+	HalfOpenRequests int           // The number of trial requests that must succeed to close the breaker.
+	ResponseStatus   int           // The status sent to clients while the breaker is open.
+	ResponseBody     string        // The body sent to clients while the breaker is open.
+}
+
+// UpstreamHTTP2 determines whether the relay uses HTTP/2 to communicate with
+// the target.
+type UpstreamHTTP2 int
+
+const (
+	// HTTP2Off uses HTTP/1.1 for all targets.
+	HTTP2Off UpstreamHTTP2 = iota
+
+	// HTTP2Negotiate uses HTTP/2 for 'https' targets that offer it during the
+	// TLS handshake, and HTTP/1.1 otherwise.
+	HTTP2Negotiate
+
+	// HTTP2PriorKnowledge uses unencrypted HTTP/2 ("h2c") for 'http' targets,
+	// which must support it. 'https' targets are treated as for
+	// HTTP2Negotiate.
+	HTTP2PriorKnowledge
+)
+
+func ParseUpstreamHTTP2(value string) (UpstreamHTTP2, error) {
+	switch value {
+	case "off":
+		return HTTP2Off, nil
+	case "on":
+		return HTTP2Negotiate, nil
+	case "h2c":
+		return HTTP2PriorKnowledge, nil
+	default:
+		return HTTP2Off, fmt.Errorf(`unknown HTTP/2 mode "%v"; expected "off", "on", or "h2c"`, value)
+	}
+}
+
+func (mode UpstreamHTTP2) String() string {
+	switch mode {
+	case HTTP2Off:
+		return "off"
+	case HTTP2Negotiate:
+		return "on"
+	case HTTP2PriorKnowledge:
+		return "h2c"
+	default:
+		return "(unknown mode)"
+	}
+}
+
+// RetryOptions configures automatic retries of failed requests. Requests with
+// idempotent methods are retried; other requests are only retried if their
+// path matches one of ReplaySafePaths, which is useful for beacon-style POST
+// requests that the target deduplicates.
+type RetryOptions struct {
+	MaxAttempts     int              // The maximum number of attempts, including the first.
+	Backoff         time.Duration    // The base delay between attempts; it doubles with each retry.
+	MaxBackoff      time.Duration    // The maximum delay between attempts.
+	StatusCodes     []int            // Target response statuses which trigger a retry.
+	Errors          RetryErrorClass  // The kinds of errors which trigger a retry.
+	ReplaySafePaths []*regexp.Regexp // Paths for which non-idempotent requests may be retried.
+}
+
+// RetryErrorClass is a set of flags describing kinds of errors that may be
+// encountered when sending a request to the target.
+type RetryErrorClass int
+
+const (
+	// RetryConnectErrors covers failures to connect to the target. The request
+	// was never sent, so these are always safe to retry.
+	RetryConnectErrors RetryErrorClass = 1 << iota
+
+	// RetryTimeoutErrors covers timeouts waiting for the target's response.
+	RetryTimeoutErrors
+
+	// RetryResetErrors covers connections closed or reset by the target before
+	// it sent a response.
+	RetryResetErrors
+)
+
+func ParseRetryErrorClass(value string) (RetryErrorClass, error) {
+	switch value {
+	case "connect":
+		return RetryConnectErrors, nil
+	case "timeout":
+		return RetryTimeoutErrors, nil
+	case "reset":
+		return RetryResetErrors, nil
+	default:
+		return 0, fmt.Errorf(`unknown error class "%v"; expected "connect", "timeout", or "reset"`, value)
+	}
+}
+
+// HealthCheckOptions configures active health checks. Each target is sent a
+// GET request for Path every Interval; a target is considered healthy if it
+// responds with a 2xx or 3xx status.
+type HealthCheckOptions struct {
+	Path               string        // The path to request.
+	Interval           time.Duration // How often to check each target.
+	Timeout            time.Duration // How long to wait for each check to complete.
+	HealthyThreshold   int           // Consecutive successful checks needed to mark an unhealthy target healthy.
+	UnhealthyThreshold int           // Consecutive failed checks needed to mark a healthy target unhealthy.
 }
 
-const DefaultMaxBodySize int64 = 1024 * 2048 // 2MB
+// LoadBalancingPolicy determines how the relay selects a target from a pool.
+type LoadBalancingPolicy int
+
+const (
+	// RoundRobin cycles through the available targets in order.
+	RoundRobin LoadBalancingPolicy = iota
+
+	// LeastConnections selects the available target with the fewest requests
+	// in flight.
+	LeastConnections
+
+	// ConsistentHash selects a target based on a hash of the client IP (or of
+	// HashHeader), so a given client sticks to the same target for as long as
+	// it's available.
+	ConsistentHash
+)
+
+func ParseLoadBalancingPolicy(value string) (LoadBalancingPolicy, error) {
+	switch value {
+	case "round-robin":
+		return RoundRobin, nil
+	case "least-connections":
+		return LeastConnections, nil
+	case "consistent-hash":
+		return ConsistentHash, nil
+	default:
+		return RoundRobin, fmt.Errorf(`unknown policy "%v"; expected "round-robin", "least-connections", or "consistent-hash"`, value)
+	}
+}
+
+func (policy LoadBalancingPolicy) String() string {
+	switch policy {
+	case RoundRobin:
+		return "round-robin"
+	case LeastConnections:
+		return "least-connections"
+	case ConsistentHash:
+		return "consistent-hash"
+	default:
+		return "(unknown policy)"
+	}
+}
+
+// OversizeResponseAction determines how the relay handles a target response
+// whose body turns out to be larger than MaxBodySize. If the target provides a
+// Content-Length, oversize responses are always detected before anything is
+// sent to the client, and the relay responds with a 503 error; this option
+// only matters for responses that are streamed without a Content-Length.
+type OversizeResponseAction int
+
+const (
+	// AbortOversizeResponse relays the response as it streams in and aborts
+	// the connection to the client once the limit is exceeded, so the client
+	// sees an incomplete response rather than a truncated one.
+	AbortOversizeResponse OversizeResponseAction = iota
+
+	// TruncateOversizeResponse relays the response up to the limit and then
+	// silently ends it.
+	TruncateOversizeResponse
+
+	// RejectOversizeResponse buffers the response body, up to the limit,
+	// before sending anything to the client, so that an oversize response can
+	// be replaced with a 502 error.
+	RejectOversizeResponse
+)
+
+func ParseOversizeResponseAction(value string) (OversizeResponseAction, error) {
+	switch value {
+	case "abort":
+		return AbortOversizeResponse, nil
+	case "truncate":
+		return TruncateOversizeResponse, nil
+	case "reject":
+		return RejectOversizeResponse, nil
+	default:
+		return AbortOversizeResponse, fmt.Errorf(`unknown action "%v"; expected "abort", "truncate", or "reject"`, value)
+	}
+}
+
+func (action OversizeResponseAction) String() string {
+	switch action {
+	case AbortOversizeResponse:
+		return "abort"
+	case TruncateOversizeResponse:
+		return "truncate"
+	case RejectOversizeResponse:
+		return "reject"
+	default:
+		return "(unknown action)"
+	}
+}
+
+const DefaultMaxBodySize int64 = 1024 * 2048                    // 2MB
+const DefaultMaxRequestBodySize int64 = 1024 * 1024 * 10        // 10MB
+const DefaultMaxDecodedRequestBodySize int64 = 1024 * 1024 * 50 // 50MB
 
 func NewDefaultRelayOptions() *RelayOptions {
 	return &RelayOptions{
-		MaxBodySize: DefaultMaxBodySize,
+		MaxBodySize:               DefaultMaxBodySize,
+		MaxRequestBodySize:        DefaultMaxRequestBodySize,
+		MaxDecodedRequestBodySize: DefaultMaxDecodedRequestBodySize,
+		Upstream:                  NewDefaultUpstreamOptions(),
+	}
+}
+
+func NewDefaultUpstreamOptions() *UpstreamOptions {
+	return &UpstreamOptions{
+		DialTimeout:         30 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     2 * time.Second,
+		TLSConfig:           &tls.Config{},
+		FailTimeout:         30 * time.Second,
+	}
+}
+
+func NewDefaultRetryOptions() *RetryOptions {
+	return &RetryOptions{
+		MaxAttempts: 3,
+		Backoff:     100 * time.Millisecond,
+		MaxBackoff:  2 * time.Second,
+		StatusCodes: []int{502, 503, 504},
+		Errors:      RetryConnectErrors | RetryTimeoutErrors | RetryResetErrors,
+	}
+}
+
+func NewDefaultCircuitBreakerOptions() *CircuitBreakerOptions {
+	return &CircuitBreakerOptions{
+		Window:           10 * time.Second,
+		MinRequests:      20,
+		ErrorThreshold:   0.5,
+		OpenDuration:     30 * time.Second,
+		HalfOpenRequests: 1,
+		ResponseStatus:   503,
+		ResponseBody:     "Target is temporarily unavailable",
+	}
+}
+
+func NewDefaultHealthCheckOptions() *HealthCheckOptions {
+	return &HealthCheckOptions{
+		Path:               "/",
+		Interval:           10 * time.Second,
+		Timeout:            2 * time.Second,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 3,
 	}
 }
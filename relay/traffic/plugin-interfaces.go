@@ -1,6 +1,8 @@
 package traffic
 
 import (
+	"context"
+	"log/slog"
 	"net/http"
 	"net/url"
 
@@ -12,23 +14,30 @@ import (
 type PluginFactory interface {
 	// Name returns a human readable name for this plugin, like "logging" or
 	// "attack-detector". This name serves as the YAML key for the plugin's
-	// section of the configuration file.
+	// section of the configuration file. Named instances of the plugin are
+	// configured by sections whose keys are this name followed by a slash and
+	// the instance name, like "block-content/pii".
 	Name() string
 
 	// New configures and returns an instance of this plugin, or an error if
 	// configuration failed. Configuration options are read from the provided
-	// configuration file section.
+	// configuration file section. Plugins should log using the provided logger,
+	// which tags each record with the plugin's name and respects the plugin's
+	// configured log level, rather than creating their own.
 	//
 	// Factories may return nil if the plugin should be inactive given the
 	// provided configuration.
-	New(configSection *config.Section) (Plugin, error)
+	New(configSection *config.Section, logger *slog.Logger) (Plugin, error)
 }
 
 // Plugin is the interface exposed by plugin instances.
 type Plugin interface {
 	// Name returns a human readable name for this plugin, like "Logging" or
-	// "Attack detector". This should match the value returned by the
-	// corresponding PluginFactory#Name().
+	// "Attack detector". This should match the name of the configuration
+	// section the plugin was created from: the value returned by the
+	// corresponding PluginFactory#Name() or, for a named instance of the
+	// plugin, that value followed by a slash and the instance name, like
+	// "block-content/pii".
 	Name() string
 
 	// HandleRequest is invoked to allow a plugin to handle an incoming traffic
@@ -46,6 +55,86 @@ type Plugin interface {
 	) bool
 }
 
+// ResponsePlugin is an optional interface that plugins may implement to
+// inspect or alter the responses that the relay target sends back.
+type ResponsePlugin interface {
+	// HandleResponse is invoked, in plugin chain order, after the relay
+	// receives a response from the target and before anything is written to
+	// the client. It's only invoked for requests that were relayed to the
+	// target; requests serviced by a plugin don't produce a target response.
+	//
+	// Plugins may alter the status code, headers, or body of the response. A
+	// plugin that replaces the body should update response.ContentLength to
+	// match (or set it to -1 if the new length is unknown) and should close
+	// the original body.
+	//
+	// If HandleResponse returns an error, the relay responds to the client
+	// with a 502 error instead of relaying the target response.
+	HandleResponse(
+		response *http.Response,
+		requestInfo RequestInfo,
+	) error
+}
+
+// WebSocketPlugin is an optional interface that plugins may implement to
+// handle the individual messages sent over relayed WebSocket connections.
+type WebSocketPlugin interface {
+	// HandleWebSocketMessage is invoked, in plugin chain order, for each data
+	// message relayed in either direction over a WebSocket connection. Control
+	// messages (ping, pong, and close) are relayed without invoking plugins.
+	//
+	// Plugins may rewrite the message by changing its Data. Returning
+	// DropMessage discards the message; later plugins won't see it.
+	HandleWebSocketMessage(
+		message *WebSocketMessage,
+		requestInfo RequestInfo,
+	) WebSocketMessageAction
+}
+
+// RulesPlugin is an optional interface that plugins may implement to describe
+// their configured rules. The descriptions are reported by the admin service.
+type RulesPlugin interface {
+	// Rules returns a short, human readable description of each rule.
+	Rules() []string
+}
+
+// StartPlugin is an optional interface that plugins may implement to acquire
+// resources, like files, connections, or goroutines, before they handle any
+// requests. Plugins should do this in Start rather than in PluginFactory#New,
+// since New may be invoked just to validate a configuration.
+type StartPlugin interface {
+	// Start is invoked, in plugin chain order, before the plugin handles any
+	// requests. The context limits how long starting may take; it isn't the
+	// lifetime of the plugin, which lasts until Close is invoked. If Start
+	// returns an error, the relay doesn't start or, during a reload, the
+	// existing plugins remain in place.
+	Start(ctx context.Context) error
+}
+
+// ClosePlugin is an optional interface that plugins may implement to release
+// their resources when they stop handling requests.
+type ClosePlugin interface {
+	// Close is invoked once the plugin will handle no more requests: when the
+	// relay shuts down, or when a reload replaces the plugin and the requests
+	// that were using it have finished. Plugins should flush any buffered
+	// data. Close is invoked in reverse plugin chain order, and only if every
+	// plugin in the chain started successfully.
+	Close() error
+}
+
+// ReloadPlugin is an optional interface that plugins may implement to carry
+// state across configuration reloads. A reload creates a new instance of every
+// plugin from the new configuration.
+type ReloadPlugin interface {
+	// Reload is invoked on a new instance of the plugin before it's started,
+	// with the instance of the same name in the same chain that it replaces.
+	// The previous instance keeps handling the requests already in progress
+	// and is closed once they finish, so the new instance should only take
+	// over state that's safe to share, like caches. If Reload returns an
+	// error, the existing plugins remain in place.
+	Reload(ctx context.Context, previous Plugin) error
+}
+
 // RequestInfo provides additional information about incoming requests.
 type RequestInfo struct {
 	// The original cookie headers included in the client request. For security
@@ -57,8 +146,47 @@ type RequestInfo struct {
 	// relay.
 	OriginalURL *url.URL
 
+	// The body of the client request. Plugins that need to inspect or alter
+	// the body should use this rather than reading the request's Body
+	// directly; it decodes the body once, on demand, and lets the relay
+	// forward the original bytes if no plugin changes them.
+	Body *RequestBody
+
 	// If true, a response has already been sent to the client.
 	Serviced bool
+
+	// A unique identifier for the request, which is included in its access
+	// log entry.
+	RequestID string
+
+	// The pool target selected for this request.
+	target *upstreamTarget
+
+	// Details of the request's handling, for the access log.
+	outcome *requestOutcome
+
+	// The request-scoped context. See Context().
+	ctx context.Context
+
+	// The attributes attached to the request by plugins. See AttributeKey.
+	attributes *requestAttributes
+
+	// The plugin chain handling the request. It's captured when the request
+	// arrives, so the whole request is handled by the same plugins even if
+	// the chain is replaced while it's in progress.
+	plugins []Plugin
+}
+
+// Context returns the request-scoped context. It's canceled when the client
+// goes away or the request's deadline, set by the 'request-timeout' option,
+// passes, and it carries the request ID, which RequestIDFromContext returns.
+// Plugins that do work on behalf of the request, like calling another service,
+// should use it.
+func (info RequestInfo) Context() context.Context {
+	if info.ctx == nil {
+		return context.Background()
+	}
+	return info.ctx
 }
 
 /*
@@ -0,0 +1,230 @@
+package traffic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// StartPlugins starts every plugin that implements StartPlugin, in chain order,
+// beginning with the default chain. If a plugin fails to start, the plugins
+// that were already started are closed and the error is returned.
+func (handler *Handler) StartPlugins(ctx context.Context) error {
+	chains := handler.plugins.Load()
+	if err := startPlugins(ctx, chains.plugins()); err != nil {
+		return err
+	}
+	chains.mutex.Lock()
+	defer chains.mutex.Unlock()
+	chains.started = true
+	return nil
+}
+
+// ReplacePlugins replaces the default plugin chain and the plugin routes. The
+// new plugins are given the plugins they replace, if they implement
+// ReloadPlugin, and are started; if any of them fails, the existing plugins
+// remain in place and the error is returned.
+//
+// The change is atomic: requests that are already in progress, including open
+// WebSocket tunnels, continue to use the plugins they started with, and new
+// requests use the new plugins. The replaced plugins are closed once the
+// requests using them have finished.
+func (handler *Handler) ReplacePlugins(ctx context.Context, trafficPlugins []Plugin, pluginRoutes []*PluginRoute) error {
+	previous := handler.plugins.Load()
+	next := &pluginChains{
+		defaultChain: trafficPlugins,
+		routes:       pluginRoutes,
+		started:      true,
+	}
+
+	if err := reloadPlugins(ctx, previous, next); err != nil {
+		return err
+	}
+	if err := startPlugins(ctx, next.plugins()); err != nil {
+		return err
+	}
+
+	handler.plugins.Store(next)
+	previous.retire()
+	return nil
+}
+
+// acquirePlugins returns the handler's current plugin chains, which won't be
+// closed until the returned release function is called.
+func (handler *Handler) acquirePlugins() (*pluginChains, func()) {
+	for {
+		chains := handler.plugins.Load()
+		if chains.acquire() {
+			return chains, chains.release
+		}
+		if handler.plugins.Load() == chains {
+			// The handler has been closed.
+			return chains, func() {}
+		}
+	}
+}
+
+// plugins returns every plugin in the chains: the default chain's, followed by
+// each route's.
+func (chains *pluginChains) plugins() []Plugin {
+	plugins := append([]Plugin{}, chains.defaultChain...)
+	for _, route := range chains.routes {
+		plugins = append(plugins, route.Plugins...)
+	}
+	return plugins
+}
+
+func (chains *pluginChains) acquire() bool {
+	chains.mutex.Lock()
+	defer chains.mutex.Unlock()
+	if chains.retired {
+		return false
+	}
+	chains.active++
+	return true
+}
+
+func (chains *pluginChains) release() {
+	chains.mutex.Lock()
+	chains.active--
+	onIdle := chains.takeOnIdle()
+	chains.mutex.Unlock()
+
+	if onIdle != nil {
+		onIdle()
+	}
+}
+
+// retire marks the chains as no longer in use by new requests. If they were
+// started, their plugins are closed once no requests are using them.
+func (chains *pluginChains) retire() {
+	chains.mutex.Lock()
+	if chains.retired {
+		chains.mutex.Unlock()
+		return
+	}
+	chains.retired = true
+	if chains.started {
+		chains.onIdle = func() {
+			if err := closePlugins(chains.plugins()); err != nil {
+				logger.Warn("Error closing plugins", "error", err)
+			}
+		}
+	}
+	onIdle := chains.takeOnIdle()
+	chains.mutex.Unlock()
+
+	if onIdle != nil {
+		onIdle()
+	}
+}
+
+// takeOnIdle returns the onIdle callback, and clears it, if the chains are
+// retired and idle. The caller must hold the mutex.
+func (chains *pluginChains) takeOnIdle() func() {
+	if !chains.retired || chains.active > 0 {
+		return nil
+	}
+	onIdle := chains.onIdle
+	chains.onIdle = nil
+	return onIdle
+}
+
+// reloadPlugins gives each plugin in the next chains that implements
+// ReloadPlugin the plugin with the same name in the same chain of the previous
+// chains, if there is one.
+func reloadPlugins(ctx context.Context, previous *pluginChains, next *pluginChains) error {
+	reloadChain := func(previousChain []Plugin, nextChain []Plugin) error {
+		for _, trafficPlugin := range nextChain {
+			reloadPlugin, ok := trafficPlugin.(ReloadPlugin)
+			if !ok {
+				continue
+			}
+			previousPlugin := findPlugin(previousChain, trafficPlugin.Name())
+			if previousPlugin == nil {
+				continue
+			}
+			if err := reloadPlugin.Reload(ctx, previousPlugin); err != nil {
+				return fmt.Errorf(`plugin "%s": %w`, trafficPlugin.Name(), err)
+			}
+		}
+		return nil
+	}
+
+	if err := reloadChain(previous.defaultChain, next.defaultChain); err != nil {
+		return err
+	}
+	for _, route := range next.routes {
+		for _, previousRoute := range previous.routes {
+			if previousRoute.Name != route.Name {
+				continue
+			}
+			if err := reloadChain(previousRoute.Plugins, route.Plugins); err != nil {
+				return fmt.Errorf(`route "%s": %w`, route.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func findPlugin(trafficPlugins []Plugin, name string) Plugin {
+	for _, trafficPlugin := range trafficPlugins {
+		if trafficPlugin.Name() == name {
+			return trafficPlugin
+		}
+	}
+	return nil
+}
+
+// startPlugins starts each plugin that implements StartPlugin, in order. If one
+// fails, the plugins before it are closed.
+func startPlugins(ctx context.Context, trafficPlugins []Plugin) error {
+	for i, trafficPlugin := range trafficPlugins {
+		startPlugin, ok := trafficPlugin.(StartPlugin)
+		if !ok {
+			continue
+		}
+		if err := startPlugin.Start(ctx); err != nil {
+			if closeErr := closePlugins(trafficPlugins[:i]); closeErr != nil {
+				logger.Warn("Error closing plugins", "error", closeErr)
+			}
+			return fmt.Errorf(`plugin "%s": %w`, trafficPlugin.Name(), err)
+		}
+	}
+	return nil
+}
+
+// closePlugins closes each plugin that implements ClosePlugin, in reverse
+// order. Every plugin is closed even if some fail; their errors are combined.
+func closePlugins(trafficPlugins []Plugin) error {
+	var errs []error
+	for i := len(trafficPlugins) - 1; i >= 0; i-- {
+		closePlugin, ok := trafficPlugins[i].(ClosePlugin)
+		if !ok {
+			continue
+		}
+		if err := closePlugin.Close(); err != nil {
+			errs = append(errs, fmt.Errorf(`plugin "%s": %w`, trafficPlugins[i].Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -0,0 +1,229 @@
+package traffic_test
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fullstorydev/relay-core/relay"
+	"github.com/fullstorydev/relay-core/relay/config"
+	"github.com/fullstorydev/relay-core/relay/plugins/traffic/test-interceptor-plugin"
+	"github.com/fullstorydev/relay-core/relay/test"
+	"github.com/fullstorydev/relay-core/relay/traffic"
+	"github.com/fullstorydev/relay-core/relay/traffic/plugin-loader"
+)
+
+// lifecycleRecorder records the lifecycle events of test-interceptor plugins.
+type lifecycleRecorder struct {
+	mutex     sync.Mutex
+	events    []string
+	instances map[traffic.Plugin]int
+	failStart bool
+}
+
+func (recorder *lifecycleRecorder) listener(event string, plugin traffic.Plugin, previous traffic.Plugin) error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	if recorder.instances == nil {
+		recorder.instances = map[traffic.Plugin]int{}
+	}
+	id := func(plugin traffic.Plugin) int {
+		if _, ok := recorder.instances[plugin]; !ok {
+			recorder.instances[plugin] = len(recorder.instances) + 1
+		}
+		return recorder.instances[plugin]
+	}
+
+	entry := fmt.Sprintf("%v %v#%v", event, plugin.Name(), id(plugin))
+	if previous != nil {
+		entry += fmt.Sprintf(" from #%v", id(previous))
+	}
+	recorder.events = append(recorder.events, entry)
+
+	if event == "start" && recorder.failStart {
+		return errors.New("start failed")
+	}
+	return nil
+}
+
+func (recorder *lifecycleRecorder) takeEvents() []string {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	events := recorder.events
+	recorder.events = nil
+	return events
+}
+
+func TestPluginLifecycle(t *testing.T) {
+	recorder := &lifecycleRecorder{}
+	pluginFactories := []traffic.PluginFactory{
+		test_interceptor_plugin.NewFactoryWithLifecycleListener(recorder.listener),
+	}
+
+	configYaml := `relay:
+    port: 0
+    target: http://localhost:1
+test-interceptor:
+test-interceptor/second:
+`
+
+	test.WithRelay(t, configYaml, pluginFactories, func(relayService *relay.Service) {
+		expected := []string{"start test-interceptor#1", "start test-interceptor/second#2"}
+		if events := recorder.takeEvents(); !slices.Equal(events, expected) {
+			t.Errorf("Expected events %v at startup, got %v", expected, events)
+		}
+
+		configPath := filepath.Join(t.TempDir(), "relay.yaml")
+		if err := os.WriteFile(configPath, []byte(configYaml), 0644); err != nil {
+			t.Fatalf("Error writing configuration file: %v", err)
+		}
+		reloader := relay.NewReloader(relayService, configPath, pluginFactories)
+		defer reloader.Close()
+		if err := reloader.Reload(); err != nil {
+			t.Errorf("Error reloading: %v", err)
+		}
+
+		// The new instances are given the instances they replace, and then
+		// started; the replaced instances are closed in reverse order.
+		expected = []string{
+			"reload test-interceptor#3 from #1",
+			"reload test-interceptor/second#4 from #2",
+			"start test-interceptor#3",
+			"start test-interceptor/second#4",
+			"close test-interceptor/second#2",
+			"close test-interceptor#1",
+		}
+		if events := recorder.takeEvents(); !slices.Equal(events, expected) {
+			t.Errorf("Expected events %v after reloading, got %v", expected, events)
+		}
+
+		// Requests still work with the new instances.
+		response, err := http.Get(relayService.HttpUrl() + relay.MonitorPath + "live")
+		if err != nil {
+			t.Errorf("Error GETing: %v", err)
+		} else {
+			response.Body.Close()
+		}
+	})
+
+	expected := []string{"close test-interceptor/second#4", "close test-interceptor#3"}
+	if events := recorder.takeEvents(); !slices.Equal(events, expected) {
+		t.Errorf("Expected events %v at shutdown, got %v", expected, events)
+	}
+}
+
+func TestPluginStartError(t *testing.T) {
+	recorder := &lifecycleRecorder{failStart: true}
+	pluginFactories := []traffic.PluginFactory{
+		test_interceptor_plugin.NewFactoryWithLifecycleListener(recorder.listener),
+	}
+
+	configFile, err := config.NewFileFromYamlString(`relay:
+    port: 0
+    target: http://localhost:1
+test-interceptor:
+`)
+	if err != nil {
+		t.Fatalf("Error parsing configuration YAML: %v", err)
+	}
+	options, err := relay.ReadOptions(configFile)
+	if err != nil {
+		t.Fatalf("Error reading options: %v", err)
+	}
+	trafficPlugins, err := plugin_loader.Load(pluginFactories, configFile)
+	if err != nil {
+		t.Fatalf("Error loading plugins: %v", err)
+	}
+
+	relayService := relay.NewService(options.Service, options.Relay, trafficPlugins, nil)
+	err = relayService.Start("localhost", 0)
+	if err == nil || !strings.Contains(err.Error(), "start failed") {
+		t.Errorf("Expected the plugin's start error, got %v", err)
+	}
+	relayService.Close()
+
+	// A plugin that failed to start isn't closed.
+	expected := []string{"start test-interceptor#1"}
+	if events := recorder.takeEvents(); !slices.Equal(events, expected) {
+		t.Errorf("Expected events %v, got %v", expected, events)
+	}
+}
+
+func TestPluginsClosedWhenStartFails(t *testing.T) {
+	// Occupy the port the admin service is configured to listen on.
+	occupied, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer occupied.Close()
+
+	recorder := &lifecycleRecorder{}
+	pluginFactories := []traffic.PluginFactory{
+		test_interceptor_plugin.NewFactoryWithLifecycleListener(recorder.listener),
+	}
+
+	configFile, err := config.NewFileFromYamlString(fmt.Sprintf(`relay:
+    port: 0
+    target: http://localhost:1
+admin:
+    address: %v
+    token: s3cret
+test-interceptor:
+`, occupied.Addr()))
+	if err != nil {
+		t.Fatalf("Error parsing configuration YAML: %v", err)
+	}
+	options, err := relay.ReadOptions(configFile)
+	if err != nil {
+		t.Fatalf("Error reading options: %v", err)
+	}
+	trafficPlugins, err := plugin_loader.Load(pluginFactories, configFile)
+	if err != nil {
+		t.Fatalf("Error loading plugins: %v", err)
+	}
+
+	relayService := relay.NewService(options.Service, options.Relay, trafficPlugins, nil)
+	if err := relayService.Start("localhost", 0); err == nil {
+		relayService.Close()
+		t.Fatalf("Expected an error starting the relay")
+	}
+
+	// The plugins are closed, and the relay's port isn't left open.
+	expected := []string{"start test-interceptor#1", "close test-interceptor#1"}
+	if events := recorder.takeEvents(); !slices.Equal(events, expected) {
+		t.Errorf("Expected events %v, got %v", expected, events)
+	}
+	if address := relayService.Address(); address != "" {
+		if conn, err := net.Dial("tcp", address); err == nil {
+			conn.Close()
+			t.Errorf("Expected the relay's listener to be closed")
+		}
+	}
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -1,33 +1,86 @@
 package plugin_loader
 
 import (
+	"cmp"
 	"fmt"
-	"log"
-	"os"
+	"slices"
+	"strings"
 
 	"github.com/fullstorydev/relay-core/relay/config"
+	"github.com/fullstorydev/relay-core/relay/logging"
 	"github.com/fullstorydev/relay-core/relay/traffic"
 )
 
-var logger = log.New(os.Stdout, "[traffic-plugin-loader] ", 0)
+var logger = logging.For("plugin-loader")
 
-// Load creates and configures a set of traffic plugins.
+// pluginInstance is an instance of a plugin, to be created by a factory. Its
+// name is also the name of its configuration section: the factory's name or,
+// for a named instance, the factory's name followed by a slash and the
+// instance name, like "block-content/pii".
+type pluginInstance struct {
+	name    string
+	factory traffic.PluginFactory
+	index   int // The position of the factory in the list of factories.
+}
+
+// create creates the plugin instance from the provided configuration section.
+// It returns nil if the plugin is inactive.
+func (instance pluginInstance) create(configSection *config.Section) (traffic.Plugin, error) {
+	if !pluginFactoryIsRegistered(instance.factory) {
+		return nil, fmt.Errorf(`Traffic plugin "%v" is not registered; add it to registry.go.`, instance.factory.Name())
+	}
+
+	plugin, err := instance.factory.New(configSection, logging.For(instance.name))
+	if err != nil {
+		return nil, fmt.Errorf("Traffic plugin \"%v\" configuration error: %v", instance.name, err)
+	}
+	return plugin, nil
+}
+
+// Load creates and configures a set of traffic plugins. Each factory creates
+// an instance configured by the section with the factory's name, and a named
+// instance for each section named like "<factory name>/<instance name>". The
+// plugins run in the order in which their factories are provided, and the
+// instances created by each factory run in order of name. The 'order' option
+// of the top-level 'plugin-chains' section can list them in a different order.
 func Load(
 	pluginFactories []traffic.PluginFactory,
 	configFile *config.File,
 ) ([]traffic.Plugin, error) {
-	trafficPlugins := []traffic.Plugin{}
+	order, err := readPluginOrder(configFile)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, factory := range pluginFactories {
-		logger.Printf("Loading plugin: %s\n", factory.Name())
+	instances, err := sortInstances(pluginFactories, instanceNames(pluginFactories, configFile))
+	if err != nil {
+		return nil, err
+	}
+	orderedInstances, err := orderInstances(pluginFactories, instances, order)
+	if err != nil {
+		return nil, err
+	}
 
-		if !pluginFactoryIsRegistered(factory) {
-			return nil, fmt.Errorf(`Traffic plugin "%v" is not registered; add it to registry.go.`, factory.Name())
+	// A plugin that's left out of the order would silently stop running, which
+	// is dangerous for plugins that protect privacy, like block-content.
+	// Reject the configuration instead.
+	for _, instance := range instances {
+		if order == nil || slices.Contains(order, instance.name) {
+			continue
+		}
+		if configSection := configFile.LookupOptionalSection(instance.name); configSection != nil && configSection.HasValues() {
+			return nil, fmt.Errorf(`Traffic plugin "%v" is configured but isn't listed in the plugin order`, instance.name)
 		}
+	}
+
+	trafficPlugins := []traffic.Plugin{}
+
+	for _, instance := range orderedInstances {
+		logger.Info("Loading plugin", "plugin", instance.name)
 
-		plugin, err := factory.New(configFile.GetOrAddSection(factory.Name()))
+		plugin, err := instance.create(configFile.GetOrAddSection(instance.name))
 		if err != nil {
-			return nil, fmt.Errorf("Traffic plugin \"%v\" configuration error: %v", factory.Name(), err)
+			return nil, err
 		}
 
 		if plugin == nil {
@@ -40,6 +93,103 @@ func Load(
 	return trafficPlugins, nil
 }
 
+// instanceNames returns the names of the plugin instances that the top-level
+// sections of the configuration file may configure: each factory's own
+// instance, and the named instances that have sections.
+func instanceNames(pluginFactories []traffic.PluginFactory, configFile *config.File) []string {
+	names := []string{}
+	for _, factory := range pluginFactories {
+		names = append(names, factory.Name())
+	}
+	for _, sectionName := range configFile.SectionNames() {
+		if typeName, _, named := strings.Cut(sectionName, "/"); named && hasFactory(pluginFactories, typeName) {
+			names = append(names, sectionName)
+		}
+	}
+	return names
+}
+
+// readPluginOrder reads the 'order' option of the top-level 'plugin-chains'
+// section. It returns nil if the option isn't set.
+func readPluginOrder(configFile *config.File) ([]string, error) {
+	configSection := configFile.LookupOptionalSection("plugin-chains")
+	if configSection == nil {
+		return nil, nil
+	}
+	order, err := config.LookupOptional[[]string](configSection, "order")
+	if err != nil || order == nil {
+		return nil, err
+	}
+	logger.Info("Configured plugin order", "order", *order)
+	return *order, nil
+}
+
+func hasFactory(pluginFactories []traffic.PluginFactory, name string) bool {
+	for _, factory := range pluginFactories {
+		if factory.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// findInstance returns the plugin instance with the provided name, or an error
+// if no factory creates it.
+func findInstance(pluginFactories []traffic.PluginFactory, name string) (pluginInstance, error) {
+	typeName, instanceName, named := strings.Cut(name, "/")
+	if named && instanceName == "" {
+		return pluginInstance{}, fmt.Errorf(`Traffic plugin "%v" is missing an instance name`, name)
+	}
+	index := slices.IndexFunc(pluginFactories, func(factory traffic.PluginFactory) bool {
+		return factory.Name() == typeName
+	})
+	if index < 0 {
+		return pluginInstance{}, fmt.Errorf(`Unknown traffic plugin "%v"`, name)
+	}
+	return pluginInstance{name: name, factory: pluginFactories[index], index: index}, nil
+}
+
+// sortInstances returns the plugin instances with the provided names, sorted
+// by the position of their factories and then by name.
+func sortInstances(pluginFactories []traffic.PluginFactory, names []string) ([]pluginInstance, error) {
+	instances := make([]pluginInstance, 0, len(names))
+	for _, name := range names {
+		instance, err := findInstance(pluginFactories, name)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, instance)
+	}
+	slices.SortFunc(instances, func(a, b pluginInstance) int {
+		if a.index != b.index {
+			return cmp.Compare(a.index, b.index)
+		}
+		return cmp.Compare(a.name, b.name)
+	})
+	return instances, nil
+}
+
+// orderInstances returns the plugin instances named in the provided order, in
+// that order. If the order is nil, the instances are returned unchanged.
+func orderInstances(pluginFactories []traffic.PluginFactory, instances []pluginInstance, order []string) ([]pluginInstance, error) {
+	if order == nil {
+		return instances, nil
+	}
+
+	orderedInstances := make([]pluginInstance, 0, len(order))
+	for i, name := range order {
+		if slices.Contains(order[:i], name) {
+			return nil, fmt.Errorf(`Traffic plugin "%v" appears more than once in the plugin order`, name)
+		}
+		instance, err := findInstance(pluginFactories, name)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid plugin order: %v", err)
+		}
+		orderedInstances = append(orderedInstances, instance)
+	}
+	return orderedInstances, nil
+}
+
 // pluginFactoryIsRegistered returns true if the provided plugin factory appears
 // in one of the groups of traffic plugins in registry.go. Checking this helps
 // ensure that newly-developed plugins get registered and are available for use
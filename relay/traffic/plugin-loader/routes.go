@@ -0,0 +1,179 @@
+package plugin_loader
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/fullstorydev/relay-core/relay/config"
+	"github.com/fullstorydev/relay-core/relay/traffic"
+	"gopkg.in/yaml.v3"
+)
+
+type configPluginRoute struct {
+	Name       string
+	PathPrefix string `yaml:"path-prefix"`
+	Path       string
+	Host       string
+	Methods    []string
+	Order      []string
+	Inherit    *bool
+	Plugins    map[string]map[string]yaml.Node
+}
+
+// LoadRoutes reads the plugin routes from the 'routes' option of the top-level
+// 'plugin-chains' section. Each route has its own plugin chain. Unless the
+// route's 'inherit' option is false, the chain includes every plugin that's
+// configured by a top-level section, so that plugins which protect privacy,
+// like block-content, can't be bypassed by adding a route. The route's
+// 'plugins' option contains a configuration section for each plugin that it
+// adds or configures differently; like top-level sections, these may configure
+// named instances. The plugins in each chain run in the order given by the
+// route's 'order' option or, if it isn't set, by the 'order' option of the
+// 'plugin-chains' section. If neither is set, they run in the same order as
+// they would in the default chain.
+func LoadRoutes(
+	pluginFactories []traffic.PluginFactory,
+	configFile *config.File,
+) ([]*traffic.PluginRoute, error) {
+	configSection := configFile.LookupOptionalSection("plugin-chains")
+	if configSection == nil {
+		return nil, nil
+	}
+
+	defaultOrder, err := readPluginOrder(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var pluginRoutes []*traffic.PluginRoute
+	if err := config.ParseOptional(configSection, "routes", func(key string, routes []configPluginRoute) error {
+		for _, route := range routes {
+			if route.Order == nil {
+				route.Order = defaultOrder
+			}
+			pluginRoute, err := loadRoute(pluginFactories, configFile, route)
+			if err != nil {
+				return err
+			}
+			pluginRoutes = append(pluginRoutes, pluginRoute)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return pluginRoutes, nil
+}
+
+func loadRoute(pluginFactories []traffic.PluginFactory, configFile *config.File, route configPluginRoute) (*traffic.PluginRoute, error) {
+	if route.Name == "" {
+		return nil, fmt.Errorf(`Each route must have a "name"`)
+	}
+	pluginRoute := &traffic.PluginRoute{
+		Name:       route.Name,
+		PathPrefix: route.PathPrefix,
+	}
+
+	if route.Path != "" {
+		path, err := regexp.Compile(route.Path)
+		if err != nil {
+			return nil, fmt.Errorf(`Could not compile path regular expression "%v" for route "%v": %v`, route.Path, route.Name, err)
+		}
+		pluginRoute.Path = path
+	}
+	if route.Host != "" {
+		host, err := regexp.Compile(route.Host)
+		if err != nil {
+			return nil, fmt.Errorf(`Could not compile host regular expression "%v" for route "%v": %v`, route.Host, route.Name, err)
+		}
+		pluginRoute.Host = host
+	}
+	for _, method := range route.Methods {
+		pluginRoute.Methods = append(pluginRoute.Methods, strings.ToUpper(method))
+	}
+
+	inherit := route.Inherit == nil || *route.Inherit
+	logger.Info(
+		"Configured plugin route",
+		"route", route.Name,
+		"path-prefix", route.PathPrefix,
+		"path", route.Path,
+		"host", route.Host,
+		"methods", pluginRoute.Methods,
+		"inherit", inherit,
+	)
+
+	// Collect the configuration section of each plugin in the route's chain:
+	// the top-level sections, if the route inherits them, and the route's own
+	// sections, which take precedence.
+	sections := map[string]*config.Section{}
+	if inherit {
+		for _, name := range instanceNames(pluginFactories, configFile) {
+			if configSection := configFile.LookupOptionalSection(name); configSection != nil && configSection.HasValues() {
+				sections[name] = configSection
+			}
+		}
+	}
+	for name, values := range route.Plugins {
+		configSection := config.NewSection(name)
+		for key, value := range values {
+			configSection.Set(key, value)
+		}
+		sections[name] = configSection
+	}
+
+	names := []string{}
+	for name := range sections {
+		if route.Order != nil && !slices.Contains(route.Order, name) {
+			return nil, fmt.Errorf(`Traffic plugin "%v" is configured for route "%v" but isn't listed in its plugin order`, name, route.Name)
+		}
+		names = append(names, name)
+	}
+	instances, err := sortInstances(pluginFactories, names)
+	if err != nil {
+		return nil, fmt.Errorf(`Invalid plugins for route "%v": %v`, route.Name, err)
+	}
+	orderedInstances, err := orderInstances(pluginFactories, instances, route.Order)
+	if err != nil {
+		return nil, fmt.Errorf(`Invalid plugin order for route "%v": %v`, route.Name, err)
+	}
+
+	pluginRoute.Plugins = []traffic.Plugin{}
+	for _, instance := range orderedInstances {
+		configSection, ok := sections[instance.name]
+		if !ok {
+			continue
+		}
+		logger.Info("Loading plugin", "plugin", instance.name, "route", route.Name)
+
+		plugin, err := instance.create(configSection)
+		if err != nil {
+			return nil, fmt.Errorf(`Error in route "%v": %v`, route.Name, err)
+		}
+		if plugin == nil {
+			continue // This plugin is inactive.
+		}
+		pluginRoute.Plugins = append(pluginRoute.Plugins, plugin)
+	}
+	return pluginRoute, nil
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
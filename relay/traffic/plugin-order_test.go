@@ -0,0 +1,286 @@
+package traffic_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/fullstorydev/relay-core/relay/config"
+	"github.com/fullstorydev/relay-core/relay/plugins/traffic/cookies-plugin"
+	"github.com/fullstorydev/relay-core/relay/plugins/traffic/headers-plugin"
+	"github.com/fullstorydev/relay-core/relay/plugins/traffic/paths-plugin"
+	"github.com/fullstorydev/relay-core/relay/traffic"
+	plugin_loader "github.com/fullstorydev/relay-core/relay/traffic/plugin-loader"
+)
+
+func TestPluginOrder(t *testing.T) {
+	plugins := []traffic.PluginFactory{
+		cookies_plugin.Factory,
+		headers_plugin.Factory,
+		paths_plugin.Factory,
+	}
+
+	pluginSections := `
+cookies:
+    allowlist: [SESSION_ID]
+headers:
+    override-origin: example.com
+paths:
+    routes:
+        - path: ^/foo/
+          target-path: /bar/
+`
+
+	testCases := []struct {
+		desc          string
+		configYaml    string
+		expectedChain []string
+		expectedRoute []string
+		expectError   bool
+	}{
+		{
+			desc:          "Without an order, plugins run in registry order",
+			configYaml:    pluginSections,
+			expectedChain: []string{"cookies", "headers", "paths"},
+		},
+		{
+			desc: "Plugins run in the configured order",
+			configYaml: pluginSections + `
+plugin-chains:
+    order: [paths, cookies, headers]
+`,
+			expectedChain: []string{"paths", "cookies", "headers"},
+		},
+		{
+			desc: "Unconfigured plugins may be left out of the order",
+			configYaml: `
+paths:
+    routes:
+        - path: ^/foo/
+          target-path: /bar/
+plugin-chains:
+    order: [paths]
+`,
+			expectedChain: []string{"paths"},
+		},
+		{
+			desc: "Plugins whose options are all empty may be left out of the order",
+			configYaml: `
+cookies:
+    allowlist:
+paths:
+    routes:
+        - path: ^/foo/
+          target-path: /bar/
+plugin-chains:
+    order: [paths]
+`,
+			expectedChain: []string{"paths"},
+		},
+		{
+			desc: "Routes use the configured order",
+			configYaml: pluginSections + `
+plugin-chains:
+    order: [paths, headers, cookies]
+    routes:
+        - name: api
+          plugins:
+              cookies:
+                  allowlist: [API_ID]
+              headers:
+                  override-origin: api.example.com
+`,
+			expectedChain: []string{"paths", "headers", "cookies"},
+			expectedRoute: []string{"paths", "headers", "cookies"},
+		},
+		{
+			desc: "Routes may set their own order",
+			configYaml: pluginSections + `
+plugin-chains:
+    order: [paths, headers, cookies]
+    routes:
+        - name: api
+          order: [cookies, headers]
+          inherit: false
+          plugins:
+              cookies:
+                  allowlist: [API_ID]
+              headers:
+                  override-origin: api.example.com
+`,
+			expectedChain: []string{"paths", "headers", "cookies"},
+			expectedRoute: []string{"cookies", "headers"},
+		},
+		{
+			desc: "Inherited plugins must be listed in a route's order",
+			configYaml: pluginSections + `
+plugin-chains:
+    order: [paths, headers, cookies]
+    routes:
+        - name: api
+          order: [cookies, headers]
+          plugins:
+              cookies:
+                  allowlist: [API_ID]
+`,
+			expectError: true,
+		},
+		{
+			desc: "Named instances run after the factory's own instance, in order of name",
+			configYaml: pluginSections + `
+cookies/b:
+    allowlist: [B_ID]
+cookies/a:
+    allowlist: [A_ID]
+`,
+			expectedChain: []string{"cookies", "cookies/a", "cookies/b", "headers", "paths"},
+		},
+		{
+			desc: "Named instances can be ordered",
+			configYaml: pluginSections + `
+cookies/b:
+    allowlist: [B_ID]
+cookies/a:
+    allowlist: [A_ID]
+plugin-chains:
+    order: [cookies/b, paths, cookies, headers, cookies/a]
+`,
+			expectedChain: []string{"cookies/b", "paths", "cookies", "headers", "cookies/a"},
+		},
+		{
+			desc: "Routes can use named instances",
+			configYaml: pluginSections + `
+plugin-chains:
+    routes:
+        - name: api
+          plugins:
+              headers/api:
+                  override-origin: api.example.com
+              cookies/api:
+                  allowlist: [API_ID]
+              cookies:
+                  allowlist: [SESSION_ID]
+`,
+			expectedChain: []string{"cookies", "headers", "paths"},
+			expectedRoute: []string{"cookies", "cookies/api", "headers", "headers/api", "paths"},
+		},
+		{
+			desc: "Named instances must be listed in the order",
+			configYaml: pluginSections + `
+cookies/a:
+    allowlist: [A_ID]
+plugin-chains:
+    order: [paths, cookies, headers]
+`,
+			expectError: true,
+		},
+		{
+			desc: "Named instances must have a name",
+			configYaml: `
+cookies/:
+    allowlist: [A_ID]
+`,
+			expectError: true,
+		},
+		{
+			desc: "Configured plugins must be listed in the order",
+			configYaml: pluginSections + `
+plugin-chains:
+    order: [paths, cookies]
+`,
+			expectError: true,
+		},
+		{
+			desc: "Plugins configured for a route must be listed in its order",
+			configYaml: `
+plugin-chains:
+    routes:
+        - name: api
+          order: [cookies]
+          plugins:
+              cookies:
+                  allowlist: [API_ID]
+              headers:
+                  override-origin: api.example.com
+`,
+			expectError: true,
+		},
+		{
+			desc: "The order may not refer to unknown plugins",
+			configYaml: pluginSections + `
+plugin-chains:
+    order: [paths, cookies, headers, no-such-plugin]
+`,
+			expectError: true,
+		},
+		{
+			desc: "The order may not list a plugin twice",
+			configYaml: pluginSections + `
+plugin-chains:
+    order: [paths, cookies, headers, paths]
+`,
+			expectError: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		configFile, err := config.NewFileFromYamlString(testCase.configYaml)
+		if err != nil {
+			t.Errorf("Test '%v': Error parsing configuration YAML: %v", testCase.desc, err)
+			continue
+		}
+
+		trafficPlugins, err := plugin_loader.Load(plugins, configFile)
+		var pluginRoutes []*traffic.PluginRoute
+		if err == nil {
+			pluginRoutes, err = plugin_loader.LoadRoutes(plugins, configFile)
+		}
+		if testCase.expectError {
+			if err == nil {
+				t.Errorf("Test '%v': Expected an error", testCase.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test '%v': Error loading plugins: %v", testCase.desc, err)
+			continue
+		}
+
+		if chain := pluginNames(trafficPlugins); !slices.Equal(chain, testCase.expectedChain) {
+			t.Errorf("Test '%v': Expected chain %v, got %v", testCase.desc, testCase.expectedChain, chain)
+		}
+		if testCase.expectedRoute != nil {
+			if len(pluginRoutes) != 1 {
+				t.Errorf("Test '%v': Expected one route, got %v", testCase.desc, len(pluginRoutes))
+			} else if chain := pluginNames(pluginRoutes[0].Plugins); !slices.Equal(chain, testCase.expectedRoute) {
+				t.Errorf("Test '%v': Expected route chain %v, got %v", testCase.desc, testCase.expectedRoute, chain)
+			}
+		}
+	}
+}
+
+func pluginNames(trafficPlugins []traffic.Plugin) []string {
+	names := []string{}
+	for _, trafficPlugin := range trafficPlugins {
+		names = append(names, trafficPlugin.Name())
+	}
+	return names
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
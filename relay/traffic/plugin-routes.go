@@ -0,0 +1,95 @@
+package traffic
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// PluginRoute is a plugin chain that handles the requests matching its
+// criteria in place of the default chain. A request matches if it satisfies
+// every criterion that's set; a route with no criteria matches every request.
+type PluginRoute struct {
+	Name       string
+	PathPrefix string         // If non-empty, the request path must start with this prefix.
+	Path       *regexp.Regexp // If non-nil, the request path must match.
+	Host       *regexp.Regexp // If non-nil, the request host, without a port, must match.
+	Methods    []string       // If non-empty, the request method must be one of these.
+	Plugins    []Plugin       // The plugins that handle matching requests, in order.
+}
+
+// Matches returns true if the provided request, as received from the client,
+// matches the route.
+func (route *PluginRoute) Matches(request *http.Request) bool {
+	path := request.URL.Path
+	if route.PathPrefix != "" && !strings.HasPrefix(path, route.PathPrefix) {
+		return false
+	}
+	if route.Path != nil && !route.Path.MatchString(path) {
+		return false
+	}
+	if route.Host != nil {
+		host := request.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		if !route.Host.MatchString(host) {
+			return false
+		}
+	}
+	if len(route.Methods) > 0 && !slices.Contains(route.Methods, request.Method) {
+		return false
+	}
+	return true
+}
+
+// pluginChains is the set of plugin chains used by a handler. It's replaced as
+// a whole when the plugins change.
+type pluginChains struct {
+	defaultChain []Plugin
+	routes       []*PluginRoute
+
+	mutex   sync.Mutex
+	started bool   // True if the plugins have been started.
+	active  int    // The number of requests using the chains.
+	retired bool   // True once the chains have been replaced or the handler closed.
+	onIdle  func() // Invoked once the chains are retired and no requests are using them.
+}
+
+// selectPlugins returns the plugins that should handle the provided request:
+// those of the first route it matches or, if it doesn't match any, those of
+// the default chain.
+func (chains *pluginChains) selectPlugins(request *http.Request) []Plugin {
+	for _, route := range chains.routes {
+		if route.Matches(request) {
+			if route.Plugins == nil {
+				// A nil chain in RequestInfo means that none was selected.
+				return []Plugin{}
+			}
+			return route.Plugins
+		}
+	}
+	return chains.defaultChain
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
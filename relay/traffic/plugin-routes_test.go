@@ -0,0 +1,172 @@
+package traffic_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fullstorydev/relay-core/relay"
+	"github.com/fullstorydev/relay-core/relay/config"
+	"github.com/fullstorydev/relay-core/relay/plugins/traffic/headers-plugin"
+	"github.com/fullstorydev/relay-core/relay/test"
+	"github.com/fullstorydev/relay-core/relay/traffic"
+	plugin_loader "github.com/fullstorydev/relay-core/relay/traffic/plugin-loader"
+)
+
+func TestPluginRoutes(t *testing.T) {
+	var mutex sync.Mutex
+	var lastOrigin string
+	target := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		lastOrigin = request.Header.Get("Origin")
+	}))
+	defer target.Close()
+
+	configYaml := fmt.Sprintf(`relay:
+    target: %v
+headers:
+    override-origin: default.example
+plugin-chains:
+    routes:
+        - name: api-posts
+          path-prefix: /api/
+          methods: [post]
+          plugins:
+              headers:
+                  override-origin: api.example
+        - name: localhost
+          path: ^/local
+          host: ^localhost$
+          plugins:
+              headers:
+                  override-origin: local.example
+        - name: inherited
+          path-prefix: /inherited
+        - name: none
+          path-prefix: /none
+          inherit: false
+`, target.URL)
+
+	testCases := []struct {
+		desc           string
+		method         string
+		host           string
+		path           string
+		expectedOrigin string
+	}{
+		{
+			desc:           "Requests that don't match a route use the default chain",
+			method:         http.MethodGet,
+			path:           "/",
+			expectedOrigin: "http://default.example",
+		},
+		{
+			desc:           "Requests matching a route use its chain",
+			method:         http.MethodPost,
+			path:           "/api/events",
+			expectedOrigin: "http://api.example",
+		},
+		{
+			desc:           "Every criterion must match",
+			method:         http.MethodGet,
+			path:           "/api/events",
+			expectedOrigin: "http://default.example",
+		},
+		{
+			desc:           "Hosts are matched without their port",
+			method:         http.MethodGet,
+			host:           "localhost",
+			path:           "/local",
+			expectedOrigin: "http://local.example",
+		},
+		{
+			desc:           "Hosts that don't match use the default chain",
+			method:         http.MethodGet,
+			host:           "127.0.0.1",
+			path:           "/local",
+			expectedOrigin: "http://default.example",
+		},
+		{
+			desc:           "Routes inherit the default chain's plugins",
+			method:         http.MethodGet,
+			path:           "/inherited",
+			expectedOrigin: "http://default.example",
+		},
+		{
+			desc:           "Routes that don't inherit the default chain may have no plugins",
+			method:         http.MethodGet,
+			path:           "/none",
+			expectedOrigin: "",
+		},
+	}
+
+	plugins := []traffic.PluginFactory{headers_plugin.Factory}
+
+	test.WithRelay(t, configYaml, plugins, func(relayService *relay.Service) {
+		for _, testCase := range testCases {
+			url := relayService.HttpUrl() + testCase.path
+			if testCase.host != "" {
+				url = strings.Replace(url, "127.0.0.1", testCase.host, 1)
+			}
+			request, err := http.NewRequest(testCase.method, url, nil)
+			if err != nil {
+				t.Errorf("Test '%v': Error creating request: %v", testCase.desc, err)
+				continue
+			}
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Errorf("Test '%v': Error sending request: %v", testCase.desc, err)
+				continue
+			}
+			response.Body.Close()
+
+			mutex.Lock()
+			origin := lastOrigin
+			mutex.Unlock()
+			if origin != testCase.expectedOrigin {
+				t.Errorf("Test '%v': Expected origin '%v', got '%v'", testCase.desc, testCase.expectedOrigin, origin)
+			}
+		}
+	})
+}
+
+func TestPluginRoutesRejectUnknownPlugins(t *testing.T) {
+	configFile, err := config.NewFileFromYamlString(`plugin-chains:
+    routes:
+        - name: api
+          plugins:
+              no-such-plugin:
+                  option: value
+`)
+	if err != nil {
+		t.Fatalf("Error parsing configuration YAML: %v", err)
+	}
+
+	plugins := []traffic.PluginFactory{headers_plugin.Factory}
+	if _, err := plugin_loader.LoadRoutes(plugins, configFile); err == nil {
+		t.Errorf("Expected an error for a route referring to an unknown plugin")
+	}
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
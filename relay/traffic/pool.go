@@ -0,0 +1,369 @@
+package traffic
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// consistentHashReplicas is the number of points each target occupies on the
+// consistent hash ring. More points spread keys more evenly among targets.
+const consistentHashReplicas = 100
+
+// upstreamTarget is a single target in a pool, along with the state the pool
+// uses to decide whether it's available.
+type upstreamTarget struct {
+	scheme string
+	host   string
+	backup bool
+
+	activeRequests atomic.Int64
+	unhealthy      atomic.Bool // Set by active health checks.
+
+	mu                  sync.Mutex
+	consecutiveFailures int       // Passive failures since the last success.
+	ejectedUntil        time.Time // Set by passive ejection.
+
+	// Only accessed by the health check goroutine.
+	checkSuccesses int
+	checkFailures  int
+}
+
+func (target *upstreamTarget) available(now time.Time) bool {
+	if target.unhealthy.Load() {
+		return false
+	}
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	return !now.Before(target.ejectedUntil)
+}
+
+type hashRingPoint struct {
+	hash   uint64
+	target *upstreamTarget
+}
+
+// targetPool selects targets for requests according to the configured load
+// balancing policy, avoiding targets that have failed health checks or that
+// have been ejected after repeated errors.
+type targetPool struct {
+	primaries []*upstreamTarget
+	backups   []*upstreamTarget
+
+	policy      LoadBalancingPolicy
+	hashHeader  string
+	maxFails    int
+	failTimeout time.Duration
+
+	next        atomic.Uint64
+	primaryRing []hashRingPoint
+	backupRing  []hashRingPoint
+
+	stopHealthChecks chan struct{}
+	healthChecksDone sync.WaitGroup
+}
+
+func newTargetPool(config *RelayOptions) *targetPool {
+	options := config.Upstream
+	pool := &targetPool{
+		policy:      options.LoadBalancing,
+		hashHeader:  options.HashHeader,
+		maxFails:    options.MaxFails,
+		failTimeout: options.FailTimeout,
+	}
+
+	targets := config.Targets
+	if len(targets) == 0 {
+		targets = []*TargetOptions{{Host: config.TargetHost, Scheme: config.TargetScheme}}
+	}
+	for _, targetOptions := range targets {
+		target := &upstreamTarget{
+			scheme: targetOptions.Scheme,
+			host:   targetOptions.Host,
+			backup: targetOptions.Backup,
+		}
+		if target.backup {
+			pool.backups = append(pool.backups, target)
+		} else {
+			pool.primaries = append(pool.primaries, target)
+		}
+	}
+
+	if pool.policy == ConsistentHash {
+		pool.primaryRing = newHashRing(pool.primaries)
+		pool.backupRing = newHashRing(pool.backups)
+	}
+	return pool
+}
+
+func newHashRing(targets []*upstreamTarget) []hashRingPoint {
+	ring := make([]hashRingPoint, 0, len(targets)*consistentHashReplicas)
+	for _, target := range targets {
+		for i := 0; i < consistentHashReplicas; i++ {
+			ring = append(ring, hashRingPoint{
+				hash:   hashString(target.scheme + "://" + target.host + "#" + strconv.Itoa(i)),
+				target: target,
+			})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	return ring
+}
+
+func hashString(value string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(value))
+	return hash.Sum64()
+}
+
+// Select chooses the target for the provided request. Primary targets are
+// preferred; backup targets are only used if no primary target is available.
+// If no target at all is available, the pool fails open and selects among the
+// primary targets anyway, since refusing every request is rarely better than
+// trying a target that may have recovered.
+func (pool *targetPool) Select(request *http.Request) *upstreamTarget {
+	now := time.Now()
+
+	if pool.policy == ConsistentHash {
+		key := pool.hashKey(request)
+		if target := selectFromRing(pool.primaryRing, key, now); target != nil {
+			return target
+		}
+		if target := selectFromRing(pool.backupRing, key, now); target != nil {
+			return target
+		}
+		return pool.selectFrom(pool.allTargets(), now, true)
+	}
+
+	if target := pool.selectFrom(pool.primaries, now, false); target != nil {
+		return target
+	}
+	if target := pool.selectFrom(pool.backups, now, false); target != nil {
+		return target
+	}
+	return pool.selectFrom(pool.allTargets(), now, true)
+}
+
+func (pool *targetPool) allTargets() []*upstreamTarget {
+	if len(pool.primaries) > 0 {
+		return pool.primaries
+	}
+	return pool.backups
+}
+
+// selectFrom chooses among the available targets in the provided list using
+// the round-robin or least-connections policy. If ignoreAvailability is true,
+// every target in the list is considered available.
+func (pool *targetPool) selectFrom(targets []*upstreamTarget, now time.Time, ignoreAvailability bool) *upstreamTarget {
+	candidates := make([]*upstreamTarget, 0, len(targets))
+	for _, target := range targets {
+		if ignoreAvailability || target.available(now) {
+			candidates = append(candidates, target)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// Start from the next round-robin position; for least-connections, this
+	// spreads requests among targets that are tied.
+	start := int(pool.next.Add(1)-1) % len(candidates)
+	if pool.policy != LeastConnections {
+		return candidates[start]
+	}
+
+	selected := candidates[start]
+	for i := 1; i < len(candidates); i++ {
+		candidate := candidates[(start+i)%len(candidates)]
+		if candidate.activeRequests.Load() < selected.activeRequests.Load() {
+			selected = candidate
+		}
+	}
+	return selected
+}
+
+// selectFromRing returns the first available target at or after the key's
+// position on the ring.
+func selectFromRing(ring []hashRingPoint, key uint64, now time.Time) *upstreamTarget {
+	if len(ring) == 0 {
+		return nil
+	}
+	start := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= key })
+	for i := 0; i < len(ring); i++ {
+		target := ring[(start+i)%len(ring)].target
+		if target.available(now) {
+			return target
+		}
+	}
+	return nil
+}
+
+func (pool *targetPool) hashKey(request *http.Request) uint64 {
+	if pool.hashHeader != "" {
+		if value := request.Header.Get(pool.hashHeader); value != "" {
+			return hashString(value)
+		}
+	}
+	clientIP, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		clientIP = request.RemoteAddr
+	}
+	return hashString(clientIP)
+}
+
+// ReportResult records the outcome of a request to the target for passive
+// ejection. A target that fails MaxFails times in a row is ejected from the
+// pool for FailTimeout.
+func (pool *targetPool) ReportResult(target *upstreamTarget, success bool) {
+	if pool.maxFails <= 0 {
+		return
+	}
+
+	target.mu.Lock()
+	defer target.mu.Unlock()
+
+	if success {
+		target.consecutiveFailures = 0
+		return
+	}
+
+	target.consecutiveFailures++
+	if target.consecutiveFailures >= pool.maxFails {
+		target.consecutiveFailures = 0
+		target.ejectedUntil = time.Now().Add(pool.failTimeout)
+		logger.Warn("Ejecting target after consecutive failures", "target", target.scheme+"://"+target.host, "failures", pool.maxFails, "fail-timeout", pool.failTimeout)
+	}
+}
+
+// StartHealthChecks begins actively checking the health of every target in
+// the pool, using the provided transport to send the checks.
+func (pool *targetPool) StartHealthChecks(options *HealthCheckOptions, transport http.RoundTripper) {
+	pool.stopHealthChecks = make(chan struct{})
+	pool.healthChecksDone.Add(1)
+	go func() {
+		defer pool.healthChecksDone.Done()
+
+		ticker := time.NewTicker(options.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-pool.stopHealthChecks:
+				return
+			case <-ticker.C:
+				pool.checkHealth(options, transport)
+			}
+		}
+	}()
+}
+
+// Close stops health checks, if they're running.
+func (pool *targetPool) Close() {
+	if pool.stopHealthChecks == nil {
+		return
+	}
+	close(pool.stopHealthChecks)
+	pool.healthChecksDone.Wait()
+	pool.stopHealthChecks = nil
+}
+
+func (pool *targetPool) checkHealth(options *HealthCheckOptions, transport http.RoundTripper) {
+	var wg sync.WaitGroup
+	for _, target := range append(append([]*upstreamTarget{}, pool.primaries...), pool.backups...) {
+		wg.Add(1)
+		go func(target *upstreamTarget) {
+			defer wg.Done()
+			pool.recordHealthCheck(target, options, checkTargetHealth(target, options, transport))
+		}(target)
+	}
+	wg.Wait()
+}
+
+func checkTargetHealth(target *upstreamTarget, options *HealthCheckOptions, transport http.RoundTripper) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), options.Timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target.scheme+"://"+target.host+options.Path, nil)
+	if err != nil {
+		return false
+	}
+	response, err := transport.RoundTrip(request)
+	if err != nil {
+		return false
+	}
+	response.Body.Close()
+	return response.StatusCode >= 200 && response.StatusCode < 400
+}
+
+// ProbeTargets sends a GET request for the provided path to each of the
+// handler's targets, returning nil if any of them responds without a server
+// error. Otherwise, it returns an error describing the last failure. Unlike
+// the active health checks, a probe doesn't affect the state of the pool.
+func (handler *Handler) ProbeTargets(ctx context.Context, path string) error {
+	var err error
+	for _, target := range append(append([]*upstreamTarget{}, handler.pool.primaries...), handler.pool.backups...) {
+		if err = probeTarget(ctx, target, path, handler.transport); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func probeTarget(ctx context.Context, target *upstreamTarget, path string, transport http.RoundTripper) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target.scheme+"://"+target.host+path, nil)
+	if err != nil {
+		return err
+	}
+	response, err := transport.RoundTrip(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 500 {
+		return fmt.Errorf("target %v responded with status %v", target.host, response.StatusCode)
+	}
+	return nil
+}
+
+func (pool *targetPool) recordHealthCheck(target *upstreamTarget, options *HealthCheckOptions, healthy bool) {
+	if healthy {
+		target.checkFailures = 0
+		target.checkSuccesses++
+		if target.unhealthy.Load() && target.checkSuccesses >= options.HealthyThreshold {
+			target.unhealthy.Store(false)
+			logger.Info("Target is healthy", "target", target.scheme+"://"+target.host)
+		}
+	} else {
+		target.checkSuccesses = 0
+		target.checkFailures++
+		if !target.unhealthy.Load() && target.checkFailures >= options.UnhealthyThreshold {
+			target.unhealthy.Store(true)
+			logger.Warn("Target is unhealthy", "target", target.scheme+"://"+target.host)
+		}
+	}
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
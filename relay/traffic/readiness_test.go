@@ -0,0 +1,145 @@
+package traffic_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/relay-core/relay"
+	"github.com/fullstorydev/relay-core/relay/test"
+)
+
+type readinessResponse struct {
+	Status string `json:"status"`
+	Checks map[string]struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	} `json:"checks"`
+}
+
+func getReadiness(t *testing.T, relayService *relay.Service) (int, *readinessResponse) {
+	response, err := http.Get(relayService.HttpUrl() + relay.MonitorPath + "ready")
+	if err != nil {
+		t.Errorf("Error GETing readiness: %v", err)
+		return 0, nil
+	}
+	defer response.Body.Close()
+
+	readiness := &readinessResponse{}
+	if err := json.NewDecoder(response.Body).Decode(readiness); err != nil {
+		t.Errorf("Error decoding readiness: %v", err)
+		return 0, nil
+	}
+	return response.StatusCode, readiness
+}
+
+func TestLivenessAndReadiness(t *testing.T) {
+	configYaml := `relay:
+                       target: http://localhost:1
+    `
+
+	test.WithRelay(t, configYaml, nil, func(relayService *relay.Service) {
+		response, err := http.Get(relayService.HttpUrl() + relay.MonitorPath + "live")
+		if err != nil {
+			t.Errorf("Error GETing liveness: %v", err)
+			return
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Errorf("Expected liveness status 200, got %v", response.StatusCode)
+		}
+
+		// Without a readiness probe, an unreachable target doesn't affect
+		// readiness.
+		code, readiness := getReadiness(t, relayService)
+		if readiness == nil {
+			return
+		}
+		if code != http.StatusOK || readiness.Status != "pass" {
+			t.Errorf("Expected relay to be ready, got %v: %+v", code, readiness)
+		}
+		for _, check := range []string{"startup", "draining"} {
+			if readiness.Checks[check].Status != "pass" {
+				t.Errorf("Expected check '%v' to pass: %+v", check, readiness.Checks)
+			}
+		}
+		if _, ok := readiness.Checks["target"]; ok {
+			t.Errorf("Expected no target check without a readiness probe: %+v", readiness.Checks)
+		}
+	})
+}
+
+func TestReadinessProbe(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	target := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/healthz" {
+			response.WriteHeader(http.StatusNotFound)
+			return
+		}
+		response.WriteHeader(int(status.Load()))
+	}))
+	defer target.Close()
+
+	configYaml := fmt.Sprintf(`relay:
+                                    target: %v
+readiness-probe:
+    path: /healthz
+    interval: 10ms
+    timeout: 1s
+    `, target.URL)
+
+	test.WithRelay(t, configYaml, nil, func(relayService *relay.Service) {
+		waitForReadiness := func(expectedCode int, expectedTarget string) {
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				code, readiness := getReadiness(t, relayService)
+				if readiness == nil {
+					return
+				}
+				if code == expectedCode && readiness.Checks["target"].Status == expectedTarget {
+					return
+				}
+				if time.Now().After(deadline) {
+					t.Errorf("Expected readiness %v with target check '%v', got %v: %+v", expectedCode, expectedTarget, code, readiness)
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+
+		waitForReadiness(http.StatusOK, "pass")
+
+		status.Store(http.StatusServiceUnavailable)
+		waitForReadiness(http.StatusServiceUnavailable, "fail")
+
+		status.Store(http.StatusOK)
+		waitForReadiness(http.StatusOK, "pass")
+
+		target.Close()
+		waitForReadiness(http.StatusServiceUnavailable, "fail")
+	})
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
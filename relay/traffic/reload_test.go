@@ -0,0 +1,125 @@
+package traffic_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/relay-core/relay"
+	"github.com/fullstorydev/relay-core/relay/plugins/traffic/headers-plugin"
+	"github.com/fullstorydev/relay-core/relay/test"
+	"github.com/fullstorydev/relay-core/relay/traffic"
+)
+
+func TestReload(t *testing.T) {
+	var mutex sync.Mutex
+	var lastOrigin string
+	target := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		lastOrigin = request.Header.Get("Origin")
+	}))
+	defer target.Close()
+
+	configYamlWithOrigin := func(origin string) string {
+		return fmt.Sprintf(`relay:
+    port: 0
+    target: %v
+headers:
+    override-origin: %v
+`, target.URL, origin)
+	}
+
+	configPath := filepath.Join(t.TempDir(), "relay.yaml")
+	writeConfig := func(configYaml string) {
+		if err := os.WriteFile(configPath, []byte(configYaml), 0644); err != nil {
+			t.Fatalf("Error writing configuration file: %v", err)
+		}
+	}
+
+	pluginFactories := []traffic.PluginFactory{headers_plugin.Factory}
+
+	test.WithRelay(t, configYamlWithOrigin("first.example"), pluginFactories, func(relayService *relay.Service) {
+		originSeenByTarget := func() string {
+			response, err := http.Get(relayService.HttpUrl())
+			if err != nil {
+				t.Errorf("Error GETing: %v", err)
+				return ""
+			}
+			response.Body.Close()
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			return lastOrigin
+		}
+
+		if origin := originSeenByTarget(); origin != "http://first.example" {
+			t.Errorf("Expected origin 'http://first.example' before reloading, got '%v'", origin)
+		}
+
+		writeConfig(configYamlWithOrigin("second.example"))
+		reloader := relay.NewReloader(relayService, configPath, pluginFactories)
+		defer reloader.Close()
+		if err := reloader.Reload(); err != nil {
+			t.Errorf("Error reloading: %v", err)
+		}
+		if origin := originSeenByTarget(); origin != "http://second.example" {
+			t.Errorf("Expected origin 'http://second.example' after reloading, got '%v'", origin)
+		}
+
+		// An invalid configuration is rejected, and the existing configuration
+		// remains in effect.
+		writeConfig(fmt.Sprintf(`relay:
+    port: 0
+    target: %v
+headers:
+    override-origin: [not, a, string]
+`, target.URL))
+		if err := reloader.Reload(); err == nil {
+			t.Errorf("Expected an error reloading an invalid configuration")
+		}
+		if origin := originSeenByTarget(); origin != "http://second.example" {
+			t.Errorf("Expected origin 'http://second.example' after a failed reload, got '%v'", origin)
+		}
+
+		// Changes are picked up automatically while watching the file.
+		reloader.Watch(10 * time.Millisecond)
+		writeConfig(configYamlWithOrigin("third.example.com"))
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			origin := originSeenByTarget()
+			if origin == "http://third.example.com" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Errorf("Expected origin 'http://third.example.com' after the file changed, got '%v'", origin)
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -0,0 +1,166 @@
+package traffic
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+)
+
+type requestIDContextKey struct{}
+
+// newRequestID returns a random identifier for a request.
+func newRequestID() string {
+	return fmt.Sprintf("%016x%016x", rand.Uint64(), rand.Uint64())
+}
+
+// contextWithRequestID returns a copy of the context that carries the provided
+// request ID.
+func contextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, requestID)
+}
+
+// RequestIDFromContext returns the ID of the request that the context belongs
+// to, or the empty string if it doesn't belong to a request handled by the
+// relay.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey{}).(string)
+	return requestID
+}
+
+// AttributeKey identifies an attribute of type T that plugins can attach to a
+// request, using the request's RequestInfo, to share data with the plugins
+// that run after them. Keys are compared by identity, so each should be created
+// once, usually as a package-level variable:
+//
+//	var TenantAttribute = traffic.NewAttributeKey[string]("tenant")
+type AttributeKey[T any] struct {
+	name   string
+	logged bool
+}
+
+// NewAttributeKey creates a key for an attribute that isn't included in the
+// access log.
+func NewAttributeKey[T any](name string) *AttributeKey[T] {
+	return &AttributeKey[T]{name: name}
+}
+
+// NewLoggedAttributeKey creates a key for an attribute that's included in the
+// request's access log entry, formatted using fmt.Sprint. Values shouldn't
+// contain sensitive data.
+func NewLoggedAttributeKey[T any](name string) *AttributeKey[T] {
+	return &AttributeKey[T]{name: name, logged: true}
+}
+
+// Name returns the name of the attribute.
+func (key *AttributeKey[T]) Name() string {
+	return key.name
+}
+
+// Get returns the value of the attribute for the request described by the
+// provided RequestInfo, and whether it has been set.
+func (key *AttributeKey[T]) Get(info RequestInfo) (T, bool) {
+	value, ok := info.attributes.get(key)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	return value.(T), true
+}
+
+// Set sets the value of the attribute for the request described by the
+// provided RequestInfo, replacing any existing value.
+func (key *AttributeKey[T]) Set(info RequestInfo, value T) {
+	info.attributes.set(key, key.name, key.logged, value)
+}
+
+// Delete removes the attribute from the request described by the provided
+// RequestInfo.
+func (key *AttributeKey[T]) Delete(info RequestInfo) {
+	info.attributes.delete(key)
+}
+
+// requestAttributes holds the attributes attached to a request. It's shared by
+// every copy of the request's RequestInfo.
+type requestAttributes struct {
+	mu     sync.Mutex
+	values map[any]attributeValue
+}
+
+type attributeValue struct {
+	name   string
+	logged bool
+	value  any
+}
+
+func (attributes *requestAttributes) get(key any) (any, bool) {
+	if attributes == nil {
+		return nil, false
+	}
+	attributes.mu.Lock()
+	defer attributes.mu.Unlock()
+	entry, ok := attributes.values[key]
+	return entry.value, ok
+}
+
+func (attributes *requestAttributes) set(key any, name string, logged bool, value any) {
+	if attributes == nil {
+		return
+	}
+	attributes.mu.Lock()
+	defer attributes.mu.Unlock()
+	if attributes.values == nil {
+		attributes.values = map[any]attributeValue{}
+	}
+	attributes.values[key] = attributeValue{name: name, logged: logged, value: value}
+}
+
+func (attributes *requestAttributes) delete(key any) {
+	if attributes == nil {
+		return
+	}
+	attributes.mu.Lock()
+	defer attributes.mu.Unlock()
+	delete(attributes.values, key)
+}
+
+// logged returns the attributes that should appear in the access log, keyed by
+// name. It returns nil if there are none.
+func (attributes *requestAttributes) logged() map[string]string {
+	if attributes == nil {
+		return nil
+	}
+	attributes.mu.Lock()
+	defer attributes.mu.Unlock()
+
+	var logged map[string]string
+	for _, entry := range attributes.values {
+		if !entry.logged {
+			continue
+		}
+		if logged == nil {
+			logged = map[string]string{}
+		}
+		logged[entry.name] = fmt.Sprint(entry.value)
+	}
+	return logged
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
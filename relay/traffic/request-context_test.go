@@ -0,0 +1,125 @@
+package traffic_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/relay-core/catcher"
+	"github.com/fullstorydev/relay-core/relay"
+	"github.com/fullstorydev/relay-core/relay/plugins/traffic/test-interceptor-plugin"
+	"github.com/fullstorydev/relay-core/relay/test"
+	"github.com/fullstorydev/relay-core/relay/traffic"
+)
+
+var (
+	tenantAttribute = traffic.NewLoggedAttributeKey[string]("tenant")
+	scoreAttribute  = traffic.NewAttributeKey[int]("score")
+)
+
+func TestRequestAttributes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	configYaml := fmt.Sprintf(`access-log:
+  format: json
+  path: %v
+relay:
+  request-timeout: 10s
+test-interceptor:
+test-interceptor/second:
+`, path)
+
+	// The first instance sets the attributes, and the second reads them.
+	var mutex sync.Mutex
+	var requestID, seenTenant string
+	var seenScore int
+	var hasDeadline bool
+	plugins := []traffic.PluginFactory{
+		test_interceptor_plugin.NewFactoryWithRequestInfoListener(func(request *http.Request, info traffic.RequestInfo) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if _, ok := tenantAttribute.Get(info); !ok {
+				tenantAttribute.Set(info, "acme")
+				scoreAttribute.Set(info, 7)
+				return
+			}
+			seenTenant, _ = tenantAttribute.Get(info)
+			seenScore, _ = scoreAttribute.Get(info)
+			requestID = traffic.RequestIDFromContext(info.Context())
+			_, hasDeadline = info.Context().Deadline()
+			if requestID != info.RequestID {
+				t.Errorf("Expected the context's request ID '%v' to match RequestInfo's '%v'", requestID, info.RequestID)
+			}
+		}),
+	}
+
+	test.WithCatcherAndRelay(t, configYaml, plugins, func(catcherService *catcher.Service, relayService *relay.Service) {
+		response, err := http.Get(relayService.HttpUrl() + "/attributes")
+		if err != nil {
+			t.Errorf("Error GETing: %v", err)
+			return
+		}
+		response.Body.Close()
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		if seenTenant != "acme" || seenScore != 7 {
+			t.Errorf("Expected the second plugin to see tenant 'acme' and score 7, got '%v' and %v", seenTenant, seenScore)
+		}
+		if requestID == "" {
+			t.Errorf("Expected the request to have an ID")
+		}
+		if !hasDeadline {
+			t.Errorf("Expected the request context to have a deadline")
+		}
+
+		// The entry is written after the response has been sent, so it may
+		// not be there yet.
+		var contents []byte
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if contents, _ = os.ReadFile(path); len(contents) > 0 {
+				break
+			}
+		}
+
+		var entry struct {
+			RequestID  string            `json:"request_id"`
+			Attributes map[string]string `json:"attributes"`
+		}
+		if err := json.Unmarshal(contents, &entry); err != nil {
+			t.Errorf("Expected a JSON access log entry but got %q: %v", contents, err)
+			return
+		}
+		if entry.RequestID != requestID {
+			t.Errorf("Expected request ID '%v' in the access log, got '%v'", requestID, entry.RequestID)
+		}
+		// Only attributes with logged keys appear in the access log.
+		if len(entry.Attributes) != 1 || entry.Attributes["tenant"] != "acme" {
+			t.Errorf("Expected attributes {tenant: acme} in the access log, got %v", entry.Attributes)
+		}
+	})
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
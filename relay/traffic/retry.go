@@ -0,0 +1,236 @@
+package traffic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// roundTrip sends the request to the target, retrying it according to the
+// configured retry policy. Retries of requests sent to a pool are directed to
+// a newly selected target, so a failing target can be avoided.
+func (handler *Handler) roundTrip(clientRequest *http.Request, info RequestInfo) (*http.Response, error) {
+	retry := handler.config.Upstream.Retry
+	maxAttempts := 1
+	if retry != nil && retry.allowsRetry(clientRequest, info) {
+		maxAttempts = retry.MaxAttempts
+	}
+
+	// Retries need to resend the body, so buffer it.
+	if maxAttempts > 1 {
+		if err := bufferRequestBody(clientRequest); err != nil {
+			return nil, err
+		}
+	}
+
+	target := pooledTarget(clientRequest, info)
+	for attempt := 1; ; attempt++ {
+		targetResponse, err := handler.sendToTarget(clientRequest, target)
+		if attempt >= maxAttempts || clientRequest.Context().Err() != nil || !retry.shouldRetry(targetResponse, err) {
+			return targetResponse, err
+		}
+
+		if err != nil {
+			logger.Info("Retrying request after error", "attempt", attempt+1, "max-attempts", maxAttempts, "error", err)
+		} else {
+			logger.Info("Retrying request after failed response", "status", targetResponse.StatusCode, "attempt", attempt+1, "max-attempts", maxAttempts, "path", clientRequest.URL.Path)
+			io.Copy(io.Discard, io.LimitReader(targetResponse.Body, handler.config.MaxBodySize))
+			targetResponse.Body.Close()
+		}
+
+		if err := sleepWithContext(clientRequest.Context(), retry.backoff(attempt)); err != nil {
+			return nil, err
+		}
+
+		if clientRequest.Body, err = clientRequest.GetBody(); err != nil {
+			return nil, err
+		}
+		if target != nil {
+			target = handler.pool.Select(clientRequest)
+			if clientRequest.Host == clientRequest.URL.Host {
+				clientRequest.Host = target.host
+			}
+			clientRequest.URL.Scheme = target.scheme
+			clientRequest.URL.Host = target.host
+		}
+	}
+}
+
+// sendToTarget makes a single attempt to send the request to the target,
+// unless the host's circuit breaker is open. The result is reported to the
+// circuit breaker and, if the target is part of one, to the pool.
+func (handler *Handler) sendToTarget(clientRequest *http.Request, target *upstreamTarget) (targetResponse *http.Response, err error) {
+	span := handler.startUpstreamSpan(clientRequest.Context(), clientRequest)
+	defer func() {
+		endUpstreamSpan(span, targetResponse, err)
+	}()
+
+	breaker := handler.breakers.Get(clientRequest.URL.Host)
+	if breaker != nil && !breaker.Allow(time.Now()) {
+		upstreamErrors.Inc(upstreamErrorKind(errCircuitOpen))
+		return nil, errCircuitOpen
+	}
+
+	// The request stays active until its response body has been relayed, so
+	// that least-connections selection accounts for slow responses.
+	if target != nil {
+		target.activeRequests.Add(1)
+		defer func() {
+			if targetResponse != nil {
+				targetResponse.Body = &activeRequestBody{ReadCloser: targetResponse.Body, target: target}
+			} else {
+				target.activeRequests.Add(-1)
+			}
+		}()
+	}
+
+	start := time.Now()
+	targetResponse, err = handler.transport.RoundTrip(clientRequest)
+	if IsRequestBodyTooLarge(err) || errors.Is(err, context.Canceled) {
+		// The target isn't to blame for these errors.
+		if breaker != nil {
+			breaker.Abandon()
+		}
+		return targetResponse, err
+	}
+
+	if err != nil {
+		upstreamErrors.Inc(upstreamErrorKind(err))
+	}
+	if target != nil {
+		handler.pool.ReportResult(target, err == nil && !isTargetFailure(targetResponse.StatusCode))
+	}
+	if breaker != nil {
+		breaker.Record(err == nil && targetResponse.StatusCode < 500, time.Since(start), time.Now())
+	}
+	return targetResponse, err
+}
+
+// activeRequestBody wraps the body of a response from a pool target. It counts
+// the request as active until the body is closed.
+type activeRequestBody struct {
+	io.ReadCloser
+	target *upstreamTarget
+	closed atomic.Bool
+}
+
+func (body *activeRequestBody) Close() error {
+	if body.closed.CompareAndSwap(false, true) {
+		body.target.activeRequests.Add(-1)
+	}
+	return body.ReadCloser.Close()
+}
+
+// allowsRetry returns true if the request may safely be sent more than once.
+func (retry *RetryOptions) allowsRetry(clientRequest *http.Request, info RequestInfo) bool {
+	switch clientRequest.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	path := clientRequest.URL.Path
+	if info.OriginalURL != nil {
+		path = info.OriginalURL.Path
+	}
+	for _, replaySafePath := range retry.ReplaySafePaths {
+		if replaySafePath.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldRetry returns true if the outcome of an attempt calls for a retry.
+func (retry *RetryOptions) shouldRetry(targetResponse *http.Response, err error) bool {
+	if err == nil {
+		return slices.Contains(retry.StatusCodes, targetResponse.StatusCode)
+	}
+	if IsRequestBodyTooLarge(err) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	switch upstreamErrorKind(err) {
+	case "circuit_open", "connect":
+		return retry.Errors&RetryConnectErrors != 0
+	case "timeout":
+		return retry.Errors&RetryTimeoutErrors != 0
+	case "reset":
+		return retry.Errors&RetryResetErrors != 0
+	default:
+		return false
+	}
+}
+
+// backoff returns the delay before the retry following the provided attempt.
+// It uses "full jitter": the delay is chosen uniformly between zero and an
+// exponentially increasing cap, which spreads out retries from many clients.
+func (retry *RetryOptions) backoff(attempt int) time.Duration {
+	limit := retry.Backoff
+	for i := 1; i < attempt && limit < retry.MaxBackoff; i++ {
+		limit *= 2
+	}
+	if retry.MaxBackoff > 0 && limit > retry.MaxBackoff {
+		limit = retry.MaxBackoff
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return rand.N(limit)
+}
+
+func sleepWithContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// bufferRequestBody reads the request body into memory so that it can be
+// resent. The body is already limited to MaxRequestBodySize.
+func bufferRequestBody(clientRequest *http.Request) error {
+	if clientRequest.Body == nil || clientRequest.Body == http.NoBody {
+		clientRequest.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+		return nil
+	}
+
+	body, err := io.ReadAll(clientRequest.Body)
+	clientRequest.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	clientRequest.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	clientRequest.Body, _ = clientRequest.GetBody()
+	return nil
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -0,0 +1,154 @@
+package traffic_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/relay-core/catcher"
+	"github.com/fullstorydev/relay-core/relay"
+	"github.com/fullstorydev/relay-core/relay/test"
+	"golang.org/x/net/websocket"
+)
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	received := make(chan struct{})
+	release := make(chan struct{})
+	target := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		close(received)
+		<-release
+		response.Write([]byte("done"))
+	}))
+	defer target.Close()
+
+	configYaml := fmt.Sprintf(`relay:
+                                    target: %v
+    `, target.URL)
+
+	test.WithRelay(t, configYaml, nil, func(relayService *relay.Service) {
+		address := relayService.Address()
+
+		responses := make(chan *http.Response, 1)
+		go func() {
+			response, err := http.Get(relayService.HttpUrl())
+			if err != nil {
+				t.Errorf("Error GETing: %v", err)
+			}
+			responses <- response
+		}()
+		<-received
+
+		shutdownErrs := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			shutdownErrs <- relayService.Shutdown(ctx)
+		}()
+
+		// Wait for the listener to close.
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			conn, err := net.Dial("tcp", address)
+			if err != nil {
+				break
+			}
+			conn.Close()
+			if time.Now().After(deadline) {
+				t.Errorf("Expected new connections to be refused while draining")
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		select {
+		case err := <-shutdownErrs:
+			t.Errorf("Shutdown returned before the in-flight request finished: %v", err)
+			return
+		default:
+		}
+
+		close(release)
+		if response := <-responses; response == nil || response.StatusCode != 200 {
+			t.Errorf("Expected in-flight request to succeed: %v", response)
+		}
+		if err := <-shutdownErrs; err != nil {
+			t.Errorf("Expected clean shutdown: %v", err)
+		}
+	})
+}
+
+func TestShutdownDrainsWebSockets(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		closeSocket   bool
+		expectedError error
+	}{
+		{
+			desc:          "Tunnel closes during drain",
+			closeSocket:   true,
+			expectedError: nil,
+		},
+		{
+			desc:          "Tunnel is closed after drain timeout",
+			closeSocket:   false,
+			expectedError: context.DeadlineExceeded,
+		},
+	}
+
+	for _, testCase := range testCases {
+		test.WithCatcherAndRelay(t, "", nil, func(catcherService *catcher.Service, relayService *relay.Service) {
+			echoURL := fmt.Sprintf("%v/echo", relayService.WsUrl())
+			ws, err := websocket.Dial(echoURL, "", relayService.HttpUrl())
+			if err != nil {
+				t.Errorf("Test '%v': Error dialing websocket: %v", testCase.desc, err)
+				return
+			}
+			defer ws.Close()
+			if err := testEcho(ws, "Breaker breaker"); err != nil {
+				t.Errorf("Test '%v': Error in echo: %v", testCase.desc, err)
+				return
+			}
+
+			if testCase.closeSocket {
+				time.AfterFunc(50*time.Millisecond, func() { ws.Close() })
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			if err := relayService.Shutdown(ctx); !errors.Is(err, testCase.expectedError) {
+				t.Errorf("Test '%v': Expected shutdown error %v but got %v", testCase.desc, testCase.expectedError, err)
+			}
+
+			if !testCase.closeSocket {
+				var message string
+				if err := websocket.Message.Receive(ws, &message); err == nil {
+					t.Errorf("Test '%v': Expected websocket to be closed", testCase.desc)
+				}
+			}
+		})
+	}
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
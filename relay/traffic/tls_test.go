@@ -0,0 +1,201 @@
+package traffic_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/relay-core/catcher"
+	"github.com/fullstorydev/relay-core/relay"
+	"github.com/fullstorydev/relay-core/relay/test"
+)
+
+// writeCertificate writes a self-signed certificate with the provided serial
+// number and DNS names, along with its private key.
+func writeCertificate(t *testing.T, certFile string, keyFile string, serial int64, dnsNames ...string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error marshaling key: %v", err)
+	}
+
+	// Write the key first, so that a reload never sees a certificate without
+	// its matching key.
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(keyFile, keyPem, 0600); err != nil {
+		t.Fatalf("Error writing key file: %v", err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer})
+	if err := os.WriteFile(certFile, certPem, 0600); err != nil {
+		t.Fatalf("Error writing certificate file: %v", err)
+	}
+}
+
+// servedCertificate connects to the relay using the provided server name and
+// returns the certificate that the relay presented.
+func servedCertificate(relayService *relay.Service, serverName string) (*x509.Certificate, error) {
+	conn, err := tls.Dial("tcp", relayService.Address(), &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+func TestTLSCertificatesAreSelectedBySNI(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	writeCertificate(t, path("default.pem"), path("default.key"), 1, "default.example.com")
+	writeCertificate(t, path("other.pem"), path("other.key"), 2, "other.example.com")
+	writeCertificate(t, path("wildcard.pem"), path("wildcard.key"), 3, "*.wildcard.example.com")
+
+	configYaml := fmt.Sprintf(`
+relay:
+    http2: true
+tls:
+    cert-file: %v
+    key-file: %v
+    certificates:
+        - cert-file: %v
+          key-file: %v
+        - cert-file: %v
+          key-file: %v
+    min-version: "1.2"
+`, path("default.pem"), path("default.key"), path("other.pem"), path("other.key"), path("wildcard.pem"), path("wildcard.key"))
+
+	test.WithCatcherAndRelay(t, configYaml, nil, func(catcherService *catcher.Service, relayService *relay.Service) {
+		testCases := []struct {
+			serverName     string
+			expectedSerial int64
+		}{
+			{"default.example.com", 1},
+			{"other.example.com", 2},
+			{"OTHER.example.com", 2},
+			{"foo.wildcard.example.com", 3},
+			{"foo.bar.wildcard.example.com", 1},
+			{"unknown.example.com", 1},
+			{"", 1},
+		}
+		for _, testCase := range testCases {
+			certificate, err := servedCertificate(relayService, testCase.serverName)
+			if err != nil {
+				t.Errorf("Test '%v': Error connecting: %v", testCase.serverName, err)
+				continue
+			}
+			if serial := certificate.SerialNumber.Int64(); serial != testCase.expectedSerial {
+				t.Errorf("Test '%v': Expected certificate %v but got %v", testCase.serverName, testCase.expectedSerial, serial)
+			}
+		}
+
+		// Requests should be relayed, over HTTP/2 if it's negotiated.
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{ServerName: "other.example.com", InsecureSkipVerify: true},
+				ForceAttemptHTTP2: true,
+			},
+		}
+		response, err := client.Get(relayService.HttpUrl())
+		if err != nil {
+			t.Errorf("Error GETing via TLS: %v", err)
+			return
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != 200 || response.Proto != "HTTP/2.0" {
+			t.Errorf("Expected 200 HTTP/2.0 response: %v", response)
+		}
+	})
+}
+
+func TestTLSCertificatesAreReloaded(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "cert.key")
+	writeCertificate(t, certFile, keyFile, 1, "relay.example.com")
+
+	configYaml := fmt.Sprintf(`
+tls:
+    cert-file: %v
+    key-file: %v
+    reload-interval: 10ms
+`, certFile, keyFile)
+
+	test.WithCatcherAndRelay(t, configYaml, nil, func(catcherService *catcher.Service, relayService *relay.Service) {
+		certificate, err := servedCertificate(relayService, "relay.example.com")
+		if err != nil {
+			t.Errorf("Error connecting: %v", err)
+			return
+		}
+		if serial := certificate.SerialNumber.Int64(); serial != 1 {
+			t.Errorf("Expected certificate 1 but got %v", serial)
+		}
+
+		writeCertificate(t, certFile, keyFile, 2, "relay.example.com")
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			certificate, err := servedCertificate(relayService, "relay.example.com")
+			if err != nil {
+				t.Errorf("Error connecting: %v", err)
+				return
+			}
+			if certificate.SerialNumber.Int64() == 2 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Errorf("Expected certificate to be reloaded")
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -0,0 +1,110 @@
+package traffic
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/fullstorydev/relay-core/relay/tracing"
+)
+
+// SetTracer directs the handler to trace each request using the provided
+// tracer. It must be called before the handler starts serving requests.
+func (handler *Handler) SetTracer(tracer *tracing.Tracer) {
+	handler.tracer = tracer
+}
+
+// startRequestSpan starts the span covering the relay's handling of a
+// request. If the client sent a traceparent header, the span continues the
+// client's trace. The returned request carries the span in its context.
+func (handler *Handler) startRequestSpan(request *http.Request, route string) (*http.Request, *tracing.Span) {
+	if handler.tracer == nil {
+		return request, nil
+	}
+
+	ctx := request.Context()
+	if parent, ok := tracing.Extract(request.Header); ok {
+		ctx = tracing.ContextWithRemoteParent(ctx, parent)
+	}
+	ctx, span := handler.tracer.Start(ctx, request.Method+" "+route, tracing.SpanKindServer)
+	span.SetAttribute("http.request.method", request.Method)
+	span.SetAttribute("http.route", route)
+	span.SetAttribute("url.path", request.URL.Path)
+	span.SetAttribute("client.address", request.RemoteAddr)
+	return request.WithContext(ctx), span
+}
+
+func endRequestSpan(span *tracing.Span, recorder *responseRecorder) {
+	span.SetAttribute("http.response.status_code", recorder.Status())
+	if recorder.Status() >= 500 {
+		span.RecordError(errorForStatus(recorder.Status()))
+	}
+	span.End()
+}
+
+// handlePlugins runs the plugin chain, tracing each plugin. If a plugin
+// services the request, info is updated to say so.
+func (handler *Handler) handlePlugins(response http.ResponseWriter, request *http.Request, info *RequestInfo) {
+	ctx, chainSpan := handler.tracer.Start(request.Context(), "plugins", tracing.SpanKindInternal)
+	defer chainSpan.End()
+
+	for _, trafficPlugin := range handler.pluginChain(*info) {
+		_, pluginSpan := handler.tracer.Start(ctx, "plugin "+trafficPlugin.Name(), tracing.SpanKindInternal)
+		if trafficPlugin.HandleRequest(response, request, *info) {
+			info.Serviced = true
+			info.outcome.setServicedBy(trafficPlugin.Name())
+			pluginSpan.SetAttribute("relay.serviced", true)
+		}
+		pluginSpan.End()
+	}
+}
+
+// startUpstreamSpan starts a span covering an attempt to send a request to the
+// target, and sets the request's traceparent header so that the target's spans
+// become its children. If tracing is disabled, any traceparent header sent by
+// the client is relayed unchanged.
+func (handler *Handler) startUpstreamSpan(ctx context.Context, clientRequest *http.Request) *tracing.Span {
+	_, span := handler.tracer.Start(ctx, "upstream", tracing.SpanKindClient)
+	if span == nil {
+		return nil
+	}
+	span.SetAttribute("http.request.method", clientRequest.Method)
+	span.SetAttribute("server.address", clientRequest.URL.Host)
+	tracing.Inject(clientRequest.Header, span.Context())
+	return span
+}
+
+func endUpstreamSpan(span *tracing.Span, targetResponse *http.Response, err error) {
+	if err != nil {
+		span.RecordError(err)
+	} else if targetResponse != nil {
+		span.SetAttribute("http.response.status_code", targetResponse.StatusCode)
+		if targetResponse.StatusCode >= 500 {
+			span.RecordError(errorForStatus(targetResponse.StatusCode))
+		}
+	}
+	span.End()
+}
+
+func errorForStatus(status int) error {
+	return fmt.Errorf("%v %v", status, http.StatusText(status))
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -0,0 +1,155 @@
+package traffic_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/relay-core/relay"
+	test_interceptor_plugin "github.com/fullstorydev/relay-core/relay/plugins/traffic/test-interceptor-plugin"
+	"github.com/fullstorydev/relay-core/relay/test"
+	"github.com/fullstorydev/relay-core/relay/tracing"
+	"github.com/fullstorydev/relay-core/relay/traffic"
+)
+
+// otlpCollector is a minimal OTLP/HTTP collector which records the spans it
+// receives by name.
+type otlpCollector struct {
+	mu    sync.Mutex
+	spans map[string]map[string]any
+}
+
+func (collector *otlpCollector) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	var body struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []map[string]any
+			}
+		}
+	}
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	for _, resourceSpans := range body.ResourceSpans {
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			for _, span := range scopeSpans.Spans {
+				collector.spans[span["name"].(string)] = span
+			}
+		}
+	}
+}
+
+func (collector *otlpCollector) span(name string) map[string]any {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	return collector.spans[name]
+}
+
+func TestTracing(t *testing.T) {
+	collector := &otlpCollector{spans: map[string]map[string]any{}}
+	collectorServer := httptest.NewServer(collector)
+	defer collectorServer.Close()
+
+	targetTraceparents := make(chan string, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		targetTraceparents <- request.Header.Get("traceparent")
+	}))
+	defer target.Close()
+
+	configYaml := fmt.Sprintf(`relay:
+  target: %v
+tracing:
+  exporter: otlp
+  endpoint: %v/v1/traces
+  batch-interval: 10ms
+`, target.URL, collectorServer.URL)
+	plugins := []traffic.PluginFactory{
+		test_interceptor_plugin.NewFactoryWithListener(func(request *http.Request) {}),
+	}
+
+	incoming := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	test.WithRelay(t, configYaml, plugins, func(relayService *relay.Service) {
+		request, err := http.NewRequest("GET", relayService.HttpUrl()+"/traced", nil)
+		if err != nil {
+			t.Errorf("Error creating request: %v", err)
+			return
+		}
+		request.Header.Set("traceparent", incoming)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Errorf("Error GETing: %v", err)
+			return
+		}
+		response.Body.Close()
+
+		targetContext, ok := tracing.ParseTraceparent(<-targetTraceparents)
+		if !ok {
+			t.Errorf("Expected the target to receive a valid traceparent")
+			return
+		}
+		if targetContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || !targetContext.Sampled {
+			t.Errorf("Expected the target to continue the client's trace but got %v", targetContext.Traceparent())
+		}
+
+		expectedParents := map[string]string{
+			"GET other":               "00f067aa0ba902b7",
+			"plugins":                 "GET other",
+			"plugin test-interceptor": "plugins",
+			"upstream":                "GET other",
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for name := range expectedParents {
+			for collector.span(name) == nil && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if collector.span(name) == nil {
+				t.Errorf("Expected the collector to receive span '%v'", name)
+				return
+			}
+		}
+
+		for name, parent := range expectedParents {
+			span := collector.span(name)
+			if span["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+				t.Errorf("Expected span '%v' to belong to the client's trace but got %v", name, span["traceId"])
+			}
+			expectedParentID := parent
+			if parentSpan := collector.span(parent); parentSpan != nil {
+				expectedParentID = parentSpan["spanId"].(string)
+			}
+			if span["parentSpanId"] != expectedParentID {
+				t.Errorf("Expected span '%v' to be a child of '%v'", name, parent)
+			}
+		}
+		if upstreamID := collector.span("upstream")["spanId"]; upstreamID != targetContext.SpanID.String() {
+			t.Errorf("Expected the target's parent to be the upstream span %v but got %v", upstreamID, targetContext.SpanID)
+		}
+	})
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
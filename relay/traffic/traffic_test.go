@@ -1,17 +1,24 @@
 package traffic_test
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/fullstorydev/relay-core/catcher"
 	"github.com/fullstorydev/relay-core/relay"
+	"github.com/fullstorydev/relay-core/relay/config"
 	test_interceptor_plugin "github.com/fullstorydev/relay-core/relay/plugins/traffic/test-interceptor-plugin"
 	"github.com/fullstorydev/relay-core/relay/test"
 	"github.com/fullstorydev/relay-core/relay/traffic"
@@ -151,48 +158,325 @@ func TestMaxBodySize(t *testing.T) {
 	})
 }
 
+func TestMaxBodySizeStreamed(t *testing.T) {
+	testCases := []struct {
+		desc           string
+		action         string
+		expectedStatus int
+		expectedBody   string
+		expectError    bool
+	}{
+		{
+			desc:        "Oversize streamed responses are aborted by default",
+			expectError: true,
+		},
+		{
+			desc:        "Oversize streamed responses can be aborted",
+			action:      "abort",
+			expectError: true,
+		},
+		{
+			desc:           "Oversize streamed responses can be truncated",
+			action:         "truncate",
+			expectedStatus: 200,
+			expectedBody:   "<html",
+		},
+		{
+			desc:           "Oversize streamed responses can be rejected",
+			action:         "reject",
+			expectedStatus: 502,
+			expectedBody:   "Response body was too large\n",
+		},
+	}
+
+	for _, testCase := range testCases {
+		configYaml := fmt.Sprintf(`relay:
+                                      max-body-size: 5
+                                      oversize-response-action: %v
+        `, testCase.action)
+
+		test.WithCatcherAndRelay(t, configYaml, nil, func(catcherService *catcher.Service, relayService *relay.Service) {
+			// When the response is aborted, the error may surface either while
+			// reading the headers or while reading the body, depending on how
+			// much of the response was sent before the limit was exceeded.
+			response, err := http.Get(fmt.Sprintf("%v/stream", relayService.HttpUrl()))
+			if err != nil {
+				if !testCase.expectError {
+					t.Errorf("Test '%v': Error GETing: %v", testCase.desc, err)
+				}
+				return
+			}
+			defer response.Body.Close()
+
+			body, err := io.ReadAll(response.Body)
+			if testCase.expectError {
+				if err == nil {
+					t.Errorf("Test '%v': Expected error reading aborted body, got: %v", testCase.desc, string(body))
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Test '%v': Error reading body: %v", testCase.desc, err)
+				return
+			}
+
+			if response.StatusCode != testCase.expectedStatus {
+				t.Errorf("Test '%v': Expected %v response: %v", testCase.desc, testCase.expectedStatus, response)
+			}
+			if string(body) != testCase.expectedBody {
+				t.Errorf("Test '%v': Expected body '%v' but got: %v", testCase.desc, testCase.expectedBody, string(body))
+			}
+		})
+	}
+}
+
+func TestMaxRequestBodySize(t *testing.T) {
+	configYaml := `relay:
+                      max-request-body-size: 100
+                      max-decoded-request-body-size: 500
+    `
+
+	testCases := []struct {
+		desc           string
+		body           string
+		encoding       traffic.Encoding
+		chunked        bool
+		expectedStatus int
+	}{
+		{
+			desc:           "Bodies within the limits are relayed",
+			encoding:       traffic.Identity,
+			body:           strings.Repeat("a", 100),
+			expectedStatus: 200,
+		},
+		{
+			desc:           "Bodies with an oversize Content-Length are rejected",
+			encoding:       traffic.Identity,
+			body:           strings.Repeat("a", 101),
+			expectedStatus: 413,
+		},
+		{
+			desc:           "Oversize chunked bodies are rejected",
+			encoding:       traffic.Identity,
+			body:           strings.Repeat("a", 1000),
+			chunked:        true,
+			expectedStatus: 413,
+		},
+		{
+			desc:           "Compressed bodies within the limits are relayed",
+			body:           strings.Repeat("a", 500),
+			encoding:       traffic.Gzip,
+			expectedStatus: 200,
+		},
+		{
+			desc:           "Compressed bodies that decode to an oversize body are rejected",
+			body:           strings.Repeat("a", 501),
+			encoding:       traffic.Gzip,
+			expectedStatus: 413,
+		},
+	}
+
+	// The relay only decodes request bodies when something needs to read
+	// them, so use a plugin that does.
+	plugins := []traffic.PluginFactory{
+		test_interceptor_plugin.NewFactoryWithListener(func(request *http.Request) {
+			io.ReadAll(request.Body)
+		}),
+	}
+
+	for _, testCase := range testCases {
+		test.WithCatcherAndRelay(t, configYaml, plugins, func(catcherService *catcher.Service, relayService *relay.Service) {
+			encodedBody, err := traffic.EncodeData([]byte(testCase.body), testCase.encoding)
+			if err != nil {
+				t.Errorf("Test '%v': Error encoding data: %v", testCase.desc, err)
+				return
+			}
+
+			// Hiding the length of the body from http.NewRequest causes the
+			// request to be sent without a Content-Length.
+			var body io.Reader = bytes.NewReader(encodedBody)
+			if testCase.chunked {
+				body = io.MultiReader(body)
+			}
+
+			request, err := http.NewRequest("POST", relayService.HttpUrl(), body)
+			if err != nil {
+				t.Errorf("Test '%v': Error creating request: %v", testCase.desc, err)
+				return
+			}
+			if testCase.encoding == traffic.Gzip {
+				request.Header.Set("Content-Encoding", "gzip")
+			}
+
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Errorf("Test '%v': Error POSTing: %v", testCase.desc, err)
+				return
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != testCase.expectedStatus {
+				t.Errorf("Test '%v': Expected %v response: %v", testCase.desc, testCase.expectedStatus, response)
+			}
+		})
+	}
+}
+
+func TestInvalidRequestBodySizes(t *testing.T) {
+	for _, option := range []string{"max-request-body-size", "max-decoded-request-body-size"} {
+		for _, value := range []string{"0", "-1"} {
+			configFile, err := config.NewFileFromYamlString(fmt.Sprintf(`relay:
+  port: 0
+  target: http://localhost:1
+  %v: %v
+`, option, value))
+			if err != nil {
+				t.Fatalf("Error parsing configuration YAML: %v", err)
+			}
+			if _, err := relay.ReadOptions(configFile); err == nil {
+				t.Errorf("Expected %v: %v to be rejected", option, value)
+			}
+		}
+	}
+}
+
+func TestResponsePlugins(t *testing.T) {
+	const replacementBody = "Rewritten by plugin"
+
+	plugins := []traffic.PluginFactory{
+		test_interceptor_plugin.NewFactoryWithResponseListener(func(response *http.Response) error {
+			response.Body.Close()
+			response.StatusCode = http.StatusAccepted
+			response.Header.Set("X-Test-Response-Plugin", "true")
+			response.Body = io.NopCloser(strings.NewReader(replacementBody))
+			response.ContentLength = int64(len(replacementBody))
+			return nil
+		}),
+	}
+
+	test.WithCatcherAndRelay(t, "", plugins, func(catcherService *catcher.Service, relayService *relay.Service) {
+		response, err := http.Get(relayService.HttpUrl())
+		if err != nil {
+			t.Errorf("Error GETing: %v", err)
+			return
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusAccepted {
+			t.Errorf("Expected 202 response: %v", response)
+		}
+		if response.Header.Get("X-Test-Response-Plugin") != "true" {
+			t.Errorf("Expected response header to be added by plugin: %v", response.Header)
+		}
+
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			t.Errorf("Error reading response body: %v", err)
+			return
+		}
+		if string(body) != replacementBody {
+			t.Errorf("Expected body '%v' but got: %v", replacementBody, string(body))
+		}
+	})
+}
+
+func TestResponsePluginError(t *testing.T) {
+	plugins := []traffic.PluginFactory{
+		test_interceptor_plugin.NewFactoryWithResponseListener(func(response *http.Response) error {
+			return errors.New("rejected by plugin")
+		}),
+	}
+
+	test.WithCatcherAndRelay(t, "", plugins, func(catcherService *catcher.Service, relayService *relay.Service) {
+		response, err := http.Get(relayService.HttpUrl())
+		if err != nil {
+			t.Errorf("Error GETing: %v", err)
+			return
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusBadGateway {
+			t.Errorf("Expected 502 response: %v", response)
+		}
+
+		// The plugin's error isn't revealed to the client.
+		body, _ := io.ReadAll(response.Body)
+		if strings.Contains(string(body), "rejected by plugin") {
+			t.Errorf("Expected the plugin's error not to be sent to the client: %s", body)
+		}
+	})
+}
+
 func TestRelaySupportsContentEncoding(t *testing.T) {
 	testCases := map[string]struct {
-		encoding       traffic.Encoding
+		encodings      []traffic.Encoding
 		bodyContentStr string
 		headers        map[string]string
 		customUrl      func(relayServiceURL string) string
 	}{
 		"identity": {
-			encoding:       traffic.Identity,
 			bodyContentStr: "Hello, world!",
 		},
 		"gzip - with header": {
-			encoding:       traffic.Gzip,
+			encodings:      []traffic.Encoding{traffic.Gzip},
 			bodyContentStr: "Hello, world!",
 			headers: map[string]string{
 				"Content-Encoding": "gzip",
 			},
 		},
 		"gzip - with query param": {
-			encoding:       traffic.Gzip,
+			encodings:      []traffic.Encoding{traffic.Gzip},
 			bodyContentStr: "Hello, world!",
 			customUrl: func(relayServiceURL string) string {
 				return fmt.Sprintf("%v?ContentEncoding=gzip", relayServiceURL)
 			},
 		},
+		"deflate": {
+			encodings:      []traffic.Encoding{traffic.Deflate},
+			bodyContentStr: "Hello, world!",
+			headers: map[string]string{
+				"Content-Encoding": "deflate",
+			},
+		},
+		"brotli": {
+			encodings:      []traffic.Encoding{traffic.Brotli},
+			bodyContentStr: "Hello, world!",
+			headers: map[string]string{
+				"Content-Encoding": "br",
+			},
+		},
+		"zstd": {
+			encodings:      []traffic.Encoding{traffic.Zstd},
+			bodyContentStr: "Hello, world!",
+			headers: map[string]string{
+				"Content-Encoding": "zstd",
+			},
+		},
+		"stacked - with header": {
+			encodings:      []traffic.Encoding{traffic.Gzip, traffic.Brotli},
+			bodyContentStr: "Hello, world!",
+			headers: map[string]string{
+				"Content-Encoding": "gzip, br",
+			},
+		},
+		"stacked - with query param": {
+			encodings:      []traffic.Encoding{traffic.Zstd, traffic.Deflate},
+			bodyContentStr: "Hello, world!",
+			customUrl: func(relayServiceURL string) string {
+				return fmt.Sprintf("%v?ContentEncoding=%v", relayServiceURL, url.QueryEscape("zstd, deflate"))
+			},
+		},
 	}
 
 	for desc, testCase := range testCases {
 		test.WithCatcherAndRelay(t, "", nil, func(catcherService *catcher.Service, relayService *relay.Service) {
 			// convert the body content to a reader with the proper content encoding applied
-			var body io.Reader
-			switch testCase.encoding {
-			case traffic.Gzip:
-				b, err := traffic.EncodeData([]byte(testCase.bodyContentStr), traffic.Gzip)
-				if err != nil {
-					t.Errorf("Test %s - Error encoding data: %v", desc, err)
-					return
-				}
-				body = bytes.NewReader(b)
-			case traffic.Identity:
-				body = strings.NewReader(testCase.bodyContentStr)
+			b, err := traffic.EncodeData([]byte(testCase.bodyContentStr), testCase.encodings...)
+			if err != nil {
+				t.Errorf("Test %s - Error encoding data: %v", desc, err)
+				return
 			}
+			body := bytes.NewReader(b)
 
 			requestURL := relayService.HttpUrl()
 			if testCase.customUrl != nil {
@@ -227,25 +511,102 @@ func TestRelaySupportsContentEncoding(t *testing.T) {
 				return
 			}
 
-			switch testCase.encoding {
-			case traffic.Gzip:
-				decodedData, err := traffic.DecodeData(lastRequest, traffic.Gzip)
-				if err != nil {
-					t.Errorf("Test %s - Error decoding data: %v", desc, err)
-					return
-				}
-				if string(decodedData) != testCase.bodyContentStr {
-					t.Errorf("Test %s - Expected body '%v' but got: %v", desc, testCase.bodyContentStr, string(decodedData))
-				}
-			case traffic.Identity:
-				if string(lastRequest) != testCase.bodyContentStr {
-					t.Errorf("Test %s - Expected body '%v' but got: %v", desc, testCase.bodyContentStr, string(lastRequest))
-				}
+			decodedData, err := traffic.DecodeData(lastRequest, testCase.encodings...)
+			if err != nil {
+				t.Errorf("Test %s - Error decoding data: %v", desc, err)
+				return
+			}
+			if string(decodedData) != testCase.bodyContentStr {
+				t.Errorf("Test %s - Expected body '%v' but got: %v", desc, testCase.bodyContentStr, string(decodedData))
+			}
+		})
+	}
+}
+
+func TestUnmodifiedRequestBodiesAreForwardedUntouched(t *testing.T) {
+	// Compress the body with a gzip header and compression level that the
+	// relay wouldn't reproduce if it re-encoded the body.
+	var buf bytes.Buffer
+	writer, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	writer.Name = "body.txt"
+	writer.Write([]byte(strings.Repeat("Hello, world! ", 100)))
+	writer.Close()
+	originalBody := buf.Bytes()
+
+	testCases := []struct {
+		desc    string
+		plugins []traffic.PluginFactory
+	}{
+		{
+			desc: "Bodies that aren't read are forwarded untouched",
+		},
+		{
+			desc: "Bodies that are read but not modified are forwarded untouched",
+			plugins: []traffic.PluginFactory{
+				test_interceptor_plugin.NewFactoryWithListener(func(request *http.Request) {
+					io.ReadAll(request.Body)
+				}),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		test.WithCatcherAndRelay(t, "", testCase.plugins, func(catcherService *catcher.Service, relayService *relay.Service) {
+			request, _ := http.NewRequest("POST", relayService.HttpUrl(), bytes.NewReader(originalBody))
+			request.Header.Set("Content-Encoding", "gzip")
+
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Errorf("Test '%v': Error POSTing: %v", testCase.desc, err)
+				return
+			}
+			defer response.Body.Close()
+
+			lastRequest, err := catcherService.LastRequestBody()
+			if err != nil {
+				t.Errorf("Test '%v': Error reading last request body from catcher: %v", testCase.desc, err)
+				return
+			}
+			if !bytes.Equal(lastRequest, originalBody) {
+				t.Errorf("Test '%v': Body was modified", testCase.desc)
 			}
 		})
 	}
 }
 
+func TestDeflateAcceptsRawData(t *testing.T) {
+	var buf bytes.Buffer
+	writer, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	writer.Write([]byte("Hello, world!"))
+	writer.Close()
+
+	decodedData, err := traffic.DecodeData(buf.Bytes(), traffic.Deflate)
+	if err != nil {
+		t.Fatalf("Error decoding raw deflate data: %v", err)
+	}
+	if string(decodedData) != "Hello, world!" {
+		t.Errorf("Expected body 'Hello, world!' but got: %v", string(decodedData))
+	}
+}
+
+func TestUnsupportedContentEncoding(t *testing.T) {
+	test.WithCatcherAndRelay(t, "", nil, func(catcherService *catcher.Service, relayService *relay.Service) {
+		request, _ := http.NewRequest("POST", relayService.HttpUrl(), strings.NewReader("Hello, world!"))
+		request.Header.Set("Content-Encoding", "gzip, compress")
+
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Errorf("Error POSTing: %v", err)
+			return
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != 500 {
+			t.Errorf("Expected 500 response: %v", response)
+		}
+	})
+}
+
 func TestRelayNotFound(t *testing.T) {
 	test.WithCatcherAndRelay(t, "", nil, func(catcherService *catcher.Service, relayService *relay.Service) {
 		faviconURL := fmt.Sprintf("%v/favicon.ico", relayService.HttpUrl())
@@ -282,6 +643,93 @@ func TestWebSocketEcho(t *testing.T) {
 	})
 }
 
+func TestWebSocketMessagePlugins(t *testing.T) {
+	plugins := []traffic.PluginFactory{
+		test_interceptor_plugin.NewFactoryWithWebSocketMessageListener(func(message *traffic.WebSocketMessage) traffic.WebSocketMessageAction {
+			if message.Direction == traffic.ClientToTarget && strings.HasPrefix(string(message.Data), "drop") {
+				return traffic.DropMessage
+			}
+			if message.Direction == traffic.TargetToClient {
+				message.Data = bytes.ToUpper(message.Data)
+			}
+			return traffic.PassMessage
+		}),
+	}
+
+	test.WithCatcherAndRelay(t, "", plugins, func(catcherService *catcher.Service, relayService *relay.Service) {
+		echoURL := fmt.Sprintf("%v/echo", relayService.WsUrl())
+		ws, err := websocket.Dial(echoURL, "", relayService.HttpUrl())
+		if err != nil {
+			t.Errorf("Error dialing websocket: %v", err)
+			return
+		}
+		defer ws.Close()
+
+		for _, message := range []string{"drop this message", "keep this message"} {
+			if err := websocket.Message.Send(ws, message); err != nil {
+				t.Errorf("Error sending websocket message: %v", err)
+				return
+			}
+		}
+
+		// The first message should have been dropped before it reached the
+		// echo server, and the echoed second message should have been
+		// rewritten on its way back.
+		var echoed string
+		if err := websocket.Message.Receive(ws, &echoed); err != nil {
+			t.Errorf("Error receiving websocket message: %v", err)
+			return
+		}
+		if echoed != "KEEP THIS MESSAGE" {
+			t.Errorf("Unexpected echo response: %v", echoed)
+		}
+	})
+}
+
+func TestWebSocketRejectsUnmaskedClientFrames(t *testing.T) {
+	plugins := []traffic.PluginFactory{
+		test_interceptor_plugin.NewFactoryWithWebSocketMessageListener(func(message *traffic.WebSocketMessage) traffic.WebSocketMessageAction {
+			return traffic.PassMessage
+		}),
+	}
+
+	test.WithCatcherAndRelay(t, "", plugins, func(catcherService *catcher.Service, relayService *relay.Service) {
+		conn, err := net.Dial("tcp", relayService.Address())
+		if err != nil {
+			t.Errorf("Error connecting: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		fmt.Fprintf(conn, "GET /echo HTTP/1.1\r\n"+
+			"Host: %v\r\n"+
+			"Upgrade: websocket\r\n"+
+			"Connection: Upgrade\r\n"+
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+			"Sec-WebSocket-Version: 13\r\n"+
+			"Origin: %v\r\n\r\n", relayService.Address(), relayService.HttpUrl())
+		reader := bufio.NewReader(conn)
+		response, err := http.ReadResponse(reader, nil)
+		if err != nil || response.StatusCode != http.StatusSwitchingProtocols {
+			t.Errorf("Expected the WebSocket handshake to succeed: %v %v", response, err)
+			return
+		}
+
+		// Clients must mask their frames, so the relay should fail the
+		// connection with a protocol error.
+		conn.Write([]byte{0x81, 0x02, 'h', 'i'})
+		closeFrame := make([]byte, 4)
+		if _, err := io.ReadFull(reader, closeFrame); err != nil {
+			t.Errorf("Error reading close frame: %v", err)
+			return
+		}
+		if !bytes.Equal(closeFrame, []byte{0x88, 0x02, 0x03, 0xEA}) {
+			t.Errorf("Expected a close frame with status 1002, got %x", closeFrame)
+		}
+	})
+}
+
 func testEcho(conn *websocket.Conn, message string) error {
 	_, err := conn.Write([]byte(message))
 	if err != nil {
@@ -0,0 +1,99 @@
+package traffic
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// tunnelSet tracks the connections of open WebSocket tunnels. Once a
+// connection is hijacked, the HTTP server no longer tracks it, so the handler
+// has to do so itself to let shutdown wait for tunnels to finish.
+type tunnelSet struct {
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	count    int
+	draining bool
+	drained  chan struct{}
+}
+
+func newTunnelSet() *tunnelSet {
+	return &tunnelSet{
+		conns:   map[net.Conn]struct{}{},
+		drained: make(chan struct{}),
+	}
+}
+
+// Add starts tracking a tunnel between the provided connections. It returns
+// false if the set is draining, in which case the tunnel should not be opened.
+func (tunnels *tunnelSet) Add(clientConn net.Conn, targetConn net.Conn) bool {
+	tunnels.mu.Lock()
+	defer tunnels.mu.Unlock()
+	if tunnels.draining {
+		return false
+	}
+	tunnels.conns[clientConn] = struct{}{}
+	tunnels.conns[targetConn] = struct{}{}
+	tunnels.count++
+	activeTunnels.Add(1)
+	return true
+}
+
+// Remove stops tracking a tunnel once it has closed.
+func (tunnels *tunnelSet) Remove(clientConn net.Conn, targetConn net.Conn) {
+	tunnels.mu.Lock()
+	defer tunnels.mu.Unlock()
+	delete(tunnels.conns, clientConn)
+	delete(tunnels.conns, targetConn)
+	tunnels.count--
+	activeTunnels.Add(-1)
+	if tunnels.draining && tunnels.count == 0 {
+		close(tunnels.drained)
+	}
+}
+
+// Drain stops new tunnels from opening and waits for the open ones to close.
+// If the context ends first, the remaining tunnels are closed forcibly and the
+// context's error is returned.
+func (tunnels *tunnelSet) Drain(ctx context.Context) error {
+	tunnels.mu.Lock()
+	if !tunnels.draining {
+		tunnels.draining = true
+		if tunnels.count == 0 {
+			close(tunnels.drained)
+		}
+	}
+	tunnels.mu.Unlock()
+
+	select {
+	case <-tunnels.drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	tunnels.mu.Lock()
+	defer tunnels.mu.Unlock()
+	for conn := range tunnels.conns {
+		conn.Close()
+	}
+	return ctx.Err()
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
@@ -0,0 +1,659 @@
+package traffic_test
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fullstorydev/relay-core/relay"
+	"github.com/fullstorydev/relay-core/relay/config"
+	"github.com/fullstorydev/relay-core/relay/plugins/traffic/test-interceptor-plugin"
+	"github.com/fullstorydev/relay-core/relay/test"
+	"github.com/fullstorydev/relay-core/relay/traffic"
+)
+
+func TestUpstreamCustomCA(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Write([]byte("Secure"))
+	}))
+	defer target.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: target.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPem, 0600); err != nil {
+		t.Fatalf("Error writing CA file: %v", err)
+	}
+
+	testCases := []struct {
+		desc           string
+		config         string
+		expectedStatus int
+	}{
+		{
+			desc: "Targets with untrusted certificates are rejected",
+			config: fmt.Sprintf(`
+relay:
+  target: %v
+`, target.URL),
+			expectedStatus: 404,
+		},
+		{
+			desc: "Targets can be trusted using a custom CA bundle",
+			config: fmt.Sprintf(`
+relay:
+  target: %v
+upstream:
+  ca-file: %v
+`, target.URL, caFile),
+			expectedStatus: 200,
+		},
+	}
+
+	for _, testCase := range testCases {
+		test.WithRelay(t, testCase.config, nil, func(relayService *relay.Service) {
+			response, err := http.Get(relayService.HttpUrl())
+			if err != nil {
+				t.Errorf("Test '%v': Error GETing: %v", testCase.desc, err)
+				return
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != testCase.expectedStatus {
+				t.Errorf("Test '%v': Expected %v response: %v", testCase.desc, testCase.expectedStatus, response)
+				return
+			}
+			if testCase.expectedStatus == 200 {
+				body, _ := io.ReadAll(response.Body)
+				if string(body) != "Secure" {
+					t.Errorf("Test '%v': Unexpected body: %v", testCase.desc, string(body))
+				}
+			}
+		})
+	}
+}
+
+func TestUpstreamTimeouts(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		select {
+		case <-request.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer target.Close()
+
+	testCases := []struct {
+		desc   string
+		option string
+	}{
+		{
+			desc:   "The response header timeout is enforced",
+			option: "response-header-timeout",
+		},
+		{
+			desc:   "The overall timeout is enforced",
+			option: "timeout",
+		},
+	}
+
+	for _, testCase := range testCases {
+		config := fmt.Sprintf(`
+relay:
+  target: %v
+upstream:
+  %v: 50ms
+`, target.URL, testCase.option)
+
+		test.WithRelay(t, config, nil, func(relayService *relay.Service) {
+			response, err := http.Get(relayService.HttpUrl())
+			if err != nil {
+				t.Errorf("Test '%v': Error GETing: %v", testCase.desc, err)
+				return
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != http.StatusGatewayTimeout {
+				t.Errorf("Test '%v': Expected 504 response: %v", testCase.desc, response)
+			}
+		})
+	}
+}
+
+// newNamedTarget returns a target server that responds with its name.
+func newNamedTarget(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Write([]byte(name))
+	}))
+}
+
+// getBodies sends count requests through the relay and returns the response
+// bodies.
+func getBodies(t *testing.T, desc string, relayService *relay.Service, header http.Header, count int) []string {
+	var bodies []string
+	for i := 0; i < count; i++ {
+		request, _ := http.NewRequest("GET", relayService.HttpUrl(), nil)
+		for key, values := range header {
+			request.Header[key] = values
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Errorf("Test '%v': Error GETing: %v", desc, err)
+			return bodies
+		}
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		bodies = append(bodies, string(body))
+	}
+	return bodies
+}
+
+func TestUpstreamPoolLoadBalancing(t *testing.T) {
+	targetA := newNamedTarget("A")
+	defer targetA.Close()
+	targetB := newNamedTarget("B")
+	defer targetB.Close()
+
+	config := fmt.Sprintf(`
+relay:
+  target:
+    - %v
+    - %v
+`, targetA.URL, targetB.URL)
+
+	test.WithRelay(t, config, nil, func(relayService *relay.Service) {
+		desc := "Round-robin alternates between targets"
+		bodies := getBodies(t, desc, relayService, nil, 4)
+		if fmt.Sprint(bodies) != "[A B A B]" {
+			t.Errorf("Test '%v': Unexpected targets: %v", desc, bodies)
+		}
+	})
+
+	config = fmt.Sprintf(`
+relay:
+  target:
+    - %v
+    - %v
+upstream:
+  load-balancing: consistent-hash
+  hash-header: X-Session
+`, targetA.URL, targetB.URL)
+
+	test.WithRelay(t, config, nil, func(relayService *relay.Service) {
+		desc := "Consistent hashing sends the same key to the same target"
+		for _, session := range []string{"1", "2", "3", "4"} {
+			bodies := getBodies(t, desc, relayService, http.Header{"X-Session": {session}}, 3)
+			if len(bodies) != 3 || bodies[0] != bodies[1] || bodies[1] != bodies[2] {
+				t.Errorf("Test '%v': Session %v was sent to multiple targets: %v", desc, session, bodies)
+			}
+		}
+	})
+}
+
+func TestUpstreamPoolFailover(t *testing.T) {
+	downTarget := newNamedTarget("Down")
+	downTarget.Close()
+	upTarget := newNamedTarget("Up")
+	defer upTarget.Close()
+	backupTarget := newNamedTarget("Backup")
+	defer backupTarget.Close()
+
+	config := fmt.Sprintf(`
+relay:
+  target:
+    - %v
+    - %v
+upstream:
+  max-fails: 1
+  fail-timeout: 1m
+`, downTarget.URL, upTarget.URL)
+
+	test.WithRelay(t, config, nil, func(relayService *relay.Service) {
+		desc := "Failing targets are ejected from the pool"
+		bodies := getBodies(t, desc, relayService, nil, 4)
+		if len(bodies) != 4 || fmt.Sprint(bodies[1:]) != "[Up Up Up]" {
+			t.Errorf("Test '%v': Unexpected targets: %v", desc, bodies)
+		}
+	})
+
+	config = fmt.Sprintf(`
+relay:
+  target:
+    - %v
+    - url: %v
+      backup: true
+upstream:
+  health-check:
+    interval: 10ms
+    unhealthy-threshold: 1
+`, downTarget.URL, backupTarget.URL)
+
+	test.WithRelay(t, config, nil, func(relayService *relay.Service) {
+		desc := "Backup targets are used when primary targets are unhealthy"
+		time.Sleep(100 * time.Millisecond)
+		bodies := getBodies(t, desc, relayService, nil, 2)
+		if fmt.Sprint(bodies) != "[Backup Backup]" {
+			t.Errorf("Test '%v': Unexpected targets: %v", desc, bodies)
+		}
+	})
+
+	config = fmt.Sprintf(`
+relay:
+  target:
+    - %v
+    - url: %v
+      backup: true
+`, upTarget.URL, backupTarget.URL)
+
+	test.WithRelay(t, config, nil, func(relayService *relay.Service) {
+		desc := "Backup targets are not used when primary targets are healthy"
+		bodies := getBodies(t, desc, relayService, nil, 2)
+		if fmt.Sprint(bodies) != "[Up Up]" {
+			t.Errorf("Test '%v': Unexpected targets: %v", desc, bodies)
+		}
+	})
+}
+
+func TestUpstreamRetries(t *testing.T) {
+	var attempts atomic.Int32
+	var bodies []string
+	var bodiesLock sync.Mutex
+	target := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		bodiesLock.Lock()
+		bodies = append(bodies, string(body))
+		bodiesLock.Unlock()
+
+		// Fail every request but the third.
+		if attempts.Add(1) != 3 {
+			http.Error(response, "Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		response.Write([]byte("OK"))
+	}))
+	defer target.Close()
+
+	config := fmt.Sprintf(`
+relay:
+  target: %v
+upstream:
+  retry:
+    max-attempts: 3
+    backoff: 1ms
+    replay-safe-paths:
+      - '^/beacon'
+`, target.URL)
+
+	testCases := []struct {
+		desc             string
+		method           string
+		path             string
+		expectedStatus   int
+		expectedAttempts int32
+	}{
+		{
+			desc:             "Idempotent requests are retried",
+			method:           "PUT",
+			path:             "/",
+			expectedStatus:   200,
+			expectedAttempts: 3,
+		},
+		{
+			desc:             "Non-idempotent requests are not retried",
+			method:           "POST",
+			path:             "/",
+			expectedStatus:   503,
+			expectedAttempts: 1,
+		},
+		{
+			desc:             "Non-idempotent requests to replay-safe paths are retried",
+			method:           "POST",
+			path:             "/beacon",
+			expectedStatus:   200,
+			expectedAttempts: 3,
+		},
+	}
+
+	test.WithRelay(t, config, nil, func(relayService *relay.Service) {
+		for _, testCase := range testCases {
+			attempts.Store(0)
+			bodies = nil
+
+			request, _ := http.NewRequest(testCase.method, relayService.HttpUrl()+testCase.path, strings.NewReader("Payload"))
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Errorf("Test '%v': Error sending request: %v", testCase.desc, err)
+				continue
+			}
+			response.Body.Close()
+
+			if response.StatusCode != testCase.expectedStatus {
+				t.Errorf("Test '%v': Expected %v response: %v", testCase.desc, testCase.expectedStatus, response)
+			}
+			if attempts.Load() != testCase.expectedAttempts {
+				t.Errorf("Test '%v': Expected %v attempts, got %v", testCase.desc, testCase.expectedAttempts, attempts.Load())
+			}
+			for _, body := range bodies {
+				if body != "Payload" {
+					t.Errorf("Test '%v': Unexpected body: %v", testCase.desc, body)
+				}
+			}
+		}
+	})
+}
+
+func TestUpstreamPoolLeastConnectionsCountsSlowBodies(t *testing.T) {
+	// The slow target sends its headers right away, but holds the body until
+	// it's released.
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	slowTarget := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusOK)
+		response.(http.Flusher).Flush()
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+		}
+		response.Write([]byte("Slow"))
+	}))
+	defer slowTarget.Close()
+	fastTarget := newNamedTarget("Fast")
+	defer fastTarget.Close()
+
+	config := fmt.Sprintf(`
+relay:
+  target:
+    - %v
+    - %v
+upstream:
+  load-balancing: least-connections
+`, slowTarget.URL, fastTarget.URL)
+
+	test.WithRelay(t, config, nil, func(relayService *relay.Service) {
+		desc := "Requests whose bodies are still being relayed count as active"
+		slowBody := make(chan []string, 1)
+		go func() {
+			slowBody <- getBodies(t, desc, relayService, nil, 1)
+		}()
+		<-started
+
+		bodies := getBodies(t, desc, relayService, nil, 3)
+		if fmt.Sprint(bodies) != "[Fast Fast Fast]" {
+			t.Errorf("Test '%v': Unexpected targets: %v", desc, bodies)
+		}
+
+		close(release)
+		if bodies := <-slowBody; fmt.Sprint(bodies) != "[Slow]" {
+			t.Errorf("Test '%v': Unexpected body from the slow target: %v", desc, bodies)
+		}
+	})
+}
+
+func TestUpstreamRetriesUseAnotherTarget(t *testing.T) {
+	downTarget := newNamedTarget("Down")
+	downTarget.Close()
+	upTarget := newNamedTarget("Up")
+	defer upTarget.Close()
+
+	config := fmt.Sprintf(`
+relay:
+  target:
+    - %v
+    - %v
+upstream:
+  retry:
+    backoff: 1ms
+`, downTarget.URL, upTarget.URL)
+
+	test.WithRelay(t, config, nil, func(relayService *relay.Service) {
+		desc := "Connection failures are retried against another target"
+		bodies := getBodies(t, desc, relayService, nil, 4)
+		if fmt.Sprint(bodies) != "[Up Up Up Up]" {
+			t.Errorf("Test '%v': Unexpected targets: %v", desc, bodies)
+		}
+	})
+}
+
+func TestUpstreamCircuitBreaker(t *testing.T) {
+	var attempts atomic.Int32
+	var healthy atomic.Bool
+	var slow atomic.Bool
+	target := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		attempts.Add(1)
+		if slow.Load() {
+			time.Sleep(50 * time.Millisecond)
+		}
+		if !healthy.Load() {
+			http.Error(response, "Broken", http.StatusInternalServerError)
+			return
+		}
+		response.Write([]byte("OK"))
+	}))
+	defer target.Close()
+
+	getStatus := func(desc string, relayService *relay.Service) (int, string) {
+		response, err := http.Get(relayService.HttpUrl())
+		if err != nil {
+			t.Errorf("Test '%v': Error GETing: %v", desc, err)
+			return 0, ""
+		}
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		return response.StatusCode, strings.TrimSpace(string(body))
+	}
+
+	getBreakerState := func(desc string, relayService *relay.Service) string {
+		request, err := http.NewRequest("GET", relayService.AdminUrl()+"/circuit-breakers", nil)
+		if err != nil {
+			t.Errorf("Test '%v': Error creating request: %v", desc, err)
+			return ""
+		}
+		request.Header.Set("Authorization", "Bearer s3cret")
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Errorf("Test '%v': Error GETing breaker state: %v", desc, err)
+			return ""
+		}
+		defer response.Body.Close()
+		var statuses []traffic.CircuitBreakerStatus
+		if err := json.NewDecoder(response.Body).Decode(&statuses); err != nil || len(statuses) != 1 {
+			t.Errorf("Test '%v': Unexpected breaker state: %v %v", desc, statuses, err)
+			return ""
+		}
+		return statuses[0].State
+	}
+
+	config := fmt.Sprintf(`
+relay:
+  target: %v
+upstream:
+  circuit-breaker:
+    min-requests: 2
+    error-threshold: 0.5
+    open-duration: 100ms
+    response-body: Circuit open
+admin:
+  address: localhost:0
+  token: s3cret
+`, target.URL)
+
+	test.WithRelay(t, config, nil, func(relayService *relay.Service) {
+		desc := "The breaker opens after failures and fails fast"
+		healthy.Store(false)
+		attempts.Store(0)
+		for i := 0; i < 2; i++ {
+			if status, _ := getStatus(desc, relayService); status != 500 {
+				t.Errorf("Test '%v': Expected 500 response, got %v", desc, status)
+			}
+		}
+		if status, body := getStatus(desc, relayService); status != 503 || body != "Circuit open" {
+			t.Errorf("Test '%v': Expected 503 response, got %v %v", desc, status, body)
+		}
+		if attempts.Load() != 2 {
+			t.Errorf("Test '%v': Expected 2 attempts, got %v", desc, attempts.Load())
+		}
+		if state := getBreakerState(desc, relayService); state != "open" {
+			t.Errorf("Test '%v': Expected open breaker, got %v", desc, state)
+		}
+
+		desc = "The monitoring page counts the breakers in each state"
+		if response, err := http.Get(relayService.HttpUrl() + relay.MonitorPath + "circuit-breakers"); err != nil {
+			t.Errorf("Test '%v': Error GETing breaker summary: %v", desc, err)
+		} else {
+			var summary map[string]int
+			err := json.NewDecoder(response.Body).Decode(&summary)
+			response.Body.Close()
+			if err != nil || summary["open"] != 1 || summary["closed"] != 0 {
+				t.Errorf("Test '%v': Unexpected breaker summary: %v %v", desc, summary, err)
+			}
+		}
+
+		desc = "The breaker closes once the target recovers"
+		healthy.Store(true)
+		time.Sleep(150 * time.Millisecond)
+		if status, _ := getStatus(desc, relayService); status != 200 {
+			t.Errorf("Test '%v': Expected 200 response, got %v", desc, status)
+		}
+		if state := getBreakerState(desc, relayService); state != "closed" {
+			t.Errorf("Test '%v': Expected closed breaker, got %v", desc, state)
+		}
+	})
+
+	config = fmt.Sprintf(`
+relay:
+  target: %v
+upstream:
+  circuit-breaker:
+    min-requests: 1
+    latency-threshold: 10ms
+    open-duration: 1m
+`, target.URL)
+
+	test.WithRelay(t, config, nil, func(relayService *relay.Service) {
+		desc := "Slow requests open the breaker"
+		healthy.Store(true)
+		slow.Store(true)
+		if status, _ := getStatus(desc, relayService); status != 200 {
+			t.Errorf("Test '%v': Expected 200 response, got %v", desc, status)
+		}
+		if status, _ := getStatus(desc, relayService); status != 503 {
+			t.Errorf("Test '%v': Expected 503 response, got %v", desc, status)
+		}
+	})
+}
+
+func TestUpstreamCircuitBreakerEviction(t *testing.T) {
+	newTarget := func() *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			response.Write([]byte("OK"))
+		}))
+	}
+	target := newTarget()
+	defer target.Close()
+	otherTarget := newTarget()
+	defer otherTarget.Close()
+
+	// Redirect the first request to the other target.
+	var redirected atomic.Bool
+	plugins := []traffic.PluginFactory{
+		test_interceptor_plugin.NewFactoryWithListener(func(request *http.Request) {
+			if redirected.CompareAndSwap(false, true) {
+				request.URL.Host = otherTarget.Listener.Addr().String()
+				request.Host = request.URL.Host
+			}
+		}),
+	}
+
+	config := fmt.Sprintf(`
+relay:
+  target: %v
+upstream:
+  circuit-breaker:
+    window: 50ms
+admin:
+  address: localhost:0
+  token: s3cret
+test-interceptor:
+`, target.URL)
+
+	test.WithRelay(t, config, plugins, func(relayService *relay.Service) {
+		getBreakerHosts := func() []string {
+			request, err := http.NewRequest("GET", relayService.AdminUrl()+"/circuit-breakers", nil)
+			if err != nil {
+				t.Errorf("Error creating request: %v", err)
+				return nil
+			}
+			request.Header.Set("Authorization", "Bearer s3cret")
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Errorf("Error GETing breaker state: %v", err)
+				return nil
+			}
+			defer response.Body.Close()
+			var statuses []traffic.CircuitBreakerStatus
+			if err := json.NewDecoder(response.Body).Decode(&statuses); err != nil {
+				t.Errorf("Error decoding breaker state: %v", err)
+			}
+			var hosts []string
+			for _, status := range statuses {
+				hosts = append(hosts, status.Host)
+			}
+			return hosts
+		}
+		get := func() {
+			response, err := http.Get(relayService.HttpUrl())
+			if err != nil {
+				t.Errorf("Error GETing: %v", err)
+				return
+			}
+			response.Body.Close()
+		}
+
+		get()
+		otherHost := otherTarget.Listener.Addr().String()
+		if hosts := getBreakerHosts(); len(hosts) != 1 || hosts[0] != otherHost {
+			t.Errorf("Expected a breaker for %v, got %v", otherHost, hosts)
+		}
+
+		// Once the other target has been idle for a whole window, its breaker
+		// is discarded.
+		time.Sleep(100 * time.Millisecond)
+		get()
+		targetHost := target.Listener.Addr().String()
+		if hosts := getBreakerHosts(); len(hosts) != 1 || hosts[0] != targetHost {
+			t.Errorf("Expected only a breaker for %v, got %v", targetHost, hosts)
+		}
+	})
+}
+
+func TestUpstreamPoolInvalidTarget(t *testing.T) {
+	configFile, err := config.NewFileFromYamlString(`relay:
+  port: 0
+  target:
+    - http://localhost:1
+    - url: http://localhost:2
+      backup: sometimes
+`)
+	if err != nil {
+		t.Fatalf("Error parsing configuration YAML: %v", err)
+	}
+
+	// The error describes the problem with the list, rather than complaining
+	// that the target isn't a string.
+	_, err = relay.ReadOptions(configFile)
+	if err == nil || !strings.Contains(err.Error(), "sometimes") {
+		t.Errorf("Expected an error about the invalid backup value, got %v", err)
+	}
+}
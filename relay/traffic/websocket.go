@@ -0,0 +1,227 @@
+package traffic
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// WebSocketDirection indicates which way a WebSocket message is travelling.
+type WebSocketDirection int
+
+const (
+	ClientToTarget WebSocketDirection = iota
+	TargetToClient
+)
+
+func (direction WebSocketDirection) String() string {
+	switch direction {
+	case ClientToTarget:
+		return "client-to-target"
+	case TargetToClient:
+		return "target-to-client"
+	default:
+		return "(unknown direction)"
+	}
+}
+
+// WebSocketMessageType is the type of a WebSocket data message. The values
+// match the corresponding WebSocket frame opcodes.
+type WebSocketMessageType int
+
+const (
+	TextMessage   WebSocketMessageType = 1
+	BinaryMessage WebSocketMessageType = 2
+)
+
+// WebSocketMessage is a complete (reassembled) WebSocket data message relayed
+// between the client and the target.
+type WebSocketMessage struct {
+	Direction WebSocketDirection
+	Type      WebSocketMessageType
+	Data      []byte
+}
+
+// WebSocketMessageAction tells the relay what to do with a WebSocket message
+// after a plugin has handled it.
+type WebSocketMessageAction int
+
+const (
+	// PassMessage relays the message, including any changes made to it.
+	PassMessage WebSocketMessageAction = iota
+
+	// DropMessage silently discards the message.
+	DropMessage
+)
+
+const (
+	wsOpcodeContinuation = 0x0
+	wsOpcodeText         = 0x1
+	wsOpcodeBinary       = 0x2
+	wsOpcodeClose        = 0x8
+
+	wsMaxControlPayload = 125
+
+	// The close status code sent to a peer that violates the protocol.
+	wsCloseProtocolError = 1002
+)
+
+var errWebSocketProtocol = errors.New("websocket protocol error")
+
+// wsFrame is a single WebSocket frame, as defined by RFC 6455 section 5.2.
+// The payload is always stored unmasked.
+type wsFrame struct {
+	fin     bool
+	rsv     byte
+	opcode  byte
+	payload []byte
+}
+
+func (frame *wsFrame) isControl() bool {
+	return frame.opcode&0x8 != 0
+}
+
+// readWebSocketFrame reads a single frame from the provided reader, unmasking
+// the payload if necessary. Frames with payloads larger than maxPayloadSize
+// are rejected, as are frames whose masking doesn't match expectMasked: frames
+// sent by clients must be masked, and frames sent by servers must not be.
+func readWebSocketFrame(reader io.Reader, maxPayloadSize int64, expectMasked bool) (*wsFrame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+
+	frame := &wsFrame{
+		fin:    header[0]&0x80 != 0,
+		rsv:    (header[0] >> 4) & 0x7,
+		opcode: header[0] & 0xF,
+	}
+	masked := header[1]&0x80 != 0
+
+	payloadLength := uint64(header[1] & 0x7F)
+	switch payloadLength {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(reader, extended[:]); err != nil {
+			return nil, err
+		}
+		payloadLength = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(reader, extended[:]); err != nil {
+			return nil, err
+		}
+		payloadLength = binary.BigEndian.Uint64(extended[:])
+	}
+
+	if masked != expectMasked {
+		return nil, fmt.Errorf("%w: unexpected frame masking", errWebSocketProtocol)
+	}
+	if frame.rsv != 0 {
+		return nil, fmt.Errorf("%w: unexpected reserved bits %x", errWebSocketProtocol, frame.rsv)
+	}
+	if frame.isControl() && (!frame.fin || payloadLength > wsMaxControlPayload) {
+		return nil, fmt.Errorf("%w: invalid control frame", errWebSocketProtocol)
+	}
+	if payloadLength > uint64(maxPayloadSize) {
+		return nil, fmt.Errorf("%w: frame payload of %v bytes is too large", errWebSocketProtocol, payloadLength)
+	}
+
+	var maskKey [4]byte
+	if masked {
+		if _, err := io.ReadFull(reader, maskKey[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	frame.payload = make([]byte, payloadLength)
+	if _, err := io.ReadFull(reader, frame.payload); err != nil {
+		return nil, err
+	}
+	if masked {
+		maskWebSocketPayload(frame.payload, maskKey)
+	}
+
+	return frame, nil
+}
+
+// writeWebSocketFrame writes a single frame to the provided writer. Frames
+// sent from the client to the target must be masked; a new random masking key
+// is generated for each such frame.
+func writeWebSocketFrame(writer io.Writer, frame *wsFrame, masked bool) error {
+	header := make([]byte, 0, 14)
+
+	firstByte := frame.opcode | frame.rsv<<4
+	if frame.fin {
+		firstByte |= 0x80
+	}
+	header = append(header, firstByte)
+
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	payloadLength := len(frame.payload)
+	switch {
+	case payloadLength <= 125:
+		header = append(header, maskBit|byte(payloadLength))
+	case payloadLength <= 0xFFFF:
+		header = append(header, maskBit|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(payloadLength))
+	default:
+		header = append(header, maskBit|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(payloadLength))
+	}
+
+	payload := frame.payload
+	if masked {
+		var maskKey [4]byte
+		if _, err := rand.Read(maskKey[:]); err != nil {
+			return err
+		}
+		header = append(header, maskKey[:]...)
+		payload = append([]byte{}, frame.payload...)
+		maskWebSocketPayload(payload, maskKey)
+	}
+
+	// Write the frame all at once, so that it isn't interleaved with a frame
+	// written to the same connection by another goroutine.
+	_, err := writer.Write(append(header, payload...))
+	return err
+}
+
+// writeWebSocketClose writes a close frame with the provided status code.
+func writeWebSocketClose(writer io.Writer, code uint16, masked bool) error {
+	return writeWebSocketFrame(writer, &wsFrame{
+		fin:     true,
+		opcode:  wsOpcodeClose,
+		payload: binary.BigEndian.AppendUint16(nil, code),
+	}, masked)
+}
+
+func maskWebSocketPayload(payload []byte, maskKey [4]byte) {
+	for i := range payload {
+		payload[i] ^= maskKey[i%4]
+	}
+}
+
+/*
+Copyright 2026 FullStory, Inc.
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of this software
+and associated documentation files (the "Software"), to deal in the Software without restriction,
+including without limitation the rights to use, copy, modify, merge, publish, distribute,
+sublicense, and/or sell copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all copies or
+substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT
+NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY,
+WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE
+SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/